@@ -18,6 +18,7 @@ import (
 	"github.com/qdm12/gosettings/reader/sources/env"
 	"github.com/qdm12/gosplash"
 	"github.com/qdm12/log"
+	"github.com/qdm12/updated/internal/catalog"
 	"github.com/qdm12/updated/internal/health"
 	"github.com/qdm12/updated/internal/run"
 	"github.com/qdm12/updated/internal/settings"
@@ -117,6 +118,11 @@ func _main(ctx context.Context, args []string, logger log.LoggerInterface,
 	}
 	logger.Info(allSettings.String())
 
+	sourcesCatalog, err := catalog.Read(allSettings.CatalogPath)
+	if err != nil {
+		return fmt.Errorf("reading sources catalog: %w", err)
+	}
+
 	shoutrrrSender, err := shoutrrr.CreateSender(allSettings.Shoutrrr.ServiceURLs...)
 	if err != nil {
 		return fmt.Errorf("setting up Shoutrrr: %w", err)
@@ -133,7 +139,7 @@ func _main(ctx context.Context, args []string, logger log.LoggerInterface,
 		return fmt.Errorf("creating health server: %w", err)
 	}
 
-	runner := run.New(allSettings, logger, shoutrrrSender, shoutrrrParams, setHealthErr)
+	runner := run.New(allSettings, sourcesCatalog, logger, shoutrrrSender, shoutrrrParams, setHealthErr)
 
 	sequence, err := goservices.NewSequence(goservices.SequenceSettings{
 		ServicesStart: []goservices.Service{healthServer, runner},
@@ -0,0 +1,321 @@
+// Package catalog loads the declarative catalog of list sources.
+package catalog
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/qdm12/gosettings"
+	"github.com/qdm12/updated/internal/constants"
+	"github.com/qdm12/updated/pkg/fetch"
+	"github.com/qdm12/updated/pkg/hostnames"
+	"github.com/qdm12/updated/pkg/ips"
+	"github.com/qdm12/updated/pkg/provenance"
+	"github.com/qdm12/updated/pkg/transform"
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed default.yaml
+var defaultCatalog []byte
+
+// Catalog is the list of block list categories to build.
+type Catalog struct {
+	// Allowlists are the URLs of allowlists applied to all categories.
+	// Each can be an HTTP(S) URL, or a file:// URL pointing to a local
+	// file or directory.
+	Allowlists []string `json:"allowlists,omitempty" yaml:"allowlists,omitempty"`
+	// Categories are the block list categories to build.
+	Categories []Category `json:"categories" yaml:"categories"`
+}
+
+// Category is a block list category, for example "malicious",
+// producing a hostnames file and an IPs file.
+type Category struct {
+	// Name is the unique name of the category.
+	Name string `json:"name" yaml:"name"`
+	// HostnamesFilename is the output filename for the hostnames list.
+	// It defaults to "<name>-hostnames.updated".
+	HostnamesFilename string `json:"hostnamesFilename,omitempty" yaml:"hostnamesFilename,omitempty"`
+	// IPsFilename is the output filename for the IPs list.
+	// It defaults to "<name>-ips.updated".
+	IPsFilename string `json:"ipsFilename,omitempty" yaml:"ipsFilename,omitempty"`
+	// IPv4Filename is the output filename for the IPv4 entries of the
+	// IPs list. It defaults to "<name>-ips-ipv4.updated".
+	IPv4Filename string `json:"ipv4Filename,omitempty" yaml:"ipv4Filename,omitempty"`
+	// IPv6Filename is the output filename for the IPv6 entries of the
+	// IPs list. It defaults to "<name>-ips-ipv6.updated".
+	IPv6Filename string `json:"ipv6Filename,omitempty" yaml:"ipv6Filename,omitempty"`
+	// ResolveHostnames indicates whether to resolve the hostnames of the
+	// category to add their IP addresses to the IPs list. It defaults
+	// to the program wide setting if left unset.
+	ResolveHostnames *bool `json:"resolveHostnames,omitempty" yaml:"resolveHostnames,omitempty"`
+	// Allowlists are the URLs of allowlists applied to this category
+	// only, in addition to the global allowlists. Each can be an HTTP(S)
+	// URL, or a file:// URL pointing to a local file or directory.
+	Allowlists []string `json:"allowlists,omitempty" yaml:"allowlists,omitempty"`
+	// MinHostnames is the minimum number of hostnames of the hostnames
+	// list, below which the list is held back and the previous one kept.
+	MinHostnames uint `json:"minHostnames,omitempty" yaml:"minHostnames,omitempty"`
+	// MinIPs is the minimum number of entries of the IPs list, below
+	// which the list is held back and the previous one kept.
+	MinIPs uint `json:"minIPs,omitempty" yaml:"minIPs,omitempty"`
+	// Outputs are additional output files of the category in
+	// formats ready to use by DNS servers and blockers.
+	Outputs []Output `json:"outputs,omitempty" yaml:"outputs,omitempty"`
+	// Sources are the hostnames and IPs sources of the category.
+	Sources []Source `json:"sources" yaml:"sources"`
+}
+
+// Source is a single list source.
+type Source struct {
+	// URL is the address to fetch the list from. It can be an HTTP(S)
+	// URL, or a file:// URL pointing to a local file or directory.
+	URL string `json:"url" yaml:"url"`
+	// Type is the type of entries of the source, and can be
+	// [TypeHostnames] or [TypeIPs].
+	Type string `json:"type" yaml:"type"`
+	// Format is the format of the source, such as "hosts" or "adblock",
+	// and defaults to "plain" if left empty.
+	Format string `json:"format,omitempty" yaml:"format,omitempty"`
+	// Members are the [path.Match] patterns of the member files to read
+	// if the source is a zip or tar archive, or of the file paths relative
+	// to the directory if the source is a local directory. All the files
+	// are read if left empty.
+	Members []string `json:"members,omitempty" yaml:"members,omitempty"`
+	// Optional indicates the source failure policy. A required source
+	// failing fails its category, whereas an optional source failing
+	// is replaced by its last known good entries.
+	Optional bool `json:"optional,omitempty" yaml:"optional,omitempty"`
+	// Transforms are the line transforms applied in order to each line,
+	// before the line is parsed according to the format.
+	Transforms []transform.Step `json:"transforms,omitempty" yaml:"transforms,omitempty"`
+
+	pipeline transform.Pipeline
+}
+
+const (
+	// TypeHostnames is the source type for hostnames lists.
+	TypeHostnames = "hostnames"
+	// TypeIPs is the source type for IP addresses and CIDRs lists.
+	TypeIPs = "ips"
+)
+
+// Read reads the catalog from the file path given, or returns
+// the default embedded catalog if the file path is empty.
+// JSON is used for files with a .json extension, and YAML otherwise.
+// The catalog returned is validated.
+func Read(path string) (catalog Catalog, err error) {
+	data := defaultCatalog
+	if path != "" {
+		data, err = os.ReadFile(path) //nolint:gosec
+		if err != nil {
+			return Catalog{}, fmt.Errorf("reading catalog file: %w", err)
+		}
+	}
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&catalog)
+	} else {
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&catalog)
+	}
+	if err != nil {
+		return Catalog{}, fmt.Errorf("decoding catalog: %w", err)
+	}
+
+	err = catalog.validateAndCompile()
+	if err != nil {
+		return Catalog{}, fmt.Errorf("validating catalog: %w", err)
+	}
+
+	return catalog, nil
+}
+
+var (
+	regexCategoryName = regexp.MustCompile(`^[a-z0-9][a-z0-9\-_]*$`)
+
+	ErrCategoryNameNotValid  = errors.New("category name is not valid")
+	ErrCategoryNameDuplicate = errors.New("category name is duplicated")
+	ErrFilenameNotValid      = errors.New("filename is not valid")
+	ErrFilenameDuplicate     = errors.New("filename is duplicated")
+	ErrSourceURLEmpty        = errors.New("source URL is empty")
+	ErrSourceURLNotValid     = errors.New("source URL is not valid")
+	ErrSourceTypeNotValid    = errors.New("source type is not valid")
+	ErrSourceMemberNotValid  = errors.New("source archive member pattern is not valid")
+	ErrAllowlistURLNotValid  = errors.New("allowlist URL is not valid")
+)
+
+func (c *Catalog) validateAndCompile() (err error) {
+	err = validateAllowlists(c.Allowlists)
+	if err != nil {
+		return err
+	}
+
+	names := make(map[string]struct{}, len(c.Categories))
+	filenames := map[string]struct{}{
+		constants.NamedRootFilename:   {},
+		constants.RootAnchorsFilename: {},
+		constants.RootKeyFilename:     {},
+		constants.ManifestFilename:    {},
+	}
+	for i := range c.Categories {
+		category := &c.Categories[i]
+		category.setDefaults()
+
+		err = category.validateAndCompile()
+		if err != nil {
+			return fmt.Errorf("category %d: %w", i+1, err)
+		}
+
+		if _, exists := names[category.Name]; exists {
+			return fmt.Errorf("%w: %s", ErrCategoryNameDuplicate, category.Name)
+		}
+		names[category.Name] = struct{}{}
+
+		for _, filename := range category.Filenames() {
+			if _, exists := filenames[filename]; exists {
+				return fmt.Errorf("%w: %s", ErrFilenameDuplicate, filename)
+			}
+			filenames[filename] = struct{}{}
+		}
+	}
+	return nil
+}
+
+func (c *Category) setDefaults() {
+	c.HostnamesFilename = gosettings.DefaultComparable(c.HostnamesFilename, c.Name+"-hostnames.updated")
+	c.IPsFilename = gosettings.DefaultComparable(c.IPsFilename, c.Name+"-ips.updated")
+	c.IPv4Filename = gosettings.DefaultComparable(c.IPv4Filename, c.Name+"-ips-ipv4.updated")
+	c.IPv6Filename = gosettings.DefaultComparable(c.IPv6Filename, c.Name+"-ips-ipv6.updated")
+	for i := range c.Outputs {
+		c.Outputs[i].setDefaults(c.Name)
+	}
+}
+
+// Filenames returns the filenames of all the output files of the category,
+// including the provenance sidecar files of its hostnames and IPs lists.
+func (c Category) Filenames() (filenames []string) {
+	filenames = make([]string, 0, 6+len(c.Outputs)) //nolint:mnd
+	filenames = append(filenames, c.HostnamesFilename, c.IPsFilename,
+		c.IPv4Filename, c.IPv6Filename,
+		provenance.SidecarPath(c.HostnamesFilename), provenance.SidecarPath(c.IPsFilename))
+	for _, output := range c.Outputs {
+		filenames = append(filenames, output.Filename)
+	}
+	return filenames
+}
+
+func (c *Category) validateAndCompile() (err error) {
+	if !regexCategoryName.MatchString(c.Name) {
+		return fmt.Errorf("%w: %q does not match regex %q",
+			ErrCategoryNameNotValid, c.Name, regexCategoryName)
+	}
+
+	for i := range c.Outputs {
+		err = c.Outputs[i].validateAndCompile()
+		if err != nil {
+			return fmt.Errorf("output %d: %w", i+1, err)
+		}
+	}
+
+	for _, filename := range c.Filenames() {
+		if filename == "" || filename != filepath.Base(filename) || filename == "." || filename == ".." {
+			return fmt.Errorf("%w: %q for category %s", ErrFilenameNotValid, filename, c.Name)
+		}
+	}
+
+	for i := range c.Sources {
+		err = c.Sources[i].validateAndCompile()
+		if err != nil {
+			return fmt.Errorf("source %d: %w", i+1, err)
+		}
+	}
+
+	return validateAllowlists(c.Allowlists)
+}
+
+func (s *Source) validateAndCompile() (err error) {
+	switch {
+	case s.URL == "":
+		return ErrSourceURLEmpty
+	case !isURLSupported(s.URL):
+		return fmt.Errorf("%w: %s must start with http://, https:// or %s",
+			ErrSourceURLNotValid, s.URL, fetch.FileScheme)
+	case s.Type != TypeHostnames && s.Type != TypeIPs:
+		return fmt.Errorf("%w: %q for %s must be one of %q or %q",
+			ErrSourceTypeNotValid, s.Type, s.URL, TypeHostnames, TypeIPs)
+	}
+
+	for _, member := range s.Members {
+		_, err = path.Match(member, "")
+		if err != nil {
+			return fmt.Errorf("%w: %q for %s", ErrSourceMemberNotValid, member, s.URL)
+		}
+	}
+
+	s.pipeline, err = transform.Compile(s.Transforms)
+	if err != nil {
+		return fmt.Errorf("transforms for %s: %w", s.URL, err)
+	}
+	return nil
+}
+
+func validateAllowlists(urls []string) error {
+	for _, url := range urls {
+		if !isURLSupported(url) {
+			return fmt.Errorf("%w: %q must start with http://, https:// or %s",
+				ErrAllowlistURLNotValid, url, fetch.FileScheme)
+		}
+	}
+	return nil
+}
+
+func isURLSupported(url string) bool {
+	return strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") ||
+		strings.HasPrefix(url, fetch.FileScheme)
+}
+
+// HostnamesSources returns the hostnames sources of the category.
+func (c Category) HostnamesSources() (sources []hostnames.Source) {
+	for _, source := range c.Sources {
+		if source.Type != TypeHostnames {
+			continue
+		}
+		sources = append(sources, hostnames.Source{
+			URL:       source.URL,
+			Format:    source.Format,
+			Members:   source.Members,
+			Transform: source.pipeline,
+			Optional:  source.Optional,
+		})
+	}
+	return sources
+}
+
+// IPsSources returns the IP sources of the category.
+func (c Category) IPsSources() (sources []ips.Source) {
+	for _, source := range c.Sources {
+		if source.Type != TypeIPs {
+			continue
+		}
+		sources = append(sources, ips.Source{
+			URL:       source.URL,
+			Format:    source.Format,
+			Members:   source.Members,
+			Transform: source.pipeline,
+			Optional:  source.Optional,
+		})
+	}
+	return sources
+}
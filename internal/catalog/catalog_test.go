@@ -0,0 +1,117 @@
+package catalog
+
+import (
+	"testing"
+
+	"github.com/qdm12/updated/pkg/rpz"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Read_default(t *testing.T) {
+	t.Parallel()
+
+	catalog, err := Read("")
+	require.NoError(t, err)
+
+	require.Len(t, catalog.Categories, 3)
+
+	malicious := catalog.Categories[0]
+	assert.Equal(t, "malicious", malicious.Name)
+	assert.Equal(t, "malicious-hostnames.updated", malicious.HostnamesFilename)
+	assert.Equal(t, "malicious-ips.updated", malicious.IPsFilename)
+	assert.Equal(t, "malicious-ips-ipv4.updated", malicious.IPv4Filename)
+	assert.Equal(t, "malicious-ips-ipv6.updated", malicious.IPv6Filename)
+	assert.Nil(t, malicious.ResolveHostnames)
+	assert.Len(t, malicious.HostnamesSources(), 4)
+	assert.Len(t, malicious.IPsSources(), 2)
+
+	ads := catalog.Categories[1]
+	assert.Equal(t, "ads", ads.Name)
+	assert.Len(t, ads.HostnamesSources(), 7)
+	assert.Empty(t, ads.IPsSources())
+
+	surveillance := catalog.Categories[2]
+	assert.Equal(t, "surveillance", surveillance.Name)
+	assert.Len(t, surveillance.HostnamesSources(), 1)
+}
+
+func Test_Category_outputs(t *testing.T) {
+	t.Parallel()
+
+	category := Category{
+		Name: "ads",
+		Outputs: []Output{
+			{Format: OutputUnbound},
+			{Format: OutputHosts, Filename: "hosts"},
+		},
+	}
+	category.setDefaults()
+	err := category.validateAndCompile()
+	require.NoError(t, err)
+	expected := []string{"ads-hostnames.updated", "ads-ips.updated", "ads-ips-ipv4.updated",
+		"ads-ips-ipv6.updated", "ads-hostnames.sources.json", "ads-ips.sources.json",
+		"ads-unbound.conf", "hosts"}
+	assert.Equal(t, expected, category.Filenames())
+
+	category.Outputs = []Output{{Format: OutputRPZ, Action: "redirect", Redirect: "0.0.0.0"}}
+	category.setDefaults()
+	err = category.validateAndCompile()
+	require.NoError(t, err)
+	assert.Equal(t, "ads-rpz.zone", category.Outputs[0].Filename)
+	assert.Equal(t, rpz.Policy{Action: rpz.ActionRedirect, Redirect: "0.0.0.0"},
+		category.Outputs[0].RPZPolicy())
+
+	category.Outputs = []Output{{Format: OutputRPZ, Action: "redirect"}}
+	category.setDefaults()
+	err = category.validateAndCompile()
+	assert.ErrorIs(t, err, rpz.ErrRedirectMissing)
+
+	category.Outputs = []Output{{Format: OutputIpset, Family: FamilyIPv6}, {Format: OutputNftables}}
+	category.setDefaults()
+	err = category.validateAndCompile()
+	require.NoError(t, err)
+	assert.Equal(t, Output{Format: OutputIpset, Filename: "ads-ipv6-ipset.restore",
+		Family: FamilyIPv6, SetName: "ads"}, category.Outputs[0])
+	assert.Equal(t, "ads-nftables.conf", category.Outputs[1].Filename)
+
+	category.Outputs = []Output{{Format: OutputHosts, Family: FamilyIPv4}}
+	category.setDefaults()
+	err = category.validateAndCompile()
+	assert.ErrorIs(t, err, ErrOutputSetUnused)
+
+	category.Outputs = []Output{{Format: "bind"}}
+	category.setDefaults()
+	err = category.validateAndCompile()
+	assert.ErrorIs(t, err, ErrOutputFormatNotValid)
+	assert.EqualError(t, err, `output 1: output format is not valid: "bind" must be one of `+
+		"adblock, dnsmasq, domains, hosts, ipset, nftables, rpz, unbound")
+}
+
+func Test_Category_allowlists(t *testing.T) {
+	t.Parallel()
+
+	category := Category{
+		Name:       "ads",
+		Allowlists: []string{"https://example.com/allow.txt", "file:///allow.txt"},
+	}
+	category.setDefaults()
+	err := category.validateAndCompile()
+	require.NoError(t, err)
+
+	category.Allowlists = []string{"/allow.txt"}
+	err = category.validateAndCompile()
+	assert.ErrorIs(t, err, ErrAllowlistURLNotValid)
+}
+
+func Test_Catalog_sidecarFilenameDuplicate(t *testing.T) {
+	t.Parallel()
+
+	catalog := Catalog{Categories: []Category{{
+		Name:              "ads",
+		HostnamesFilename: "ads.txt",
+		IPsFilename:       "ads.list",
+	}}}
+	err := catalog.validateAndCompile()
+	assert.ErrorIs(t, err, ErrFilenameDuplicate)
+}
@@ -0,0 +1,178 @@
+package catalog
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/qdm12/updated/pkg/rpz"
+)
+
+// Output is an additional output file of a category, written in a
+// format ready to use by a DNS server, blocker or firewall.
+type Output struct {
+	// Format is the format of the output, such as [OutputUnbound].
+	Format string `json:"format" yaml:"format"`
+	// Filename is the output filename. It defaults to a filename
+	// depending on the category name and the format, for example
+	// "malicious-unbound.conf".
+	Filename string `json:"filename,omitempty" yaml:"filename,omitempty"`
+	// Action is the policy action of an [OutputRPZ] output, and can be
+	// "nxdomain", "nodata" or "redirect". It defaults to "nxdomain".
+	Action string `json:"action,omitempty" yaml:"action,omitempty"`
+	// Redirect is the IP address or hostname to redirect to for an
+	// [OutputRPZ] output with the "redirect" action.
+	Redirect string `json:"redirect,omitempty" yaml:"redirect,omitempty"`
+	// Family restricts an [OutputNftables] or [OutputIpset] output to
+	// the "ipv4" or "ipv6" addresses only. Both families are written,
+	// in one set each, if left empty.
+	Family string `json:"family,omitempty" yaml:"family,omitempty"`
+	// SetName is the base name of the sets of an [OutputNftables] or
+	// [OutputIpset] output, suffixed with "_ipv4" or "_ipv6" for each
+	// family. It defaults to the category name with hyphens replaced
+	// by underscores.
+	SetName string `json:"setName,omitempty" yaml:"setName,omitempty"`
+
+	policy rpz.Policy
+}
+
+const (
+	// OutputUnbound is the output format for Unbound configuration
+	// files with lines such as `local-zone: "a.com" always_nxdomain`.
+	OutputUnbound = "unbound"
+	// OutputDnsmasq is the output format for dnsmasq configuration
+	// files with lines such as "address=/a.com/#".
+	OutputDnsmasq = "dnsmasq"
+	// OutputHosts is the output format for hosts files with
+	// lines such as "0.0.0.0 a.com".
+	OutputHosts = "hosts"
+	// OutputAdblock is the output format for AdBlock lists with
+	// lines such as "||a.com^".
+	OutputAdblock = "adblock"
+	// OutputDomains is the output format for plain domain lists
+	// with one hostname per line.
+	OutputDomains = "domains"
+	// OutputRPZ is the output format for response policy zone files,
+	// with triggers for both the hostnames and the IPs lists.
+	OutputRPZ = "rpz"
+	// OutputNftables is the output format for nftables set definitions
+	// of the IPs list, with interval flags.
+	OutputNftables = "nftables"
+	// OutputIpset is the output format for `ipset restore` files of
+	// the IPs list, with hash:net sets.
+	OutputIpset = "ipset"
+)
+
+const (
+	// FamilyIPv4 restricts a firewall output to IPv4 addresses.
+	FamilyIPv4 = "ipv4"
+	// FamilyIPv6 restricts a firewall output to IPv6 addresses.
+	FamilyIPv6 = "ipv6"
+)
+
+// outputDefaultSuffixes maps each output format to the suffix
+// appended to the category name to form the default filename.
+func outputDefaultSuffixes() map[string]string {
+	return map[string]string{
+		OutputUnbound:  "-unbound.conf",
+		OutputDnsmasq:  "-dnsmasq.conf",
+		OutputHosts:    "-hosts",
+		OutputAdblock:  "-adblock.txt",
+		OutputDomains:  "-domains.txt",
+		OutputRPZ:      "-rpz.zone",
+		OutputNftables: "-nftables.conf",
+		OutputIpset:    "-ipset.restore",
+	}
+}
+
+// IsFirewall returns true if the output is a firewall sets output.
+func (o Output) IsFirewall() bool {
+	return o.Format == OutputNftables || o.Format == OutputIpset
+}
+
+// RPZPolicy returns the policy of an [OutputRPZ] output.
+func (o Output) RPZPolicy() rpz.Policy {
+	return o.policy
+}
+
+var (
+	ErrOutputFormatNotValid  = errors.New("output format is not valid")
+	ErrOutputPolicyUnused    = errors.New("output policy is set but format is not rpz")
+	ErrOutputSetUnused       = errors.New("output family or set name is set but format is not a firewall format")
+	ErrOutputFamilyNotValid  = errors.New("output family is not valid")
+	ErrOutputSetNameNotValid = errors.New("output set name is not valid")
+
+	regexSetName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// maxIpsetSetNameLength is the maximum length of a set name, so that
+// the ipset set names with their family and temporary suffixes, such
+// as "name_ipv4_tmp", fit in the 31 characters limit of ipset.
+const maxIpsetSetNameLength = 31 - len("_ipv4_tmp")
+
+func (o *Output) setDefaults(categoryName string) {
+	if o.Format == OutputRPZ && o.Action == "" {
+		o.Action = rpz.ActionNXDOMAIN.String()
+	}
+	if o.IsFirewall() && o.SetName == "" {
+		o.SetName = strings.ReplaceAll(categoryName, "-", "_")
+	}
+	if o.Filename != "" {
+		return
+	}
+	suffix, ok := outputDefaultSuffixes()[o.Format]
+	if !ok {
+		return
+	}
+	if o.Family != "" {
+		suffix = "-" + o.Family + suffix
+	}
+	o.Filename = categoryName + suffix
+}
+
+func (o *Output) validateAndCompile() (err error) {
+	suffixes := outputDefaultSuffixes()
+	if _, ok := suffixes[o.Format]; !ok {
+		formats := slices.Sorted(maps.Keys(suffixes))
+		return fmt.Errorf("%w: %q must be one of %s",
+			ErrOutputFormatNotValid, o.Format, strings.Join(formats, ", "))
+	}
+
+	if o.Format != OutputRPZ && (o.Action != "" || o.Redirect != "") {
+		return fmt.Errorf("%w: %s", ErrOutputPolicyUnused, o.Format)
+	}
+
+	switch {
+	case o.IsFirewall():
+		return o.validateFirewall()
+	case o.Family != "" || o.SetName != "":
+		return fmt.Errorf("%w: %s", ErrOutputSetUnused, o.Format)
+	case o.Format != OutputRPZ:
+		return nil
+	}
+
+	o.policy.Action, err = rpz.ParseAction(o.Action)
+	if err != nil {
+		return err
+	}
+	o.policy.Redirect = o.Redirect
+	return o.policy.Validate()
+}
+
+func (o Output) validateFirewall() (err error) {
+	switch {
+	case o.Family != "" && o.Family != FamilyIPv4 && o.Family != FamilyIPv6:
+		return fmt.Errorf("%w: %q must be one of %s or %s",
+			ErrOutputFamilyNotValid, o.Family, FamilyIPv4, FamilyIPv6)
+	case !regexSetName.MatchString(o.SetName):
+		return fmt.Errorf("%w: %q does not match regex %q",
+			ErrOutputSetNameNotValid, o.SetName, regexSetName)
+	case o.Format == OutputIpset && len(o.SetName) > maxIpsetSetNameLength:
+		return fmt.Errorf("%w: %q is longer than %d characters",
+			ErrOutputSetNameNotValid, o.SetName, maxIpsetSetNameLength)
+	}
+	return nil
+}
@@ -6,13 +6,8 @@ const (
 )
 
 const (
-	NamedRootFilename             = "named.root.updated"
-	RootAnchorsFilename           = "root-anchors.xml.updated"
-	RootKeyFilename               = "root.key.updated"
-	MaliciousHostnamesFilename    = "malicious-hostnames.updated"
-	MaliciousIPsFilename          = "malicious-ips.updated"
-	AdsHostnamesFilename          = "ads-hostnames.updated"
-	AdsIPsFilename                = "ads-ips.updated"
-	SurveillanceHostnamesFilename = "surveillance-hostnames.updated"
-	SurveillanceIPsFilename       = "surveillance-ips.updated"
+	NamedRootFilename   = "named.root.updated"
+	RootAnchorsFilename = "root-anchors.xml.updated"
+	RootKeyFilename     = "root.key.updated"
+	ManifestFilename    = "manifest.json"
 )
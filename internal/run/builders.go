@@ -7,50 +7,282 @@ import (
 	"path/filepath"
 	"strings"
 
-	"github.com/qdm12/updated/internal/constants"
+	"github.com/qdm12/updated/internal/catalog"
+	"github.com/qdm12/updated/pkg/allowlist"
+	"github.com/qdm12/updated/pkg/ips"
+	"github.com/qdm12/updated/pkg/manifest"
+	"github.com/qdm12/updated/pkg/provenance"
 )
 
-func (r *Runner) buildBlockLists(ctx context.Context, buildHostnames,
-	buildIps func(ctx context.Context) ([]string, error),
-	hostnamesFilename, ipsFilename string,
+func (r *Runner) buildBlockLists(ctx context.Context, category catalog.Category,
+	allowRules []allowlist.Rule, guard *guard,
 ) error {
-	hostnames, err := buildHostnames(ctx)
+	hostnames, hostnamesIPs, allowlist, err := r.buildHostnamesList(ctx, category, allowRules, guard)
 	if err != nil {
 		return err
 	}
+	err = r.buildIPsList(ctx, category, hostnames, hostnamesIPs, allowlist, guard)
+	if err != nil {
+		return err
+	}
+	return r.writeRPZOutputs(category)
+}
 
-	hostnamesFilepath := filepath.Join(r.settings.OutputDir, hostnamesFilename)
-	err = writeLines(hostnamesFilepath, hostnames)
+// buildHostnamesList builds and writes the hostnames list of the category.
+// It returns the hostnames of the list, the public IP addresses and CIDRs
+// found in the hostnames sources, and the allowlist of the category,
+// including the hostnames explicitly allowed by the sources.
+func (r *Runner) buildHostnamesList(ctx context.Context, category catalog.Category,
+	allowRules []allowlist.Rule, guard *guard,
+) (hostnames []string, hostnamesIPs ipsInputs, categoryAllowlist *allowlist.Allowlist, err error) {
+	sources := category.HostnamesSources()
+	sourceNames := make([]string, len(sources))
+	for i, source := range sources {
+		sourceNames[i] = source.URL
+	}
+	result, err := r.hostnamesBuilder.Build(ctx, category.Name, sources, r.settings.Provenance)
 	if err != nil {
-		return fmt.Errorf("writing hostnames: %w", err)
+		return nil, ipsInputs{}, nil, err
+	}
+	hostnamesIPs = ipsInputs{
+		ips:         r.ipsBuilder.PublicIPs(result.IPs),
+		provenance:  result.IPsProvenance,
+		sourceNames: sourceNames,
 	}
 
-	IPs := []string{}
-	if *r.settings.ResolveHostnames {
-		IPs = append(IPs, r.ipsBuilder.BuildIPsFromHostnames(hostnames)...)
+	for _, stale := range result.Stale {
+		r.appendNotification(category.Name + " hostnames " + stale.String())
+	}
+
+	allowRules, err = appendSourcesAllowRules(allowRules, result.Allowed)
+	if err != nil {
+		return nil, ipsInputs{}, nil, fmt.Errorf("parsing allowed hostnames from sources: %w", err)
+	}
+	categoryAllowlist = allowlist.New(allowRules)
+
+	hostnames, counts := categoryAllowlist.FilterHostnames(result.Hostnames)
+	r.logAllowlistCounts(category.Name+" hostnames", counts)
+
+	filename := category.HostnamesFilename
+	previous, written, err := r.writeGuardedLines(guard, filename, hostnames, category.MinHostnames, false)
+	if err != nil {
+		return nil, ipsInputs{}, nil, fmt.Errorf("writing hostnames: %w", err)
 	}
-	if buildIps != nil {
-		newIPs, err := buildIps(ctx)
+
+	if written {
+		r.recordChanges(category.Name+" hostnames", filename, previous, hostnames)
+		r.recordList(category.HostnamesFilename, len(hostnames), sourceNames)
+
+		err = r.writeProvenance(r.staging.Path(filename), sourceNames, hostnames, result.Provenance)
 		if err != nil {
-			return err
+			return nil, ipsInputs{}, nil, fmt.Errorf("writing hostnames provenance: %w", err)
+		}
+
+		err = r.writeHostnamesOutputs(category, hostnames, sourceNames)
+		if err != nil {
+			return nil, ipsInputs{}, nil, err
 		}
-		IPs = append(IPs, newIPs...)
 	}
-	IPs, removedCount, warnings := r.ipsBuilder.CleanIPs(IPs)
-	for _, warning := range warnings {
-		r.logger.Warn(warning)
+
+	return hostnames, hostnamesIPs, categoryAllowlist, nil
+}
+
+// buildIPsList builds and writes the IPs list of the category, adding
+// the IP addresses and CIDRs found in its hostnames sources, and resolving
+// the hostnames given if enabled for the category.
+func (r *Runner) buildIPsList(ctx context.Context, category catalog.Category,
+	hostnames []string, hostnamesIPs ipsInputs, categoryAllowlist *allowlist.Allowlist, guard *guard,
+) (err error) {
+	sources := category.IPsSources()
+	inputs := ipsInputs{sourceNames: make([]string, len(sources))}
+	for i, source := range sources {
+		inputs.sourceNames[i] = source.URL
+	}
+	inputs.ips, inputs.provenance, err = r.buildIPsSources(ctx, category.Name, sources)
+	if err != nil {
+		return err
 	}
-	r.logger.Infof("Trimmed down %d IP address lines", removedCount)
 
-	ipsFilepath := filepath.Join(r.settings.OutputDir, ipsFilename)
-	err = writeLines(ipsFilepath, IPs)
+	if len(hostnamesIPs.ips) > 0 {
+		inputs.add(hostnamesIPs)
+	}
+	if r.resolveHostnames(category) {
+		resolvedIPs, err := r.resolveIPs(ctx, category.Name, hostnames)
+		if err != nil {
+			return err
+		}
+		inputs.add(r.resolvedInputs(resolvedIPs))
+	}
+
+	// Filter IPs before cleaning them, so allowed IP addresses
+	// are not aggregated into CIDRs blocking them.
+	IPs, counts := categoryAllowlist.FilterIPs(inputs.ips)
+	r.logAllowlistCounts(category.Name+" IPs", counts)
+	IPs, ipsProvenance := r.cleanIPs(category.Name, IPs, inputs.provenance)
+	sourceNames := inputs.sourceNames
+
+	previous, written, err := r.writeGuardedLines(guard, category.IPsFilename, IPs, category.MinIPs, true)
 	if err != nil {
 		return fmt.Errorf("writing IPs: %w", err)
 	}
 
+	if written {
+		r.recordChanges(category.Name+" IPs", category.IPsFilename, previous, IPs)
+		r.recordList(category.IPsFilename, len(IPs), sourceNames)
+
+		err = r.writeProvenance(r.staging.Path(category.IPsFilename), sourceNames, IPs, ipsProvenance)
+		if err != nil {
+			return fmt.Errorf("writing IPs provenance: %w", err)
+		}
+
+		err = r.writeFirewallOutputs(category, IPs, sourceNames)
+		if err != nil {
+			return err
+		}
+
+		err = r.writeIPsFamilies(category, IPs, sourceNames)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// resolveHostnames returns true if the hostnames of the category
+// given should be resolved to add their IP addresses to its IPs.
+func (r *Runner) resolveHostnames(category catalog.Category) bool {
+	if category.ResolveHostnames != nil {
+		return *category.ResolveHostnames
+	}
+	return *r.settings.ResolveHostnames
+}
+
+// writeIPsFamilies writes the IPv4 and IPv6 entries of the IPs list
+// given to their own files, in the staging directory.
+func (r *Runner) writeIPsFamilies(category catalog.Category, IPs, sourceNames []string) error {
+	ipv4, ipv6 := ips.SplitFamilies(IPs)
+	files := []struct {
+		filename string
+		entries  []string
+	}{
+		{filename: category.IPv4Filename, entries: ipv4},
+		{filename: category.IPv6Filename, entries: ipv6},
+	}
+	for _, file := range files {
+		err := writeLines(r.staging.Path(file.filename), file.entries)
+		if err != nil {
+			return fmt.Errorf("writing %s: %w", file.filename, err)
+		}
+		r.recordList(file.filename, len(file.entries), sourceNames)
+	}
+	return nil
+}
+
+// buildIPsSources builds the IP addresses and CIDRs of the sources given.
+func (r *Runner) buildIPsSources(ctx context.Context, categoryName string, sources []ips.Source) (
+	IPs []string, provenances map[string]provenance.Entry, err error,
+) {
+	IPs = []string{}
+	if len(sources) == 0 {
+		return IPs, nil, nil
+	}
+
+	result, err := r.ipsBuilder.Build(ctx, categoryName, sources, r.settings.Provenance)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, stale := range result.Stale {
+		r.appendNotification(categoryName + " IPs " + stale.String())
+	}
+	return append(IPs, result.IPs...), result.Provenance, nil
+}
+
+// cleanIPs cleans and aggregates the IP addresses and CIDRs given, logging
+// the number of entries removed by each step, and returns them with their
+// provenance attributed to the cleaned entries.
+func (r *Runner) cleanIPs(categoryName string, IPs []string,
+	provenances map[string]provenance.Entry,
+) (cleanIPs []string, cleanProvenances map[string]provenance.Entry) {
+	cleanIPs, stats := r.ipsBuilder.CleanIPs(IPs, r.settings.Aggregation.Options())
+	if stats.Invalid > 0 {
+		r.logger.Warn(fmt.Sprintf("%s IPs: removed %d invalid entries", categoryName, stats.Invalid))
+	}
+	r.logger.Infof("%s IPs: removed %d duplicate, %d covered by CIDRs and %d aggregated entries",
+		categoryName, stats.Duplicates, stats.Covered, stats.Aggregated)
+	if provenances != nil {
+		provenances = ips.AttributeProvenance(cleanIPs, provenances)
+	}
+	return cleanIPs, provenances
+}
+
+// writeProvenance writes the provenance sidecar file of the list file
+// path given, if provenance tracking is enabled.
+func (r *Runner) writeProvenance(listPath string, sourceNames, entries []string,
+	provenances map[string]provenance.Entry,
+) error {
+	if r.settings.Provenance == provenance.ModeOff {
+		return nil
+	}
+	sidecarPath := provenance.SidecarPath(listPath)
+	err := provenance.WriteSidecar(sidecarPath, sourceNames, entries, provenances)
+	if err != nil {
+		return err
+	}
+	r.recordArtifact(filepath.Base(sidecarPath), manifest.Metadata{Sources: sourceNames})
 	return nil
 }
 
+// ipsInputs are the IP addresses and CIDRs of an IPs list before they are
+// filtered and cleaned, with their provenance indexing their source names.
+type ipsInputs struct {
+	ips         []string
+	provenance  map[string]provenance.Entry
+	sourceNames []string
+}
+
+// add adds the IP addresses and CIDRs of other, with their provenance
+// shifted to index the source names of other appended to the source names.
+func (in *ipsInputs) add(other ipsInputs) {
+	in.ips = append(in.ips, other.ips...)
+	if other.provenance != nil {
+		if in.provenance == nil {
+			in.provenance = make(map[string]provenance.Entry, len(other.provenance))
+		}
+		offset := len(in.sourceNames)
+		for ip, otherEntry := range other.provenance {
+			shifted := provenance.Entry{Lines: otherEntry.Lines}
+			for _, sourceIndex := range otherEntry.Sources {
+				shifted.Sources = append(shifted.Sources, offset+sourceIndex)
+			}
+			entry := in.provenance[ip]
+			entry.Merge(shifted)
+			in.provenance[ip] = entry
+		}
+	}
+	in.sourceNames = append(in.sourceNames, other.sourceNames...)
+}
+
+// resolvedSourceName is the source name used in provenance sidecar
+// files for IP addresses obtained by resolving hostnames.
+const resolvedSourceName = "resolved hostnames"
+
+// resolvedInputs returns the IPs inputs of the resolved IP addresses
+// given, recording their provenance if provenance tracking is on.
+func (r *Runner) resolvedInputs(resolvedIPs []string) (inputs ipsInputs) {
+	inputs = ipsInputs{ips: resolvedIPs, sourceNames: []string{resolvedSourceName}}
+	if r.settings.Provenance == provenance.ModeOff {
+		return inputs
+	}
+	inputs.provenance = make(map[string]provenance.Entry, len(resolvedIPs))
+	for _, ip := range resolvedIPs {
+		entry := inputs.provenance[ip]
+		entry.Add(0, "")
+		inputs.provenance[ip] = entry
+	}
+	return inputs
+}
+
 func writeLines(filePath string, lines []string) error {
 	const perms = 0o600
 	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perms) //nolint:gosec
@@ -72,29 +304,58 @@ func writeLines(filePath string, lines []string) error {
 	return nil
 }
 
-func (r *Runner) buildMalicious(ctx context.Context) error {
-	return r.buildBlockLists(ctx,
-		r.hostnamesBuilder.BuildMalicious,
-		r.ipsBuilder.BuildMalicious,
-		constants.MaliciousHostnamesFilename,
-		constants.MaliciousIPsFilename,
-	)
+func (r *Runner) buildCategory(ctx context.Context, category catalog.Category,
+	globalAllowRules []allowlist.Rule, guard *guard,
+) error {
+	allowRules, err := r.fetchAllowRules(ctx, category.Allowlists)
+	if err != nil {
+		return fmt.Errorf("fetching %s allowlists: %w", category.Name, err)
+	}
+	allowRules = append(allowRules, globalAllowRules...)
+
+	err = r.buildBlockLists(ctx, category, allowRules, guard)
+	if err != nil {
+		return fmt.Errorf("building %s block lists: %w", category.Name, err)
+	}
+	return nil
+}
+
+func (r *Runner) fetchAllowRules(ctx context.Context, locations []string) (
+	rules []allowlist.Rule, err error,
+) {
+	for _, location := range locations {
+		newRules, err := allowlist.Fetch(ctx, r.client, location)
+		if err != nil {
+			return nil, fmt.Errorf("fetching allowlist %s: %w", location, err)
+		}
+		rules = append(rules, newRules...)
+	}
+	return rules, nil
 }
 
-func (r *Runner) buildAds(ctx context.Context) error {
-	return r.buildBlockLists(ctx,
-		r.hostnamesBuilder.BuildAds,
-		nil,
-		constants.AdsHostnamesFilename,
-		constants.AdsIPsFilename,
-	)
+// appendSourcesAllowRules appends rules allowing each of the hostnames
+// given and their subdomains, as explicitly allowed by sources.
+func appendSourcesAllowRules(rules []allowlist.Rule, allowedHostnames []string) (
+	[]allowlist.Rule, error,
+) {
+	for _, hostname := range allowedHostnames {
+		for _, ruleString := range []string{hostname, "*." + hostname} {
+			rule, err := allowlist.ParseRule(ruleString)
+			if err != nil {
+				return nil, err
+			}
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
 }
 
-func (r *Runner) buildSurveillance(ctx context.Context) error {
-	return r.buildBlockLists(ctx,
-		r.hostnamesBuilder.BuildSurveillance,
-		nil,
-		constants.SurveillanceHostnamesFilename,
-		constants.SurveillanceIPsFilename,
-	)
+func (r *Runner) logAllowlistCounts(title string, counts []allowlist.RuleCount) {
+	for _, count := range counts {
+		if count.Count == 0 {
+			r.logger.Debugf("%s allowlist rule %q removed no entry", title, count.Rule)
+			continue
+		}
+		r.logger.Infof("%s allowlist rule %q removed %d entries", title, count.Rule, count.Count)
+	}
 }
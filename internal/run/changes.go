@@ -0,0 +1,93 @@
+package run
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/qdm12/updated/pkg/changes"
+)
+
+// changesDirname is the name of the directory in the output
+// directory where the changes of each day are written.
+const changesDirname = "changes"
+
+// recordChanges records the entries added and removed from the
+// previous lines to the current lines of the list given, to report
+// them at the end of the run. Nothing is recorded if the list had
+// no previous version or did not change.
+func (r *Runner) recordChanges(name, filename string, previous, current []string) {
+	if previous == nil {
+		return
+	}
+	list := changes.New(name, filename, previous, current)
+	if list.Empty() {
+		return
+	}
+	r.changesMu.Lock()
+	defer r.changesMu.Unlock()
+	r.changes = append(r.changes, list)
+}
+
+// resetChanges discards the changes recorded, for example
+// by a previous run which failed to publish its lists.
+func (r *Runner) resetChanges() {
+	r.changesMu.Lock()
+	defer r.changesMu.Unlock()
+	r.changes = nil
+}
+
+// sortedChanges returns the changes recorded during the run,
+// sorted by filename.
+func (r *Runner) sortedChanges() (lists []changes.List) {
+	r.changesMu.Lock()
+	lists = slices.Clone(r.changes)
+	r.changesMu.Unlock()
+	slices.SortFunc(lists, func(a, b changes.List) int {
+		return strings.Compare(a.Filename, b.Filename)
+	})
+	return lists
+}
+
+// stageChanges stages the changes file of the day with the changes
+// recorded during the run appended to it, if enabled and if there is
+// any change, so it is published together with the lists changed.
+func (r *Runner) stageChanges(now time.Time) (err error) {
+	lists := r.sortedChanges()
+	if !*r.settings.Changes.File || len(lists) == 0 {
+		return nil
+	}
+
+	filename := filepath.Join(changesDirname, now.Format("2006-01-02")+".json")
+	run := changes.Run{Time: now, Lists: lists}
+	err = changes.Append(r.staging.TargetPath(filename), r.staging.Path(filename), run)
+	if err != nil {
+		return fmt.Errorf("writing changes file: %w", err)
+	}
+	return nil
+}
+
+// reportChanges logs the changes recorded during the run and adds them
+// to the run notification. It returns a summary of the changes, one list
+// per line, which is empty if there is no change.
+func (r *Runner) reportChanges() (summary string) {
+	lists := r.sortedChanges()
+	r.resetChanges()
+	if len(lists) == 0 {
+		return ""
+	}
+
+	summaries := make([]string, len(lists))
+	details := make([]string, len(lists))
+	maxEntries := int(*r.settings.Changes.NotificationEntries) //nolint:gosec
+	for i, list := range lists {
+		summaries[i] = list.Summary()
+		r.logger.Info(summaries[i])
+		details[i] = list.Details(maxEntries)
+	}
+	r.appendNotification("Changes:\n" + strings.Join(details, "\n"))
+
+	return strings.Join(summaries, "\n")
+}
@@ -0,0 +1,104 @@
+package run
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/qdm12/updated/internal/constants"
+	"github.com/qdm12/updated/pkg/compress"
+)
+
+// compressOutputs writes to the staging directory the compressed copies
+// of each output file staged, for each of the compression formats
+// configured. Compressed copies missing for output files already
+// published, for example after enabling a format, are also staged.
+// Hidden files, such as the .git directory, temporary files, compressed
+// copies, the manifest and the files of subdirectories, such as the
+// changes files, are ignored.
+func (r *Runner) compressOutputs() (err error) {
+	formats := r.settings.Compression.Formats
+	if len(formats) == 0 {
+		return nil
+	}
+
+	staged, err := r.staging.Filenames()
+	if err != nil {
+		return fmt.Errorf("listing staged files: %w", err)
+	}
+
+	entries, err := os.ReadDir(r.settings.OutputDir)
+	if err != nil {
+		return fmt.Errorf("reading output directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || !isCompressible(name) || slices.Contains(staged, name) {
+			continue
+		}
+		for _, format := range formats {
+			extension, err := format.Extension()
+			if err != nil {
+				return err
+			}
+			_, err = os.Stat(r.staging.TargetPath(name + extension))
+			if err == nil {
+				continue
+			} else if !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			err = r.compressFile(name, r.staging.TargetPath(name), format)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, name := range staged {
+		if !isCompressible(name) {
+			continue
+		}
+		for _, format := range formats {
+			err = r.compressFile(name, r.staging.Path(name), format)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// compressFile writes the compressed copy of the file with the
+// name and path given to the staging directory.
+func (r *Runner) compressFile(name, path string, format compress.Format) (err error) {
+	extension, err := format.Extension()
+	if err != nil {
+		return err
+	}
+	compressedName := name + extension
+	err = compress.File(path, r.staging.Path(compressedName), format, r.settings.Compression.Level(format))
+	if err != nil {
+		return fmt.Errorf("compressing %s with %s: %w", name, format, err)
+	}
+	r.logger.Debugf("wrote %s", compressedName)
+	return nil
+}
+
+func isCompressible(name string) bool {
+	if strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".tmp") ||
+		name == constants.ManifestFilename || strings.ContainsRune(name, filepath.Separator) {
+		return false
+	}
+	for _, format := range compress.Formats() {
+		extension, err := format.Extension()
+		if err == nil && strings.HasSuffix(name, extension) {
+			return false
+		}
+	}
+	return true
+}
@@ -4,9 +4,10 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"path/filepath"
 
 	"github.com/qdm12/updated/internal/constants"
+	"github.com/qdm12/updated/pkg/dnscrypto"
+	"github.com/qdm12/updated/pkg/manifest"
 )
 
 func (r *Runner) buildNamedRoot(ctx context.Context) error {
@@ -16,7 +17,7 @@ func (r *Runner) buildNamedRoot(ctx context.Context) error {
 		return fmt.Errorf("downloading named root: %w", err)
 	}
 
-	filepath := filepath.Join(r.settings.OutputDir, constants.NamedRootFilename)
+	filepath := r.staging.Path(constants.NamedRootFilename)
 	const perms = 0o600
 	file, err := os.OpenFile(filepath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perms) //nolint:gosec
 	if err != nil {
@@ -34,6 +35,15 @@ func (r *Runner) buildNamedRoot(ctx context.Context) error {
 		return err
 	}
 
+	metadata := manifest.Metadata{Sources: []string{dnscrypto.NamedRootURL}}
+	if *r.settings.HexSums.NamedRootMD5 != "" {
+		metadata.PinnedChecksum = &manifest.Checksum{
+			Algorithm: "md5",
+			Value:     *r.settings.HexSums.NamedRootMD5,
+		}
+	}
+	r.recordArtifact(constants.NamedRootFilename, metadata)
+
 	return nil
 }
 
@@ -48,18 +58,30 @@ func (r *Runner) buildRootAnchorsAndKeys(ctx context.Context) error {
 		return err
 	}
 
-	xmlFilepath := filepath.Join(r.settings.OutputDir, constants.RootAnchorsFilename)
+	xmlFilepath := r.staging.Path(constants.RootAnchorsFilename)
 	err = writeFile(xmlFilepath, rootAnchorsXML)
 	if err != nil {
 		return fmt.Errorf("writing root anchors XML: %w", err)
 	}
 
-	rootKeysFilepath := filepath.Join(r.settings.OutputDir, constants.RootKeyFilename)
+	rootKeysFilepath := r.staging.Path(constants.RootKeyFilename)
 	err = writeLines(rootKeysFilepath, rootKeys)
 	if err != nil {
 		return fmt.Errorf("writing root keys: %w", err)
 	}
 
+	metadata := manifest.Metadata{Sources: []string{dnscrypto.RootAnchorsURL}}
+	if r.settings.HexSums.RootAnchorsSHA256 != "" {
+		metadata.PinnedChecksum = &manifest.Checksum{
+			Algorithm: "sha256",
+			Value:     r.settings.HexSums.RootAnchorsSHA256,
+		}
+	}
+	r.recordArtifact(constants.RootAnchorsFilename, metadata)
+	rootKeysCount := len(rootKeys)
+	metadata.Entries = &rootKeysCount
+	r.recordArtifact(constants.RootKeyFilename, metadata)
+
 	return nil
 }
 
@@ -0,0 +1,198 @@
+package run
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math/bits"
+	"net/netip"
+	"os"
+
+	"github.com/qdm12/updated/internal/catalog"
+	"github.com/qdm12/updated/pkg/ips"
+)
+
+// firewallSet is a set of non overlapping prefixes of a single family.
+type firewallSet struct {
+	name     string
+	family   string
+	prefixes []netip.Prefix
+}
+
+// writeFirewallOutputs writes the IP addresses and CIDRs given to each
+// of the firewall outputs of the category, in the staging directory.
+// Overlapping and adjacent entries are merged so the sets load without
+// overlapping elements errors. Sources are the sources of the IP addresses
+// and CIDRs, recorded for the manifest.
+func (r *Runner) writeFirewallOutputs(category catalog.Category, IPs, sources []string) error {
+	var ipv4, ipv6 []netip.Prefix
+	merged := false
+	for _, output := range category.Outputs {
+		if !output.IsFirewall() {
+			continue
+		}
+
+		if !merged {
+			var err error
+			ipv4, ipv6, err = ips.MergePrefixes(IPs)
+			if err != nil {
+				return fmt.Errorf("merging IPs: %w", err)
+			}
+			merged = true
+		}
+
+		var sets []firewallSet
+		entries := 0
+		if output.Family != catalog.FamilyIPv6 {
+			sets = append(sets, firewallSet{
+				name: output.SetName + "_ipv4", family: catalog.FamilyIPv4, prefixes: ipv4,
+			})
+			entries += len(ipv4)
+		}
+		if output.Family != catalog.FamilyIPv4 {
+			sets = append(sets, firewallSet{
+				name: output.SetName + "_ipv6", family: catalog.FamilyIPv6, prefixes: ipv6,
+			})
+			entries += len(ipv6)
+		}
+
+		write := writeNftablesSets
+		if output.Format == catalog.OutputIpset {
+			write = writeIpsetSets
+		}
+		err := writeFirewallFile(r.staging.Path(output.Filename), sets, write)
+		if err != nil {
+			return fmt.Errorf("writing %s output: %w", output.Format, err)
+		}
+		r.recordList(output.Filename, entries, sources)
+	}
+	return nil
+}
+
+func writeFirewallFile(filePath string, sets []firewallSet,
+	write func(writer *bufio.Writer, sets []firewallSet) error,
+) error {
+	const perms = 0o600
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perms) //nolint:gosec
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+	err = write(writer, sets)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// writeNftablesSets writes nftables set definitions with interval flags,
+// to be included in a table definition.
+func writeNftablesSets(writer *bufio.Writer, sets []firewallSet) error {
+	for i, set := range sets {
+		if i > 0 {
+			_ = writer.WriteByte('\n')
+		}
+		addressType := "ipv4_addr"
+		if set.family == catalog.FamilyIPv6 {
+			addressType = "ipv6_addr"
+		}
+		_, _ = fmt.Fprintf(writer, "set %s {\n\ttype %s\n\tflags interval\n", set.name, addressType)
+		if len(set.prefixes) > 0 {
+			_, _ = writer.WriteString("\telements = {\n")
+			for j, prefix := range set.prefixes {
+				_, _ = writer.WriteString("\t\t" + formatPrefix(prefix))
+				if j < len(set.prefixes)-1 {
+					_ = writer.WriteByte(',')
+				}
+				_ = writer.WriteByte('\n')
+			}
+			_, _ = writer.WriteString("\t}\n")
+		}
+		_, _ = writer.WriteString("}\n")
+	}
+	return nil
+}
+
+var (
+	errIpsetSetNameTooLong  = errors.New("ipset set name is too long")
+	errIpsetTooManyElements = errors.New("ipset set has too many elements")
+)
+
+const (
+	// ipsetMaxNameLength is the maximum length of an ipset set name.
+	ipsetMaxNameLength = 31
+	// ipsetMaxElements is the maximum number of elements of the ipset sets.
+	// It is fixed so the existing set created with the same options can be
+	// created again with -exist, since ipset refuses to create an existing
+	// set with a different maximum number of elements.
+	ipsetMaxElements = 1 << 24
+)
+
+// writeIpsetSets writes `ipset restore` commands creating a temporary
+// hash:net set for each set, filling it and swapping it with the set, so
+// the set is replaced atomically when restoring the file again. The
+// temporary set left over by an interrupted restore is destroyed first,
+// and the set is only created if it does not exist yet.
+func writeIpsetSets(writer *bufio.Writer, sets []firewallSet) error {
+	for _, set := range sets {
+		tempName := set.name + "_tmp"
+		switch {
+		case len(tempName) > ipsetMaxNameLength:
+			return fmt.Errorf("%w: %q is longer than %d characters",
+				errIpsetSetNameTooLong, tempName, ipsetMaxNameLength)
+		case len(set.prefixes) > ipsetMaxElements:
+			return fmt.Errorf("%w: %d elements for set %s exceed the maximum of %d",
+				errIpsetTooManyElements, len(set.prefixes), set.name, ipsetMaxElements)
+		}
+	}
+
+	for _, set := range sets {
+		family := "inet"
+		if set.family == catalog.FamilyIPv6 {
+			family = "inet6"
+		}
+		options := fmt.Sprintf("hash:net family %s maxelem %d", family, ipsetMaxElements)
+		tempName := set.name + "_tmp"
+
+		_, _ = fmt.Fprintf(writer, "destroy %s -exist\n", tempName)
+		_, _ = fmt.Fprintf(writer, "create %s %s hashsize %d\n", tempName, options, ipsetHashSize(len(set.prefixes)))
+		for _, prefix := range set.prefixes {
+			_, _ = writer.WriteString("add " + tempName + " " + formatPrefix(prefix) + "\n")
+		}
+		_, _ = fmt.Fprintf(writer, "create %s %s -exist\n", set.name, options)
+		_, _ = fmt.Fprintf(writer, "swap %s %s\n", tempName, set.name)
+		_, _ = fmt.Fprintf(writer, "destroy %s\n", tempName)
+	}
+	return nil
+}
+
+// ipsetHashSize returns the hash size of an ipset set for the number of
+// entries given, as a power of two no lower than the ipset default.
+// The hash size is not compared when creating an existing set with -exist,
+// so it can change from one run to the next.
+func ipsetHashSize(entries int) (hashSize uint) {
+	const defaultHashSize = 1024
+	return max(defaultHashSize, nextPowerOfTwo(uint(entries))) //nolint:gosec
+}
+
+func nextPowerOfTwo(n uint) uint {
+	if n <= 1 {
+		return 1
+	}
+	return 1 << bits.Len(n-1)
+}
+
+// formatPrefix formats the prefix as an IP address if it contains
+// a single address, and as a CIDR otherwise.
+func formatPrefix(prefix netip.Prefix) string {
+	if prefix.IsSingleIP() {
+		return prefix.Addr().String()
+	}
+	return prefix.String()
+}
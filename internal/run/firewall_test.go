@@ -0,0 +1,81 @@
+package run
+
+import (
+	"bufio"
+	"io"
+	"net/netip"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/qdm12/updated/internal/catalog"
+	"github.com/qdm12/updated/pkg/staging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_writeFirewallOutputs(t *testing.T) {
+	t.Parallel()
+
+	stagingDir, err := staging.New(t.TempDir())
+	require.NoError(t, err)
+	runner := &Runner{staging: stagingDir}
+	category := catalog.Category{
+		Outputs: []catalog.Output{
+			{Format: catalog.OutputNftables, Filename: "nft", SetName: "bad"},
+			{Format: catalog.OutputIpset, Filename: "ipset", SetName: "bad", Family: catalog.FamilyIPv4},
+		},
+	}
+	IPs := []string{"1.2.3.0/24", "1.2.3.4", "1.2.4.0/24", "5.6.7.8", "2001:db8::1"}
+
+	err = runner.writeFirewallOutputs(category, IPs, nil)
+	require.NoError(t, err)
+
+	nftables, err := os.ReadFile(stagingDir.Path("nft"))
+	require.NoError(t, err)
+	const expectedNftables = "set bad_ipv4 {\n\ttype ipv4_addr\n\tflags interval\n" +
+		"\telements = {\n\t\t1.2.3.0/24,\n\t\t1.2.4.0/24,\n\t\t5.6.7.8\n\t}\n}\n" +
+		"\nset bad_ipv6 {\n\ttype ipv6_addr\n\tflags interval\n" +
+		"\telements = {\n\t\t2001:db8::1\n\t}\n}\n"
+	assert.Equal(t, expectedNftables, string(nftables))
+
+	ipset, err := os.ReadFile(stagingDir.Path("ipset"))
+	require.NoError(t, err)
+	const expectedIpset = "destroy bad_ipv4_tmp -exist\n" +
+		"create bad_ipv4_tmp hash:net family inet maxelem 16777216 hashsize 1024\n" +
+		"add bad_ipv4_tmp 1.2.3.0/24\n" +
+		"add bad_ipv4_tmp 1.2.4.0/24\n" +
+		"add bad_ipv4_tmp 5.6.7.8\n" +
+		"create bad_ipv4 hash:net family inet maxelem 16777216 -exist\n" +
+		"swap bad_ipv4_tmp bad_ipv4\n" +
+		"destroy bad_ipv4_tmp\n"
+	assert.Equal(t, expectedIpset, string(ipset))
+}
+
+func Test_writeIpsetSets_nameTooLong(t *testing.T) {
+	t.Parallel()
+
+	sets := []firewallSet{{name: strings.Repeat("a", 23) + "_ipv4", family: catalog.FamilyIPv4}}
+	err := writeIpsetSets(bufio.NewWriter(io.Discard), sets)
+	assert.ErrorIs(t, err, errIpsetSetNameTooLong)
+}
+
+func Test_ipsetHashSize(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[int]uint{
+		0:       1024,
+		40000:   65536,
+		1000000: 1048576,
+	}
+	for entries, expected := range testCases {
+		assert.Equal(t, expected, ipsetHashSize(entries), "entries %d", entries)
+	}
+}
+
+func Test_formatPrefix(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "1.2.3.4", formatPrefix(netip.MustParsePrefix("1.2.3.4/32")))
+	assert.Equal(t, "1.2.3.0/24", formatPrefix(netip.MustParsePrefix("1.2.3.0/24")))
+}
@@ -0,0 +1,255 @@
+package run
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/qdm12/updated/internal/settings"
+	"github.com/qdm12/updated/pkg/ips"
+)
+
+// guardOverrideFilename is the name of the file in the state directory
+// overriding the guard for the next successful run. It can be empty to
+// override the guard for all outputs, or list output filenames one per line.
+const guardOverrideFilename = "guard-override"
+
+// guardStateFilename is the name of the file in the state directory
+// recording the settings the published outputs were built with.
+const guardStateFilename = "guard-state.json"
+
+// guardState is the state of the guard saved across runs.
+type guardState struct {
+	// Aggregation is the IPs aggregation of the published IPs lists.
+	Aggregation ips.Aggregation `json:"aggregation"`
+}
+
+// guard holds back outputs whose number of entries changes too much
+// compared to their previous version, or is below a minimum.
+type guard struct {
+	maxChangePercent uint
+	maxChangeCount   uint
+	// overridePath is the path of the override file.
+	overridePath string
+	// overrideAll is true if the override file is empty.
+	overrideAll bool
+	// overrides are the output filenames listed in the override file.
+	overrides map[string]struct{}
+	// statePath is the path of the state file.
+	statePath string
+	// state is the state of the current run.
+	state guardState
+	// aggregationChanged is true if the IPs aggregation changed since
+	// the last published IPs lists. It is false if the previous IPs
+	// aggregation is unknown, so the IPs lists are still compared.
+	aggregationChanged bool
+	// mutex protects aggregatedHeldBack from concurrent category jobs.
+	mutex sync.Mutex
+	// aggregatedHeldBack is true if an aggregated IPs list was held back,
+	// in which case the state is not saved, since the published IPs lists
+	// are not all built with the aggregation of the current run.
+	aggregatedHeldBack bool
+}
+
+func newGuard(settings settings.Guard, aggregation ips.Aggregation,
+	stateDir string,
+) (g *guard, err error) {
+	g = &guard{
+		maxChangePercent: *settings.MaxChangePercent,
+		maxChangeCount:   *settings.MaxChangeCount,
+		overridePath:     filepath.Join(stateDir, guardOverrideFilename),
+		statePath:        filepath.Join(stateDir, guardStateFilename),
+		state:            guardState{Aggregation: aggregation},
+	}
+
+	previousState, err := readGuardState(g.statePath)
+	if err != nil {
+		return nil, fmt.Errorf("reading guard state file: %w", err)
+	}
+	g.aggregationChanged = previousState != nil && previousState.Aggregation != aggregation
+
+	data, err := os.ReadFile(g.overridePath)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return g, nil
+	case err != nil:
+		return nil, fmt.Errorf("reading guard override file: %w", err)
+	}
+
+	g.overrides = make(map[string]struct{})
+	for line := range strings.Lines(string(data)) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		g.overrides[line] = struct{}{}
+	}
+	g.overrideAll = len(g.overrides) == 0
+	return g, nil
+}
+
+// overridden returns true if the guard is overridden for the filename given.
+func (g *guard) overridden(filename string) bool {
+	if g.overrideAll {
+		return true
+	}
+	_, ok := g.overrides[filename]
+	return ok
+}
+
+// heldBack records that the output was held back,
+// and aggregated is true if it is an aggregated IPs list.
+func (g *guard) heldBack(aggregated bool) {
+	if !aggregated {
+		return
+	}
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.aggregatedHeldBack = true
+}
+
+// finish saves the state of the guard for the next runs, unless an
+// aggregated IPs list was held back, and removes the override file,
+// if any, once the outputs are published.
+func (g *guard) finish() (err error) {
+	g.mutex.Lock()
+	saveState := !g.aggregatedHeldBack
+	g.mutex.Unlock()
+	if saveState {
+		err = g.saveState()
+		if err != nil {
+			return err
+		}
+	}
+
+	if g.overrides == nil {
+		return nil
+	}
+	err = os.Remove(g.overridePath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("removing override file: %w", err)
+	}
+	return nil
+}
+
+// saveState writes the state of the current run to the state file.
+func (g *guard) saveState() (err error) {
+	data, err := json.Marshal(g.state)
+	if err != nil {
+		return fmt.Errorf("encoding state: %w", err)
+	}
+	const permissions = 0o600
+	err = os.WriteFile(g.statePath, data, permissions)
+	if err != nil {
+		return fmt.Errorf("writing state file: %w", err)
+	}
+	return nil
+}
+
+// check returns a non empty reason if an output with the number of
+// entries given should be held back, given its previous number of entries
+// and the minimum number of entries. previous is -1 if there is no
+// previous version of the output. aggregated is true for outputs of
+// aggregated IPs, which are not compared with their previous version if
+// the aggregation changed, since their number of entries then changes
+// regardless of their sources.
+func (g *guard) check(previous, current int, minimum uint, aggregated bool) (reason string) {
+	if current < int(minimum) { //nolint:gosec
+		return fmt.Sprintf("%d entries is below the minimum of %d entries",
+			current, minimum)
+	}
+
+	if previous <= 0 || (aggregated && g.aggregationChanged) {
+		return ""
+	}
+
+	change := current - previous
+	absChange := max(change, -change)
+	direction := "more"
+	if change < 0 {
+		direction = "fewer"
+	}
+
+	if g.maxChangeCount > 0 && absChange > int(g.maxChangeCount) { //nolint:gosec
+		return fmt.Sprintf("%d entries is %d entries %s than the previous %d entries, "+
+			"exceeding the maximum change of %d entries",
+			current, absChange, direction, previous, g.maxChangeCount)
+	}
+
+	const hundred = 100
+	percent := hundred * absChange / previous
+	if g.maxChangePercent > 0 && percent > int(g.maxChangePercent) { //nolint:gosec
+		return fmt.Sprintf("%d entries is %d%% %s than the previous %d entries, "+
+			"exceeding the maximum change of %d%%",
+			current, percent, direction, previous, g.maxChangePercent)
+	}
+
+	return ""
+}
+
+// writeGuardedLines stages the lines to the output file with the
+// filename given, unless the guard holds them back compared to the
+// published file, in which case the published file is kept and
+// a notification is sent. aggregated is true if the lines are aggregated
+// IPs. It returns the lines of the published file, or nil if it does not
+// exist, and true if the lines were written.
+func (r *Runner) writeGuardedLines(guard *guard, filename string,
+	lines []string, minimum uint, aggregated bool,
+) (previousLines []string, written bool, err error) {
+	previousLines, err = readLines(r.staging.TargetPath(filename))
+	if err != nil {
+		return nil, false, fmt.Errorf("reading previous entries: %w", err)
+	}
+	previous := -1
+	if previousLines != nil {
+		previous = len(previousLines)
+	}
+
+	if aggregated && guard.aggregationChanged && previous > 0 {
+		r.logger.Infof("IPs aggregation changed since the last run, "+
+			"not comparing %s with its previous %d entries", filename, previous)
+	}
+
+	reason := guard.check(previous, len(lines), minimum, aggregated)
+	switch {
+	case reason == "":
+	case guard.overridden(filename):
+		r.logger.Warn("guard overridden for " + filename + ": " + reason)
+	default:
+		message := "holding back " + filename + ": " + reason
+		r.logger.Warn(message)
+		r.appendNotification(message)
+		guard.heldBack(aggregated)
+		return previousLines, false, nil
+	}
+
+	err = writeLines(r.staging.Path(filename), lines)
+	if err != nil {
+		return nil, false, err
+	}
+	return previousLines, true, nil
+}
+
+// readGuardState reads the guard state file at the path given,
+// and returns nil if it does not exist.
+func readGuardState(path string) (state *guardState, err error) {
+	data, err := os.ReadFile(path) //nolint:gosec
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return nil, nil //nolint:nilnil
+	case err != nil:
+		return nil, err
+	}
+
+	state = new(guardState)
+	err = json.Unmarshal(data, state)
+	if err != nil {
+		return nil, fmt.Errorf("decoding guard state: %w", err)
+	}
+	return state, nil
+}
@@ -0,0 +1,133 @@
+package run
+
+import (
+	"testing"
+
+	"github.com/qdm12/updated/internal/settings"
+	"github.com/qdm12/updated/pkg/ips"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_guard_check(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		guard      *guard
+		previous   int
+		current    int
+		minimum    uint
+		aggregated bool
+		reason     string
+	}{
+		"no previous": {
+			guard:    &guard{maxChangePercent: 50},
+			previous: -1,
+			current:  10,
+		},
+		"below minimum": {
+			guard:    &guard{maxChangePercent: 50},
+			previous: -1,
+			current:  10,
+			minimum:  11,
+			reason:   "10 entries is below the minimum of 11 entries",
+		},
+		"shrink within percent": {
+			guard:    &guard{maxChangePercent: 50},
+			previous: 100,
+			current:  50,
+		},
+		"shrink beyond percent": {
+			guard:    &guard{maxChangePercent: 50},
+			previous: 100,
+			current:  49,
+			reason: "49 entries is 51% fewer than the previous 100 entries, " +
+				"exceeding the maximum change of 50%",
+		},
+		"growth beyond percent": {
+			guard:    &guard{maxChangePercent: 50},
+			previous: 100,
+			current:  200,
+			reason: "200 entries is 100% more than the previous 100 entries, " +
+				"exceeding the maximum change of 50%",
+		},
+		"growth beyond count": {
+			guard:    &guard{maxChangeCount: 10},
+			previous: 100,
+			current:  111,
+			reason: "111 entries is 11 entries more than the previous 100 entries, " +
+				"exceeding the maximum change of 10 entries",
+		},
+		"aggregation changed": {
+			guard:      &guard{maxChangePercent: 50, aggregationChanged: true},
+			previous:   100,
+			current:    10,
+			aggregated: true,
+		},
+		"aggregation changed for hostnames": {
+			guard:    &guard{maxChangePercent: 50, aggregationChanged: true},
+			previous: 100,
+			current:  10,
+			reason: "10 entries is 90% fewer than the previous 100 entries, " +
+				"exceeding the maximum change of 50%",
+		},
+		"all disabled": {
+			guard:    &guard{},
+			previous: 100,
+			current:  1,
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			reason := testCase.guard.check(testCase.previous, testCase.current,
+				testCase.minimum, testCase.aggregated)
+			assert.Equal(t, testCase.reason, reason)
+		})
+	}
+}
+
+func Test_newGuard_aggregationChanged(t *testing.T) {
+	t.Parallel()
+
+	stateDir := t.TempDir()
+	maxChangePercent, maxChangeCount := uint(50), uint(0)
+	guardSettings := settings.Guard{MaxChangePercent: &maxChangePercent, MaxChangeCount: &maxChangeCount}
+	aggregation := ips.Aggregation{MaxWidthIPv4: 24, MaxWidthIPv6: 48}
+
+	// First run after upgrading, without guard state: the previous
+	// IPs aggregation is unknown, so the IPs lists are still compared.
+	g, err := newGuard(guardSettings, aggregation, stateDir)
+	require.NoError(t, err)
+	assert.False(t, g.aggregationChanged)
+	assert.NotEmpty(t, g.check(1000, 100, 0, true))
+	err = g.finish()
+	require.NoError(t, err)
+
+	g, err = newGuard(guardSettings, aggregation, stateDir)
+	require.NoError(t, err)
+	assert.False(t, g.aggregationChanged)
+	assert.NotEmpty(t, g.check(1000, 100, 0, true))
+
+	// An aggregated IPs list held back keeps the previous aggregation.
+	changedAggregation := aggregation
+	changedAggregation.MaxWidthIPv4 = 32
+	g, err = newGuard(guardSettings, changedAggregation, stateDir)
+	require.NoError(t, err)
+	assert.True(t, g.aggregationChanged)
+	assert.Empty(t, g.check(1000, 100, 0, true))
+	g.heldBack(true)
+	err = g.finish()
+	require.NoError(t, err)
+
+	g, err = newGuard(guardSettings, changedAggregation, stateDir)
+	require.NoError(t, err)
+	assert.True(t, g.aggregationChanged)
+	err = g.finish()
+	require.NoError(t, err)
+
+	g, err = newGuard(guardSettings, changedAggregation, stateDir)
+	require.NoError(t, err)
+	assert.False(t, g.aggregationChanged)
+}
@@ -0,0 +1,110 @@
+package run
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/qdm12/updated/internal/constants"
+	"github.com/qdm12/updated/pkg/manifest"
+)
+
+// recordArtifact records the metadata of an output file written
+// during the current run, to describe it in the manifest.
+func (r *Runner) recordArtifact(filename string, metadata manifest.Metadata) {
+	r.artifactsMu.Lock()
+	defer r.artifactsMu.Unlock()
+	if r.artifacts == nil {
+		r.artifacts = make(map[string]manifest.Metadata)
+	}
+	r.artifacts[filename] = metadata
+}
+
+// recordList records the metadata of a list output file with
+// the number of entries and sources given.
+func (r *Runner) recordList(filename string, entries int, sources []string) {
+	r.recordArtifact(filename, manifest.Metadata{
+		Entries: &entries,
+		Sources: sources,
+	})
+}
+
+// writeManifest stages the manifest describing the output files of the
+// output directory once the staged files are promoted, if any of them
+// changed since the previous manifest. Compressed copies are described
+// with the metadata of their file. Other files of the output directory,
+// such as the files of its Git repository, are not described.
+func (r *Runner) writeManifest() (err error) {
+	r.artifactsMu.Lock()
+	artifacts := r.artifacts
+	r.artifacts = nil
+	r.artifactsMu.Unlock()
+
+	extensions, err := r.compressionExtensions()
+	if err != nil {
+		return err
+	}
+	metadata := make(map[string]manifest.Metadata, len(artifacts))
+	for filename, fileMetadata := range artifacts {
+		metadata[filename] = fileMetadata
+		for _, extension := range extensions {
+			metadata[filename+extension] = fileMetadata
+		}
+	}
+
+	previous, err := manifest.Read(r.staging.TargetPath(constants.ManifestFilename))
+	if err != nil {
+		return fmt.Errorf("reading previous manifest: %w", err)
+	}
+
+	dirs := []string{r.settings.OutputDir, r.staging.Root()}
+	current, changed, err := manifest.Build(dirs, r.outputFilenames(extensions), previous, metadata, time.Now())
+	if err != nil {
+		return err
+	}
+	if !changed {
+		return nil
+	}
+
+	err = current.Write(r.staging.Path(constants.ManifestFilename))
+	if err != nil {
+		return err
+	}
+	r.logger.Infof("Staged manifest version %d", current.Version)
+	return nil
+}
+
+// outputFilenames returns the names of all the files the runner can
+// publish in the output directory, apart from the manifest and the changes
+// files: the DNS root files, the files of the categories of the catalog
+// and their compressed copies with the extensions given. They may not all
+// exist.
+func (r *Runner) outputFilenames(extensions []string) (filenames []string) {
+	filenames = []string{
+		constants.NamedRootFilename,
+		constants.RootAnchorsFilename,
+		constants.RootKeyFilename,
+	}
+	for _, category := range r.catalog.Categories {
+		filenames = append(filenames, category.Filenames()...)
+	}
+	uncompressed := len(filenames)
+	for _, extension := range extensions {
+		for _, filename := range filenames[:uncompressed] {
+			filenames = append(filenames, filename+extension)
+		}
+	}
+	return filenames
+}
+
+// compressionExtensions returns the file extensions
+// of the compression formats configured.
+func (r *Runner) compressionExtensions() (extensions []string, err error) {
+	extensions = make([]string, len(r.settings.Compression.Formats))
+	for i, format := range r.settings.Compression.Formats {
+		extensions[i], err = format.Extension()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return extensions, nil
+}
@@ -0,0 +1,163 @@
+package run
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/qdm12/updated/internal/catalog"
+	"github.com/qdm12/updated/pkg/rpz"
+)
+
+// hostnameFormatters returns the functions formatting a hostname
+// into a line for each of the catalog output formats.
+func hostnameFormatters() map[string]func(hostname string) string {
+	return map[string]func(hostname string) string{
+		catalog.OutputUnbound: func(hostname string) string {
+			return `local-zone: "` + hostname + `" always_nxdomain`
+		},
+		catalog.OutputDnsmasq: func(hostname string) string {
+			return "address=/" + hostname + "/#"
+		},
+		catalog.OutputHosts: func(hostname string) string {
+			return "0.0.0.0 " + hostname
+		},
+		catalog.OutputAdblock: func(hostname string) string {
+			return "||" + hostname + "^"
+		},
+		catalog.OutputDomains: func(hostname string) string {
+			return hostname
+		},
+	}
+}
+
+// writeHostnamesOutputs writes the hostnames given to each of the hostnames
+// outputs of the category, in the staging directory. Sources are the
+// sources of the hostnames, recorded for the manifest.
+func (r *Runner) writeHostnamesOutputs(category catalog.Category, hostnames, sources []string) error {
+	formatters := hostnameFormatters()
+	for _, output := range category.Outputs {
+		format, ok := formatters[output.Format]
+		if !ok { // not a hostnames only output
+			continue
+		}
+		err := writeFormattedLines(r.staging.Path(output.Filename), hostnames, format)
+		if err != nil {
+			return fmt.Errorf("writing %s output: %w", output.Format, err)
+		}
+		r.recordList(output.Filename, len(hostnames), sources)
+	}
+	return nil
+}
+
+// writeFormattedLines writes each of the values given formatted with
+// the format function given, one per line, to the file path given.
+func writeFormattedLines(filePath string, values []string,
+	format func(value string) string,
+) error {
+	const perms = 0o600
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perms) //nolint:gosec
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+	for _, value := range values {
+		_, _ = writer.WriteString(format(value))
+		_ = writer.WriteByte('\n')
+	}
+
+	err = writer.Flush()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// writeRPZOutputs stages the response policy zone outputs of the category
+// from its hostnames and IPs lists files, staged or published, so the
+// zones match the lists to publish, including when a list is held back by
+// the guard. Zones are not written if any of the lists files does not exist.
+func (r *Runner) writeRPZOutputs(category catalog.Category) error {
+	var outputs []catalog.Output
+	for _, output := range category.Outputs {
+		if output.Format == catalog.OutputRPZ {
+			outputs = append(outputs, output)
+		}
+	}
+	if len(outputs) == 0 {
+		return nil
+	}
+
+	hostnames, err := readLines(r.staging.CurrentPath(category.HostnamesFilename))
+	if err != nil {
+		return fmt.Errorf("reading hostnames list: %w", err)
+	}
+	IPs, err := readLines(r.staging.CurrentPath(category.IPsFilename))
+	if err != nil {
+		return fmt.Errorf("reading IPs list: %w", err)
+	}
+	if hostnames == nil || IPs == nil {
+		r.logger.Warn("not writing " + category.Name + " RPZ outputs: lists files do not all exist")
+		return nil
+	}
+
+	sources := make([]string, len(category.Sources))
+	for i, source := range category.Sources {
+		sources[i] = source.URL
+	}
+
+	for _, output := range outputs {
+		zone := rpz.New(r.staging.Path(output.Filename), r.staging.TargetPath(output.Filename),
+			filepath.Join(r.settings.StateDir, rpzStateDirname, output.Filename+".json"),
+			output.RPZPolicy())
+		result, err := zone.Write(hostnames, IPs, time.Now())
+		if err != nil {
+			return fmt.Errorf("writing %s: %w", output.Filename, err)
+		}
+		if result.Skipped > 0 {
+			r.logger.Warn(fmt.Sprintf("skipped %d hostnames not valid as zone owner names in %s",
+				result.Skipped, output.Filename))
+		}
+		if result.Changed {
+			r.logger.Infof("Wrote %s with serial %d", output.Filename, result.Serial)
+		}
+		r.recordList(output.Filename, len(hostnames)-result.Skipped+len(IPs), sources)
+	}
+	return nil
+}
+
+// rpzStateDirname is the name of the directory in the state directory
+// storing the serial state of response policy zone outputs.
+const rpzStateDirname = "rpz"
+
+// readLines returns the non empty lines of the file at the path
+// given, or nil if the file does not exist.
+func readLines(filePath string) (lines []string, err error) {
+	file, err := os.Open(filePath) //nolint:gosec
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	lines = []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	err = scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
@@ -0,0 +1,41 @@
+package run
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/qdm12/updated/internal/catalog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_hostnameFormatters(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]string{
+		catalog.OutputUnbound: "local-zone: \"a.com\" always_nxdomain\n" +
+			"local-zone: \"b.a.com\" always_nxdomain\n",
+		catalog.OutputDnsmasq: "address=/a.com/#\naddress=/b.a.com/#\n",
+		catalog.OutputHosts:   "0.0.0.0 a.com\n0.0.0.0 b.a.com\n",
+		catalog.OutputAdblock: "||a.com^\n||b.a.com^\n",
+		catalog.OutputDomains: "a.com\nb.a.com\n",
+	}
+
+	formatters := hostnameFormatters()
+	assert.Len(t, formatters, len(testCases))
+
+	for format, expected := range testCases {
+		t.Run(format, func(t *testing.T) {
+			t.Parallel()
+
+			filePath := filepath.Join(t.TempDir(), "list")
+			err := writeFormattedLines(filePath, []string{"a.com", "b.a.com"}, formatters[format])
+			require.NoError(t, err)
+
+			content, err := os.ReadFile(filePath)
+			require.NoError(t, err)
+			assert.Equal(t, expected, string(content))
+		})
+	}
+}
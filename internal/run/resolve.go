@@ -0,0 +1,57 @@
+package run
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/qdm12/updated/pkg/ips"
+)
+
+// resolveCacheFilename is the name of the file in the state
+// directory caching the IP addresses of hostnames.
+const resolveCacheFilename = "resolve-cache.json"
+
+// resolveIPs resolves the hostnames of the category given to their IP
+// addresses, using the resolve cache if it is enabled, and reports the
+// resolution statistics. Lookups are limited by the limiter shared by all
+// categories. The protected ranges files are read on every call, so changes
+// to them apply without restarting the program.
+func (r *Runner) resolveIPs(ctx context.Context, categoryName string, hostnames []string) (
+	resolved []string, err error,
+) {
+	options := r.settings.Resolver.Options()
+	options.Limiter = r.resolveLimiter
+	if r.resolveCache != nil {
+		options.Cache = r.resolveCache
+	}
+	for _, path := range r.settings.Resolver.ProtectedRanges {
+		ranges, err := ips.ReadProtectedRanges(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading protected ranges: %w", err)
+		}
+		options.Protection.Ranges = append(options.Protection.Ranges, ranges...)
+	}
+
+	resolved, stats, err := r.ipsBuilder.BuildIPsFromHostnames(ctx, hostnames, options)
+	if err != nil {
+		return nil, fmt.Errorf("resolving hostnames: %w", err)
+	}
+	message := fmt.Sprintf("%s hostnames resolution: %s", categoryName, stats)
+	r.logger.Info(message)
+	r.appendNotification(message)
+	return resolved, nil
+}
+
+// saveResolveCache saves the resolve cache if it is enabled. Failing to
+// save it only logs a warning, since the hostnames are resolved again
+// on the next run.
+func (r *Runner) saveResolveCache() {
+	if r.resolveCache == nil {
+		return
+	}
+	err := r.resolveCache.Save(time.Now())
+	if err != nil {
+		r.logger.Warn("saving resolve cache: " + err.Error())
+	}
+}
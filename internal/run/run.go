@@ -6,31 +6,60 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/containrrr/shoutrrr/pkg/router"
 	"github.com/containrrr/shoutrrr/pkg/types"
+	"github.com/qdm12/updated/internal/catalog"
 	"github.com/qdm12/updated/internal/settings"
+	"github.com/qdm12/updated/pkg/changes"
 	"github.com/qdm12/updated/pkg/dnscrypto"
 	"github.com/qdm12/updated/pkg/hostnames"
+	"github.com/qdm12/updated/pkg/httpcache"
 	"github.com/qdm12/updated/pkg/ips"
+	"github.com/qdm12/updated/pkg/lastgood"
+	"github.com/qdm12/updated/pkg/manifest"
+	"github.com/qdm12/updated/pkg/resolvecache"
+	"github.com/qdm12/updated/pkg/resolver"
+	"github.com/qdm12/updated/pkg/staging"
 )
 
 // Runner runs the main update loop.
 type Runner struct {
 	settings         settings.Settings
+	catalog          catalog.Catalog
 	logger           Logger
+	client           *http.Client
 	shoutrrrSender   *router.ServiceRouter
 	shoutrrrParams   *types.Params
 	ipsBuilder       *ips.Builder
 	hostnamesBuilder *hostnames.Builder
 	dnscrypto        *dnscrypto.DNSCrypto
 	setHealthErr     func(err error)
+	// resolveCache is the cache of the IP addresses of hostnames,
+	// and is nil if disabled.
+	resolveCache *resolvecache.Cache
+	// resolveLimiter limits the lookups of all the categories together.
+	resolveLimiter *ips.ResolveLimiter
+	// resolver resolves hostnames with the upstream servers,
+	// and is nil if the system resolver is used.
+	resolver *resolver.Resolver
 
 	// State
-	cancel context.CancelFunc
-	done   <-chan struct{}
+	cancel          context.CancelFunc
+	done            <-chan struct{}
+	notifications   []string
+	notificationsMu sync.Mutex
+	artifacts       map[string]manifest.Metadata
+	artifactsMu     sync.Mutex
+	changes         []changes.List
+	changesMu       sync.Mutex
+	// staging is the staging directory of the current run.
+	staging *staging.Dir
 }
 
 // Logger represents a minimal logger interface.
@@ -44,22 +73,35 @@ type Logger interface {
 }
 
 // New creates a new [Runner] implementing the goservices.Service interface.
-func New(settings settings.Settings, logger Logger,
+func New(settings settings.Settings, catalog catalog.Catalog, logger Logger,
 	shoutrrrSender *router.ServiceRouter, shoutrrrParams *types.Params,
 	setHealthErr func(err error),
 ) *Runner {
+	fallback := lastgood.New(filepath.Join(settings.StateDir, "sources"))
 	client := &http.Client{
 		Timeout: settings.HTTPTimeout,
 	}
+	if *settings.HTTPCache {
+		cacheDir := filepath.Join(settings.StateDir, "http")
+		client.Transport = httpcache.New(cacheDir, http.DefaultTransport, logger)
+	}
+	tempDir := filepath.Join(settings.StateDir, "tmp")
+	ipsBuilder := ips.New(client, logger, fallback, tempDir)
+	dnsResolver := settings.Resolver.New()
+	ipsBuilder.SetLookupIP(settings.Resolver.LookupIP(dnsResolver))
 	return &Runner{
 		settings:         settings,
+		catalog:          catalog,
 		logger:           logger,
+		client:           client,
 		shoutrrrSender:   shoutrrrSender,
 		shoutrrrParams:   shoutrrrParams,
-		ipsBuilder:       ips.New(client, logger),
-		hostnamesBuilder: hostnames.New(client, logger),
+		ipsBuilder:       ipsBuilder,
+		hostnamesBuilder: hostnames.New(client, logger, fallback, tempDir),
 		dnscrypto:        dnscrypto.New(client, *settings.HexSums.NamedRootMD5, settings.HexSums.RootAnchorsSHA256),
 		setHealthErr:     setHealthErr,
+		resolveLimiter:   settings.Resolver.Limiter(),
+		resolver:         dnsResolver,
 	}
 }
 
@@ -69,6 +111,19 @@ func (r *Runner) String() string {
 
 // Start starts the runner.
 func (r *Runner) Start(_ context.Context) (runErr <-chan error, err error) {
+	err = staging.Clean(r.settings.OutputDir)
+	if err != nil {
+		return nil, fmt.Errorf("cleaning leftover staging directories: %w", err)
+	}
+
+	if *r.settings.Resolver.Cache {
+		r.resolveCache, err = resolvecache.New(filepath.Join(r.settings.StateDir, resolveCacheFilename),
+			*r.settings.Resolver.CacheMinFreshness, *r.settings.Resolver.CacheGracePeriod)
+		if err != nil {
+			return nil, fmt.Errorf("loading resolve cache: %w", err)
+		}
+	}
+
 	done := make(chan struct{})
 	r.done = done
 	ctx, cancel := context.WithCancel(context.Background())
@@ -104,10 +159,14 @@ func (r *Runner) Start(_ context.Context) (runErr <-chan error, err error) {
 	return nil, nil //nolint:nilnil
 }
 
-// Stop stops the runner.
+// Stop stops the runner and closes the connections
+// kept open to the resolver upstream servers.
 func (r *Runner) Stop() error {
 	r.cancel()
 	<-r.done
+	if r.resolver != nil {
+		r.resolver.Close()
+	}
 	return nil
 }
 
@@ -120,43 +179,90 @@ func (r *Runner) singleRun(ctx context.Context) (err error) {
 		r.logger.Infof("overall execution took %s", executionTime)
 		r.logger.Infof("sleeping for %s", r.settings.Period-executionTime)
 	}()
+	defer r.sendNotifications()
+	r.resetChanges()
+
 	gitUploader, err := setupGit(ctx, r.settings, r.logger)
 	if err != nil {
 		return fmt.Errorf("setting up Git: %w", err)
 	}
 
+	guard, err := newGuard(r.settings.Guard, r.settings.Aggregation.Options(), r.settings.StateDir)
+	if err != nil {
+		return fmt.Errorf("setting up guard: %w", err)
+	}
+
+	r.staging, err = staging.New(r.settings.OutputDir)
+	if err != nil {
+		return fmt.Errorf("setting up staging: %w", err)
+	}
+	defer r.removeStaging()
+
+	err = r.runJobs(ctx, guard)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	err = r.publishOutputs(now)
+	if err != nil {
+		return fmt.Errorf("publishing outputs: %w", err)
+	}
+
+	changesSummary := r.reportChanges()
+	message := "Update of " + now.Format("2006-01-02")
+	if changesSummary != "" {
+		message += "\n\n" + changesSummary
+	}
+	err = gitUploader.UploadAllChanges(ctx, message)
+	if err != nil {
+		return fmt.Errorf("uploading changes: %w", err)
+	}
+
+	err = guard.finish()
+	if err != nil {
+		return fmt.Errorf("finishing guard: %w", err)
+	}
+	return nil
+}
+
+// runJobs builds all the output files concurrently, staging them
+// in the staging directory, and returns an error if any job failed.
+func (r *Runner) runJobs(ctx context.Context, guard *guard) (err error) {
+	globalAllowlists := slices.Concat(r.settings.Allowlists, r.catalog.Allowlists)
+	globalAllowRules, err := r.fetchAllowRules(ctx, globalAllowlists)
+	if err != nil {
+		return fmt.Errorf("fetching global allowlists: %w", err)
+	}
+
+	jobs := []func(ctx context.Context) error{
+		r.buildNamedRoot,
+		r.buildRootAnchorsAndKeys,
+	}
+	for _, category := range r.catalog.Categories {
+		jobs = append(jobs, func(ctx context.Context) error {
+			return r.buildCategory(ctx, category, globalAllowRules, guard)
+		})
+	}
+
 	chError := make(chan error)
-	go func() {
-		chError <- r.buildNamedRoot(ctx)
-	}()
-	go func() {
-		chError <- r.buildRootAnchorsAndKeys(ctx)
-	}()
-	go func() {
-		chError <- r.buildMalicious(ctx)
-	}()
-	go func() {
-		chError <- r.buildAds(ctx)
-	}()
-	go func() {
-		chError <- r.buildSurveillance(ctx)
-	}()
+	for _, job := range jobs {
+		go func() {
+			chError <- job(ctx)
+		}()
+	}
 	var errorMessages []string
-	for range 5 {
+	for range jobs {
 		err := <-chError
 		if err != nil {
 			errorMessages = append(errorMessages, err.Error())
 		}
 	}
 	close(chError)
+	r.saveResolveCache()
 	if errorMessages != nil {
 		return fmt.Errorf("%w: %s", errEncountered, strings.Join(errorMessages, "; "))
 	}
-	message := "Update of " + time.Now().Format("2006-01-02")
-	err = gitUploader.UploadAllChanges(ctx, message)
-	if err != nil {
-		return fmt.Errorf("uploading changes: %w", err)
-	}
 	return nil
 }
 
@@ -170,6 +276,26 @@ func (r *Runner) handleRunError(err error) {
 	r.shoutrrrSend(err.Error())
 }
 
+// appendNotification appends a message to be sent as part of
+// a single notification at the end of the current run.
+func (r *Runner) appendNotification(message string) {
+	r.notificationsMu.Lock()
+	defer r.notificationsMu.Unlock()
+	r.notifications = append(r.notifications, message)
+}
+
+func (r *Runner) sendNotifications() {
+	r.notificationsMu.Lock()
+	notifications := r.notifications
+	r.notifications = nil
+	r.notificationsMu.Unlock()
+
+	if len(notifications) == 0 {
+		return
+	}
+	r.shoutrrrSend(strings.Join(notifications, "\n"))
+}
+
 func (r *Runner) shoutrrrSend(message string) {
 	errs := r.shoutrrrSender.Send(message, r.shoutrrrParams)
 	for _, err := range errs {
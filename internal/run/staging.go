@@ -0,0 +1,58 @@
+package run
+
+import (
+	"fmt"
+	"time"
+)
+
+// publishOutputs promotes the output files staged during the run to the
+// output directory. Staged files identical to their published file are
+// dropped so they are left untouched, and the changes file of the day,
+// compressed copies and the manifest describing the files to publish are
+// staged before promoting all the files with atomic renames.
+func (r *Runner) publishOutputs(now time.Time) (err error) {
+	err = r.staging.DropUnchanged()
+	if err != nil {
+		return fmt.Errorf("dropping unchanged files: %w", err)
+	}
+
+	err = r.stageChanges(now)
+	if err != nil {
+		return fmt.Errorf("staging changes: %w", err)
+	}
+
+	err = r.compressOutputs()
+	if err != nil {
+		return fmt.Errorf("compressing outputs: %w", err)
+	}
+
+	err = r.writeManifest()
+	if err != nil {
+		return fmt.Errorf("writing manifest: %w", err)
+	}
+
+	promoted, err := r.staging.Promote()
+	if err != nil {
+		return fmt.Errorf("promoting staged files: %w", err)
+	}
+	for _, filename := range promoted {
+		r.logger.Debug("published " + filename)
+	}
+
+	// Remove the staging directory before uploading the changes,
+	// so it cannot be added to the Git repository.
+	err = r.staging.Remove()
+	if err != nil {
+		return fmt.Errorf("removing staging directory: %w", err)
+	}
+	return nil
+}
+
+// removeStaging removes the staging directory of the run, if
+// it was not already removed once its files were published.
+func (r *Runner) removeStaging() {
+	err := r.staging.Remove()
+	if err != nil {
+		r.logger.Warn("removing staging directory: " + err.Error())
+	}
+}
@@ -0,0 +1,74 @@
+package settings
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/qdm12/gosettings"
+	"github.com/qdm12/gosettings/reader"
+	"github.com/qdm12/gotree"
+	"github.com/qdm12/updated/pkg/ips"
+)
+
+// Aggregation holds the settings of the aggregation of adjacent
+// IP addresses and CIDRs of IPs lists into larger CIDRs.
+type Aggregation struct {
+	// MaxWidthIPv4 is the shortest prefix length of the IPv4 CIDRs
+	// produced by aggregation. It defaults to 24, and 32 disables
+	// the aggregation of IPv4 entries.
+	MaxWidthIPv4 *uint
+	// MaxWidthIPv6 is the shortest prefix length of the IPv6 CIDRs
+	// produced by aggregation. It defaults to 48, and 128 disables
+	// the aggregation of IPv6 entries.
+	MaxWidthIPv6 *uint
+}
+
+// Options returns the aggregation options for [ips.Builder.CleanIPs].
+func (a Aggregation) Options() ips.Aggregation {
+	return ips.Aggregation{
+		MaxWidthIPv4: int(*a.MaxWidthIPv4), //nolint:gosec
+		MaxWidthIPv6: int(*a.MaxWidthIPv6), //nolint:gosec
+	}
+}
+
+func (a *Aggregation) read(r *reader.Reader) (err error) {
+	a.MaxWidthIPv4, err = r.UintPtr("IPS_AGGREGATION_MAX_WIDTH_IPV4")
+	if err != nil {
+		return err
+	}
+
+	a.MaxWidthIPv6, err = r.UintPtr("IPS_AGGREGATION_MAX_WIDTH_IPV6")
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (a *Aggregation) setDefaults() {
+	const defaultMaxWidthIPv4, defaultMaxWidthIPv6 = 24, 48
+	a.MaxWidthIPv4 = gosettings.DefaultPointer(a.MaxWidthIPv4, defaultMaxWidthIPv4)
+	a.MaxWidthIPv6 = gosettings.DefaultPointer(a.MaxWidthIPv6, defaultMaxWidthIPv6)
+}
+
+var ErrAggregationWidthNotValid = errors.New("aggregation maximum width is not valid")
+
+func (a Aggregation) validate() (err error) {
+	const ipv4Bits, ipv6Bits = 32, 128
+	switch {
+	case *a.MaxWidthIPv4 > ipv4Bits:
+		return fmt.Errorf("%w: IPv4 width %d must be between 0 and %d",
+			ErrAggregationWidthNotValid, *a.MaxWidthIPv4, ipv4Bits)
+	case *a.MaxWidthIPv6 > ipv6Bits:
+		return fmt.Errorf("%w: IPv6 width %d must be between 0 and %d",
+			ErrAggregationWidthNotValid, *a.MaxWidthIPv6, ipv6Bits)
+	}
+	return nil
+}
+
+func (a Aggregation) toLinesNode() (node *gotree.Node) {
+	node = gotree.New("IPs aggregation:")
+	node.Appendf("IPv4 maximum width: /%d", *a.MaxWidthIPv4)
+	node.Appendf("IPv6 maximum width: /%d", *a.MaxWidthIPv6)
+	return node
+}
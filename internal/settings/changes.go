@@ -0,0 +1,47 @@
+package settings
+
+import (
+	"github.com/qdm12/gosettings"
+	"github.com/qdm12/gosettings/reader"
+	"github.com/qdm12/gotree"
+)
+
+// Changes holds the settings of the report of the entries
+// added to and removed from lists on each run.
+type Changes struct {
+	// File is true to append the changes of each run to the file
+	// changes/<date>.json in the output directory.
+	// It defaults to false.
+	File *bool
+	// NotificationEntries is the maximum number of entries added and
+	// of entries removed listed for each list in notifications.
+	// It defaults to 10 and 0 only notifies the counts.
+	NotificationEntries *uint
+}
+
+func (c *Changes) read(r *reader.Reader) (err error) {
+	c.File, err = r.BoolPtr("CHANGES_FILE")
+	if err != nil {
+		return err
+	}
+
+	c.NotificationEntries, err = r.UintPtr("CHANGES_NOTIFICATION_ENTRIES")
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *Changes) setDefaults() {
+	c.File = gosettings.DefaultPointer(c.File, false)
+	const defaultNotificationEntries = 10
+	c.NotificationEntries = gosettings.DefaultPointer(c.NotificationEntries, defaultNotificationEntries)
+}
+
+func (c Changes) toLinesNode() (node *gotree.Node) {
+	node = gotree.New("Changes:")
+	node.Appendf("file: %s", gosettings.BoolToYesNo(c.File))
+	node.Appendf("notification entries: %d", *c.NotificationEntries)
+	return node
+}
@@ -0,0 +1,93 @@
+package settings
+
+import (
+	"github.com/qdm12/gosettings"
+	"github.com/qdm12/gosettings/reader"
+	"github.com/qdm12/gotree"
+	"github.com/qdm12/updated/pkg/compress"
+)
+
+// Compression holds the settings of the compressed
+// copies written next to each output file.
+type Compression struct {
+	// Formats are the compression formats of the copies to write.
+	// It defaults to no format, which disables compressed copies.
+	Formats []compress.Format
+	// GzipLevel is the gzip compression level from 1 to 9.
+	// It defaults to 9.
+	GzipLevel *uint
+	// ZstdLevel is the zstd compression level from 1 to 22.
+	// It defaults to 19.
+	ZstdLevel *uint
+	// BrotliLevel is the brotli compression level from 0 to 11.
+	// It defaults to 11.
+	BrotliLevel *uint
+}
+
+// Level returns the compression level for the format given.
+func (c Compression) Level(format compress.Format) (level int) {
+	switch format {
+	case compress.FormatGzip:
+		return int(*c.GzipLevel) //nolint:gosec
+	case compress.FormatZstd:
+		return int(*c.ZstdLevel) //nolint:gosec
+	case compress.FormatBrotli:
+		return int(*c.BrotliLevel) //nolint:gosec
+	default:
+		return 0
+	}
+}
+
+func (c *Compression) read(r *reader.Reader) (err error) {
+	formats := r.CSV("COMPRESSION")
+	c.Formats = make([]compress.Format, len(formats))
+	for i, format := range formats {
+		c.Formats[i] = compress.Format(format)
+	}
+
+	c.GzipLevel, err = r.UintPtr("COMPRESSION_GZIP_LEVEL")
+	if err != nil {
+		return err
+	}
+
+	c.ZstdLevel, err = r.UintPtr("COMPRESSION_ZSTD_LEVEL")
+	if err != nil {
+		return err
+	}
+
+	c.BrotliLevel, err = r.UintPtr("COMPRESSION_BROTLI_LEVEL")
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *Compression) setDefaults() {
+	const defaultGzipLevel, defaultZstdLevel, defaultBrotliLevel = 9, 19, 11
+	c.GzipLevel = gosettings.DefaultPointer(c.GzipLevel, defaultGzipLevel)
+	c.ZstdLevel = gosettings.DefaultPointer(c.ZstdLevel, defaultZstdLevel)
+	c.BrotliLevel = gosettings.DefaultPointer(c.BrotliLevel, defaultBrotliLevel)
+}
+
+func (c Compression) validate() (err error) {
+	for _, format := range c.Formats {
+		err = format.Validate(c.Level(format))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c Compression) toLinesNode() (node *gotree.Node) {
+	node = gotree.New("Compression:")
+	if len(c.Formats) == 0 {
+		node.Appendf("formats: disabled")
+		return node
+	}
+	for _, format := range c.Formats {
+		node.Appendf("%s: level %d", format, c.Level(format))
+	}
+	return node
+}
@@ -0,0 +1,55 @@
+package settings
+
+import (
+	"github.com/qdm12/gosettings"
+	"github.com/qdm12/gosettings/reader"
+	"github.com/qdm12/gotree"
+)
+
+// Guard holds the settings of the guard holding back
+// outputs changing suspiciously compared to their previous version.
+type Guard struct {
+	// MaxChangePercent is the maximum change in percent of the number of
+	// entries of an output compared to its previous version.
+	// It defaults to 50 and is disabled if set to 0.
+	MaxChangePercent *uint
+	// MaxChangeCount is the maximum change of the number of entries
+	// of an output compared to its previous version.
+	// It defaults to 0 which disables it.
+	MaxChangeCount *uint
+}
+
+func (g *Guard) read(r *reader.Reader) (err error) {
+	g.MaxChangePercent, err = r.UintPtr("GUARD_MAX_CHANGE_PERCENT")
+	if err != nil {
+		return err
+	}
+
+	g.MaxChangeCount, err = r.UintPtr("GUARD_MAX_CHANGE_COUNT")
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (g *Guard) setDefaults() {
+	const defaultMaxChangePercent = 50
+	g.MaxChangePercent = gosettings.DefaultPointer(g.MaxChangePercent, defaultMaxChangePercent)
+	g.MaxChangeCount = gosettings.DefaultPointer(g.MaxChangeCount, 0)
+}
+
+func (g Guard) toLinesNode() (node *gotree.Node) {
+	node = gotree.New("Guard:")
+	if *g.MaxChangePercent == 0 {
+		node.Appendf("max change percent: disabled")
+	} else {
+		node.Appendf("max change percent: %d%%", *g.MaxChangePercent)
+	}
+	if *g.MaxChangeCount == 0 {
+		node.Appendf("max change count: disabled")
+	} else {
+		node.Appendf("max change count: %d", *g.MaxChangeCount)
+	}
+	return node
+}
@@ -0,0 +1,235 @@
+package settings
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/qdm12/gosettings"
+	"github.com/qdm12/gosettings/reader"
+	"github.com/qdm12/gotree"
+	"github.com/qdm12/updated/pkg/ips"
+	"github.com/qdm12/updated/pkg/resolver"
+)
+
+// Resolver holds the settings of the resolution of hostnames
+// to add their IP addresses to the IPs lists.
+type Resolver struct {
+	// Upstreams are the plain DNS, DNS over TLS or DNS over HTTPS
+	// servers queried in order. It defaults to no server, in which
+	// case the system resolver is used.
+	Upstreams []resolver.Upstream
+	// QueryTypes are the record types queried for each hostname,
+	// which can be A and AAAA. It defaults to both A and AAAA.
+	QueryTypes []uint16
+	// Concurrency is the number of hostnames resolved concurrently.
+	// It defaults to 100 and cannot be 0.
+	Concurrency *uint
+	// Timeout is the timeout of each hostname lookup.
+	// It defaults to 5 seconds.
+	Timeout time.Duration
+	// QPS is the maximum number of lookups started per second.
+	// It defaults to 0 which disables the limit.
+	QPS *uint
+	// MaxDomainsPerIP is the maximum number of unrelated domains with
+	// hostnames resolving to an IP address for the IP address to be
+	// added to the IPs lists. It defaults to 10, and 0 disables the limit.
+	MaxDomainsPerIP *uint
+	// ProtectedRanges are the paths of files listing protected IP
+	// address ranges, such as cloud providers IP ranges JSON files.
+	// Resolved IP addresses inside these ranges are not added to the
+	// IPs lists. It defaults to no file.
+	ProtectedRanges []string
+	// Cache is true if the IP addresses of hostnames are cached in
+	// the state directory across runs. It defaults to true.
+	Cache *bool
+	// CacheMinFreshness is the minimum duration during which cached IP
+	// addresses are used without resolving their hostname again, even
+	// if their TTL is shorter. It defaults to 1 hour.
+	CacheMinFreshness *time.Duration
+	// CacheGracePeriod is the duration during which the cached IP
+	// addresses of a hostname are kept after it stops resolving.
+	// It defaults to 0 which disables keeping them.
+	CacheGracePeriod *time.Duration
+}
+
+// Options returns the resolution settings for [ips.Builder.BuildIPsFromHostnames].
+func (r Resolver) Options() ips.ResolveSettings {
+	return ips.ResolveSettings{
+		Timeout: r.Timeout,
+		Protection: ips.Protection{
+			MaxDomains: int(*r.MaxDomainsPerIP), //nolint:gosec
+		},
+	}
+}
+
+// Limiter returns a new limiter of the concurrency and rate of lookups,
+// to share between all the hostnames resolutions.
+func (r Resolver) Limiter() *ips.ResolveLimiter {
+	return ips.NewResolveLimiter(int(*r.Concurrency), int(*r.QPS)) //nolint:gosec
+}
+
+// New returns a new resolver querying the upstream servers, or nil if
+// there is no upstream server. The resolver must be closed once done.
+func (r Resolver) New() *resolver.Resolver {
+	if len(r.Upstreams) == 0 {
+		return nil
+	}
+	return resolver.New(r.Upstreams, r.QueryTypes, nil)
+}
+
+// LookupIP returns the function looking up the IP addresses of hostnames
+// with the resolver given, or with the system resolver if it is nil.
+func (r Resolver) LookupIP(dnsResolver *resolver.Resolver) ips.LookupIPFunc {
+	if dnsResolver == nil {
+		return resolver.SystemLookup(r.QueryTypes)
+	}
+	return dnsResolver.LookupNetIP
+}
+
+var ErrResolveQueryTypeNotSupported = errors.New("resolve query type is not supported")
+
+func (r *Resolver) read(env *reader.Reader) (err error) {
+	upstreams := env.CSV("RESOLVE_UPSTREAMS", reader.ForceLowercase(false))
+	r.Upstreams = make([]resolver.Upstream, len(upstreams))
+	for i, upstream := range upstreams {
+		r.Upstreams[i], err = resolver.ParseUpstream(upstream)
+		if err != nil {
+			return fmt.Errorf("RESOLVE_UPSTREAMS: %w", err)
+		}
+	}
+
+	for _, queryType := range env.CSV("RESOLVE_QUERY_TYPES") {
+		queryType = strings.ToUpper(queryType)
+		switch queryType {
+		case "A", "AAAA":
+			r.QueryTypes = append(r.QueryTypes, dns.StringToType[queryType])
+		default:
+			return fmt.Errorf("RESOLVE_QUERY_TYPES: %w: %s", ErrResolveQueryTypeNotSupported, queryType)
+		}
+	}
+
+	r.Concurrency, err = env.UintPtr("RESOLVE_CONCURRENCY")
+	if err != nil {
+		return err
+	}
+
+	r.Timeout, err = env.Duration("RESOLVE_TIMEOUT")
+	if err != nil {
+		return err
+	}
+
+	r.QPS, err = env.UintPtr("RESOLVE_QPS")
+	if err != nil {
+		return err
+	}
+
+	r.MaxDomainsPerIP, err = env.UintPtr("RESOLVE_MAX_DOMAINS_PER_IP")
+	if err != nil {
+		return err
+	}
+
+	r.ProtectedRanges = env.CSV("RESOLVE_PROTECTED_RANGES", reader.ForceLowercase(false))
+
+	r.Cache, err = env.BoolPtr("RESOLVE_CACHE")
+	if err != nil {
+		return err
+	}
+
+	r.CacheMinFreshness, err = env.DurationPtr("RESOLVE_CACHE_MIN_FRESHNESS")
+	if err != nil {
+		return err
+	}
+
+	r.CacheGracePeriod, err = env.DurationPtr("RESOLVE_CACHE_GRACE_PERIOD")
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *Resolver) setDefaults() {
+	const defaultConcurrency = 100
+	r.Concurrency = gosettings.DefaultPointer(r.Concurrency, defaultConcurrency)
+	const defaultTimeout = 5 * time.Second
+	r.Timeout = gosettings.DefaultComparable(r.Timeout, defaultTimeout)
+	r.QPS = gosettings.DefaultPointer(r.QPS, 0)
+	r.QueryTypes = gosettings.DefaultSlice(r.QueryTypes, []uint16{dns.TypeA, dns.TypeAAAA})
+	const defaultMaxDomainsPerIP = 10
+	r.MaxDomainsPerIP = gosettings.DefaultPointer(r.MaxDomainsPerIP, defaultMaxDomainsPerIP)
+	r.Cache = gosettings.DefaultPointer(r.Cache, true)
+	r.CacheMinFreshness = gosettings.DefaultPointer(r.CacheMinFreshness, time.Hour)
+	r.CacheGracePeriod = gosettings.DefaultPointer(r.CacheGracePeriod, 0)
+}
+
+var (
+	ErrResolveConcurrencyZero       = errors.New("resolve concurrency cannot be 0")
+	ErrResolveTimeoutTooSmall       = errors.New("resolve timeout is too small")
+	ErrResolveCacheDurationNegative = errors.New("resolve cache duration cannot be negative")
+)
+
+func (r Resolver) validate() (err error) {
+	const minTimeout = 100 * time.Millisecond
+	switch {
+	case *r.Concurrency == 0:
+		return ErrResolveConcurrencyZero
+	case r.Timeout < minTimeout:
+		return fmt.Errorf("%w: %s must be at least %s",
+			ErrResolveTimeoutTooSmall, r.Timeout, minTimeout)
+	case *r.CacheMinFreshness < 0:
+		return fmt.Errorf("%w: minimum freshness %s", ErrResolveCacheDurationNegative, *r.CacheMinFreshness)
+	case *r.CacheGracePeriod < 0:
+		return fmt.Errorf("%w: grace period %s", ErrResolveCacheDurationNegative, *r.CacheGracePeriod)
+	}
+	return nil
+}
+
+func (r Resolver) toLinesNode() (node *gotree.Node) {
+	node = gotree.New("Resolver:")
+	if len(r.Upstreams) == 0 {
+		node.Appendf("upstreams: system resolver")
+	} else {
+		upstreamsNode := node.Appendf("upstreams:")
+		for _, upstream := range r.Upstreams {
+			upstreamsNode.Appendf("%s", upstream)
+		}
+	}
+	queryTypes := make([]string, len(r.QueryTypes))
+	for i, queryType := range r.QueryTypes {
+		queryTypes[i] = dns.TypeToString[queryType]
+	}
+	node.Appendf("query types: %s", strings.Join(queryTypes, ", "))
+	node.Appendf("concurrency: %d", *r.Concurrency)
+	node.Appendf("timeout: %s", r.Timeout)
+	if *r.QPS == 0 {
+		node.Appendf("queries per second: unlimited")
+	} else {
+		node.Appendf("queries per second: %d", *r.QPS)
+	}
+	if *r.MaxDomainsPerIP == 0 {
+		node.Appendf("maximum domains per IP address: unlimited")
+	} else {
+		node.Appendf("maximum domains per IP address: %d", *r.MaxDomainsPerIP)
+	}
+	if len(r.ProtectedRanges) > 0 {
+		rangesNode := node.Appendf("protected ranges files:")
+		for _, path := range r.ProtectedRanges {
+			rangesNode.Appendf("%s", path)
+		}
+	}
+	if !*r.Cache {
+		node.Appendf("cache: disabled")
+		return node
+	}
+	cacheNode := node.Appendf("cache:")
+	cacheNode.Appendf("minimum freshness: %s", *r.CacheMinFreshness)
+	if *r.CacheGracePeriod == 0 {
+		cacheNode.Appendf("grace period: disabled")
+	} else {
+		cacheNode.Appendf("grace period: %s", *r.CacheGracePeriod)
+	}
+	return node
+}
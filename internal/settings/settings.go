@@ -6,31 +6,47 @@ import (
 	"fmt"
 	"path/filepath"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/qdm12/gosettings"
 	"github.com/qdm12/gosettings/reader"
 	"github.com/qdm12/gotree"
 	"github.com/qdm12/updated/pkg/dnscrypto"
+	"github.com/qdm12/updated/pkg/fetch"
+	"github.com/qdm12/updated/pkg/provenance"
 )
 
 // Settings holds the application settings.
 type Settings struct {
 	OutputDir        string
+	StateDir         string
+	CatalogPath      string
+	Allowlists       []string
 	Period           time.Duration
 	ResolveHostnames *bool
 	HTTPTimeout      time.Duration
+	HTTPCache        *bool
+	Provenance       provenance.Mode
 	HexSums          struct {
 		NamedRootMD5      *string
 		RootAnchorsSHA256 string
 	}
-	Git      Git
-	Log      Log
-	Shoutrrr Shoutrrr
+	Resolver    Resolver
+	Aggregation Aggregation
+	Guard       Guard
+	Compression Compression
+	Changes     Changes
+	Git         Git
+	Log         Log
+	Shoutrrr    Shoutrrr
 }
 
 func (s *Settings) Read(r *reader.Reader) (err error) {
 	s.OutputDir = r.String("OUTPUT_DIR")
+	s.StateDir = r.String("STATE_DIR")
+	s.CatalogPath = r.String("CATALOG_PATH")
+	s.Allowlists = r.CSV("ALLOWLISTS", reader.ForceLowercase(false))
 
 	s.Period, err = r.Duration("PERIOD")
 	if err != nil {
@@ -47,9 +63,47 @@ func (s *Settings) Read(r *reader.Reader) (err error) {
 		return err
 	}
 
+	s.HTTPCache, err = r.BoolPtr("HTTP_CACHE")
+	if err != nil {
+		return err
+	}
+
+	provenanceMode := r.String("PROVENANCE")
+	if provenanceMode != "" {
+		s.Provenance, err = provenance.ParseMode(provenanceMode)
+		if err != nil {
+			return err
+		}
+	}
+
 	s.HexSums.NamedRootMD5 = r.Get("NAMED_ROOT_MD5")
 	s.HexSums.RootAnchorsSHA256 = r.String("ROOT_ANCHORS_SHA256")
 
+	err = s.Resolver.read(r)
+	if err != nil {
+		return fmt.Errorf("reading resolver settings: %w", err)
+	}
+
+	err = s.Aggregation.read(r)
+	if err != nil {
+		return fmt.Errorf("reading aggregation settings: %w", err)
+	}
+
+	err = s.Guard.read(r)
+	if err != nil {
+		return fmt.Errorf("reading guard settings: %w", err)
+	}
+
+	err = s.Compression.read(r)
+	if err != nil {
+		return fmt.Errorf("reading compression settings: %w", err)
+	}
+
+	err = s.Changes.read(r)
+	if err != nil {
+		return fmt.Errorf("reading changes settings: %w", err)
+	}
+
 	err = s.Git.read(r)
 	if err != nil {
 		return fmt.Errorf("reading git settings: %w", err)
@@ -73,13 +127,20 @@ var (
 // SetDefaults sets the default values for the settings.
 func (s *Settings) SetDefaults() {
 	s.OutputDir = gosettings.DefaultComparable(s.OutputDir, "./files")
+	s.StateDir = gosettings.DefaultComparable(s.StateDir, "./state")
 	const defaultPeriod = 600 * time.Minute
 	s.Period = gosettings.DefaultComparable(s.Period, defaultPeriod)
 	s.ResolveHostnames = gosettings.DefaultPointer(s.ResolveHostnames, false)
 	const defaultHTTPTimeout = 10 * time.Second
 	s.HTTPTimeout = gosettings.DefaultComparable(s.HTTPTimeout, defaultHTTPTimeout)
+	s.HTTPCache = gosettings.DefaultPointer(s.HTTPCache, true)
 	s.HexSums.NamedRootMD5 = gosettings.DefaultPointer(s.HexSums.NamedRootMD5, "")
 	s.HexSums.RootAnchorsSHA256 = gosettings.DefaultComparable(s.HexSums.RootAnchorsSHA256, dnscrypto.RootAnchorsSHA256Sum)
+	s.Resolver.setDefaults()
+	s.Aggregation.setDefaults()
+	s.Guard.setDefaults()
+	s.Compression.setDefaults()
+	s.Changes.setDefaults()
 	s.Git.setDefaults()
 	s.Log.SetDefaults()
 	s.Shoutrrr.setDefaults()
@@ -92,6 +153,28 @@ func (s Settings) Validate() (err error) {
 		return fmt.Errorf("output directory: %w", err)
 	}
 
+	_, err = filepath.Abs(s.StateDir)
+	if err != nil {
+		return fmt.Errorf("state directory: %w", err)
+	}
+
+	if s.CatalogPath != "" {
+		err = checkFileExists(s.CatalogPath)
+		if err != nil {
+			return fmt.Errorf("checking catalog file: %w", err)
+		}
+	}
+
+	for _, allowlist := range s.Allowlists {
+		if strings.HasPrefix(allowlist, "http://") || strings.HasPrefix(allowlist, "https://") {
+			continue
+		}
+		err = checkFileExists(strings.TrimPrefix(allowlist, fetch.FileScheme))
+		if err != nil {
+			return fmt.Errorf("checking allowlist file: %w", err)
+		}
+	}
+
 	const minPeriod = 5 * time.Minute
 	switch {
 	case s.Period < minPeriod:
@@ -111,6 +194,21 @@ func (s Settings) Validate() (err error) {
 			s.HTTPTimeout, minHTTPTimeout)
 	}
 
+	err = s.Resolver.validate()
+	if err != nil {
+		return fmt.Errorf("validating resolver settings: %w", err)
+	}
+
+	err = s.Aggregation.validate()
+	if err != nil {
+		return fmt.Errorf("validating aggregation settings: %w", err)
+	}
+
+	err = s.Compression.validate()
+	if err != nil {
+		return fmt.Errorf("validating compression settings: %w", err)
+	}
+
 	err = s.Git.validate()
 	if err != nil {
 		return fmt.Errorf("validating git settings: %w", err)
@@ -136,11 +234,30 @@ func (s Settings) String() string {
 func (s Settings) toLinesNode() (node *gotree.Node) {
 	node = gotree.New("Settings summary:")
 	node.Appendf("output directory: %s", s.OutputDir)
+	node.Appendf("state directory: %s", s.StateDir)
+	if s.CatalogPath == "" {
+		node.Appendf("catalog file: [embedded default]")
+	} else {
+		node.Appendf("catalog file: %s", s.CatalogPath)
+	}
+	if len(s.Allowlists) > 0 {
+		allowlistsNode := node.Append("global allowlists:")
+		for _, allowlist := range s.Allowlists {
+			allowlistsNode.Append(allowlist)
+		}
+	}
 	node.Appendf("period: %s", s.Period)
 	node.Appendf("resolve hostnames: %s", gosettings.BoolToYesNo(s.ResolveHostnames))
 	node.Appendf("HTTP timeout: %s", s.HTTPTimeout)
+	node.Appendf("HTTP cache: %s", gosettings.BoolToYesNo(s.HTTPCache))
+	node.Appendf("provenance: %s", s.Provenance)
 	node.Appendf("named root MD5 sum: %s", *s.HexSums.NamedRootMD5)
 	node.Appendf("root anchors SHA256 sum: %s", s.HexSums.RootAnchorsSHA256)
+	node.AppendNode(s.Resolver.toLinesNode())
+	node.AppendNode(s.Aggregation.toLinesNode())
+	node.AppendNode(s.Guard.toLinesNode())
+	node.AppendNode(s.Compression.toLinesNode())
+	node.AppendNode(s.Changes.toLinesNode())
 	node.AppendNode(s.Git.toLinesNode())
 	node.AppendNode(s.Log.toLinesNode())
 	node.AppendNode(s.Shoutrrr.toLinesNode())
@@ -0,0 +1,170 @@
+// Package allowlist provides allowlists to remove hostnames and
+// IP addresses from block lists.
+package allowlist
+
+import (
+	"net/netip"
+	"strings"
+
+	"github.com/qdm12/updated/pkg/ips"
+)
+
+// Allowlist filters out hostnames and IP addresses matching its rules.
+type Allowlist struct {
+	rules     []Rule
+	exact     map[string]int
+	wildcards map[string]int
+	regexes   []int
+	prefixes  []int
+}
+
+// New creates an allowlist from the rules given.
+func New(rules []Rule) *Allowlist {
+	allowlist := &Allowlist{
+		rules:     rules,
+		exact:     make(map[string]int),
+		wildcards: make(map[string]int),
+	}
+	for i, rule := range rules {
+		switch rule.kind {
+		case kindHostname:
+			if _, exists := allowlist.exact[rule.hostname]; !exists {
+				allowlist.exact[rule.hostname] = i
+			}
+		case kindWildcard:
+			if _, exists := allowlist.wildcards[rule.hostname]; !exists {
+				allowlist.wildcards[rule.hostname] = i
+			}
+		case kindRegex:
+			allowlist.regexes = append(allowlist.regexes, i)
+		case kindPrefix:
+			allowlist.prefixes = append(allowlist.prefixes, i)
+		}
+	}
+	return allowlist
+}
+
+// Len returns the number of rules in the allowlist.
+func (a *Allowlist) Len() int {
+	return len(a.rules)
+}
+
+// RuleCount is the number of entries removed or narrowed by a rule.
+type RuleCount struct {
+	Rule  string
+	Count int
+}
+
+// FilterHostnames removes hostnames matching any of the hostname rules
+// of the allowlist. It returns the hostnames kept and the number of
+// hostnames removed by each hostname rule, in the order of the rules.
+func (a *Allowlist) FilterHostnames(hostnames []string) (kept []string, counts []RuleCount) {
+	ruleCounts := make([]int, len(a.rules))
+	kept = make([]string, 0, len(hostnames))
+	for _, hostname := range hostnames {
+		ruleIndex, matched := a.matchHostname(hostname)
+		if matched {
+			ruleCounts[ruleIndex]++
+			continue
+		}
+		kept = append(kept, hostname)
+	}
+	return kept, a.makeCounts(ruleCounts, false)
+}
+
+// FilterIPs removes the IP addresses and CIDRs covered by any of the IP
+// address or CIDR rules of the allowlist. A CIDR entry only partially
+// covered by rules is replaced by the minimal list of CIDRs and IP addresses
+// covering its addresses not allowed. It returns the entries kept and the
+// number of entries removed or narrowed by each IP address or CIDR rule,
+// in the order of the rules.
+func (a *Allowlist) FilterIPs(entries []string) (kept []string, counts []RuleCount) {
+	ruleCounts := make([]int, len(a.rules))
+	kept = make([]string, 0, len(entries))
+	for _, entry := range entries {
+		prefix, ruleIndices := a.matchIP(entry)
+		if len(ruleIndices) == 0 {
+			kept = append(kept, entry)
+			continue
+		}
+
+		allowed := make([]netip.Prefix, len(ruleIndices))
+		for i, ruleIndex := range ruleIndices {
+			ruleCounts[ruleIndex]++
+			allowed[i] = a.rules[ruleIndex].prefix
+		}
+		for _, remaining := range ips.SubtractPrefixes(prefix, allowed) {
+			if remaining.IsSingleIP() {
+				kept = append(kept, remaining.Addr().String())
+				continue
+			}
+			kept = append(kept, remaining.String())
+		}
+	}
+	return kept, a.makeCounts(ruleCounts, true)
+}
+
+func (a *Allowlist) matchHostname(hostname string) (ruleIndex int, matched bool) {
+	ruleIndex, matched = a.exact[hostname]
+	if matched {
+		return ruleIndex, true
+	}
+
+	parent := hostname
+	for {
+		dotIndex := strings.IndexByte(parent, '.')
+		if dotIndex == -1 {
+			break
+		}
+		parent = parent[dotIndex+1:]
+		ruleIndex, matched = a.wildcards[parent]
+		if matched {
+			return ruleIndex, true
+		}
+	}
+
+	for _, ruleIndex := range a.regexes {
+		if a.rules[ruleIndex].regex.MatchString(hostname) {
+			return ruleIndex, true
+		}
+	}
+
+	return 0, false
+}
+
+// matchIP returns the prefix of the IP address or CIDR given and the
+// indices of the rules overlapping it.
+func (a *Allowlist) matchIP(ip string) (prefix netip.Prefix, ruleIndices []int) {
+	if len(a.prefixes) == 0 {
+		return netip.Prefix{}, nil
+	}
+
+	prefix, err := netip.ParsePrefix(ip)
+	if err != nil {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil {
+			return netip.Prefix{}, nil
+		}
+		prefix = netip.PrefixFrom(addr, addr.BitLen())
+	}
+
+	for _, ruleIndex := range a.prefixes {
+		if a.rules[ruleIndex].prefix.Overlaps(prefix) {
+			ruleIndices = append(ruleIndices, ruleIndex)
+		}
+	}
+	return prefix, ruleIndices
+}
+
+func (a *Allowlist) makeCounts(ruleCounts []int, ipRules bool) (counts []RuleCount) {
+	for i, rule := range a.rules {
+		if (rule.kind == kindPrefix) != ipRules {
+			continue
+		}
+		counts = append(counts, RuleCount{
+			Rule:  rule.String(),
+			Count: ruleCounts[i],
+		})
+	}
+	return counts
+}
@@ -0,0 +1,88 @@
+package allowlist
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Allowlist(t *testing.T) {
+	t.Parallel()
+
+	rules, err := ParseRules([]string{
+		"# comment",
+		"example.com",
+		"*.example.net # trailing comment",
+		`/^ads[0-9]+\./`,
+		"",
+		"1.2.3.4",
+		"10.0.0.0/8",
+		"2001:db8::/32",
+	})
+	require.NoError(t, err)
+	allowlist := New(rules)
+	assert.Equal(t, 6, allowlist.Len())
+
+	hostnames := []string{
+		"example.com", "sub.example.com",
+		"example.net", "a.b.example.net",
+		"ads1.example.org", "ads.example.org",
+	}
+	kept, counts := allowlist.FilterHostnames(hostnames)
+	assert.Equal(t, []string{"sub.example.com", "example.net", "ads.example.org"}, kept)
+	assert.Equal(t, []RuleCount{
+		{Rule: "example.com", Count: 1},
+		{Rule: "*.example.net", Count: 1},
+		{Rule: `/^ads[0-9]+\./`, Count: 1},
+	}, counts)
+
+	ips := []string{
+		"1.2.3.4", "1.2.4.0/24",
+		"10.1.2.3", "10.1.0.0/16", "12.0.0.0/6",
+		"2001:db8::1", "2002::/16",
+	}
+	kept, counts = allowlist.FilterIPs(ips)
+	assert.Equal(t, []string{"1.2.4.0/24", "12.0.0.0/6", "2002::/16"}, kept)
+	assert.Equal(t, []RuleCount{
+		{Rule: "1.2.3.4", Count: 1},
+		{Rule: "10.0.0.0/8", Count: 2},
+		{Rule: "2001:db8::/32", Count: 1},
+	}, counts)
+}
+
+func Test_Allowlist_FilterIPs_partialOverlap(t *testing.T) {
+	t.Parallel()
+
+	rules, err := ParseRules([]string{"1.2.3.4", "1.2.3.128/25", "10.0.0.0/8", "2001:db8::/32"})
+	require.NoError(t, err)
+	allowlist := New(rules)
+
+	ips := []string{"1.2.3.0/24", "8.0.0.0/6", "2001:db8::/31"}
+	kept, counts := allowlist.FilterIPs(ips)
+	assert.Equal(t, []string{
+		"1.2.3.0/30", "1.2.3.5", "1.2.3.6/31", "1.2.3.8/29",
+		"1.2.3.16/28", "1.2.3.32/27", "1.2.3.64/26",
+		"8.0.0.0/7", "11.0.0.0/8",
+		"2001:db9::/32",
+	}, kept)
+	assert.Equal(t, []RuleCount{
+		{Rule: "1.2.3.4", Count: 1},
+		{Rule: "1.2.3.128/25", Count: 1},
+		{Rule: "10.0.0.0/8", Count: 1},
+		{Rule: "2001:db8::/32", Count: 1},
+	}, counts)
+}
+
+func Test_ParseRule(t *testing.T) {
+	t.Parallel()
+
+	_, err := ParseRule("/(/")
+	require.ErrorIs(t, err, ErrRuleRegexInvalid)
+
+	_, err = ParseRule("*.")
+	require.ErrorIs(t, err, ErrRuleWildcard)
+
+	_, err = ParseRule(" ")
+	require.ErrorIs(t, err, ErrRuleEmpty)
+}
@@ -0,0 +1,42 @@
+package allowlist
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/qdm12/updated/pkg/fetch"
+)
+
+// Fetch reads and parses the rules from the location given, which is
+// either a URL supported by [fetch.Open] or a local file path.
+func Fetch(ctx context.Context, client *http.Client, location string) (rules []Rule, err error) {
+	url := location
+	if !strings.Contains(location, "://") {
+		url = fetch.FileScheme + location
+	}
+
+	reader, err := fetch.Open(ctx, client, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		_ = reader.Close()
+		return nil, err
+	}
+
+	err = reader.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	rules, err = ParseRules(strings.Split(string(content), "\n"))
+	if err != nil {
+		return nil, fmt.Errorf("parsing rules from %s: %w", location, err)
+	}
+	return rules, nil
+}
@@ -0,0 +1,106 @@
+package allowlist
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"regexp"
+	"strings"
+)
+
+type ruleKind uint8
+
+const (
+	kindHostname ruleKind = iota
+	kindWildcard
+	kindRegex
+	kindPrefix
+)
+
+// Rule is a single allowlist rule.
+type Rule struct {
+	raw      string
+	kind     ruleKind
+	hostname string
+	regex    *regexp.Regexp
+	prefix   netip.Prefix
+}
+
+var (
+	ErrRuleEmpty        = errors.New("rule is empty")
+	ErrRuleRegexInvalid = errors.New("rule regular expression is not valid")
+	ErrRuleWildcard     = errors.New("rule wildcard is not valid")
+)
+
+// ParseRule parses a rule string which can be:
+//   - an exact hostname such as "example.com"
+//   - a wildcard suffix such as "*.example.com", matching all
+//     subdomains of example.com but not example.com itself
+//   - a regular expression enclosed in slashes such as "/^ads[0-9]+\./"
+//   - an IP address such as "1.2.3.4"
+//   - a CIDR such as "1.2.3.0/24" or "2001:db8::/32".
+func ParseRule(s string) (rule Rule, err error) {
+	s = strings.TrimSpace(s)
+	rule.raw = s
+	switch {
+	case s == "":
+		return Rule{}, ErrRuleEmpty
+	case len(s) > 2 && strings.HasPrefix(s, "/") && strings.HasSuffix(s, "/"):
+		rule.kind = kindRegex
+		rule.regex, err = regexp.Compile(s[1 : len(s)-1])
+		if err != nil {
+			return Rule{}, fmt.Errorf("%w: %w", ErrRuleRegexInvalid, err)
+		}
+		return rule, nil
+	case strings.HasPrefix(s, "*."):
+		rule.kind = kindWildcard
+		rule.hostname = strings.ToLower(strings.TrimPrefix(s, "*."))
+		if rule.hostname == "" || strings.Contains(rule.hostname, "*") {
+			return Rule{}, fmt.Errorf("%w: %s", ErrRuleWildcard, s)
+		}
+		return rule, nil
+	}
+
+	if prefix, err := netip.ParsePrefix(s); err == nil {
+		rule.kind = kindPrefix
+		rule.prefix = prefix.Masked()
+		return rule, nil
+	}
+
+	if ip, err := netip.ParseAddr(s); err == nil {
+		rule.kind = kindPrefix
+		rule.prefix = netip.PrefixFrom(ip, ip.BitLen())
+		return rule, nil
+	}
+
+	rule.kind = kindHostname
+	rule.hostname = strings.ToLower(s)
+	return rule, nil
+}
+
+// String returns the rule as it was given to [ParseRule].
+func (r Rule) String() string {
+	return r.raw
+}
+
+// ParseRules parses rules from lines, ignoring empty lines and
+// '#' comments.
+func ParseRules(lines []string) (rules []Rule, err error) {
+	rules = make([]Rule, 0, len(lines))
+	for i, line := range lines {
+		commentIndex := strings.IndexByte(line, '#')
+		if commentIndex >= 0 {
+			line = line[:commentIndex]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		rule, err := ParseRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
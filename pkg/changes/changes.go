@@ -0,0 +1,139 @@
+// Package changes computes the entries added to and removed from
+// lists between two versions, and records them in JSON files.
+package changes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// List holds the changes of a single list.
+type List struct {
+	// Name is the name of the list, such as "malicious hostnames".
+	Name string `json:"name"`
+	// Filename is the filename of the list.
+	Filename string `json:"filename"`
+	// Added are the entries added to the list, sorted.
+	Added []string `json:"added"`
+	// Removed are the entries removed from the list, sorted.
+	Removed []string `json:"removed"`
+}
+
+// New returns the changes of the list with the name and filename given,
+// from its previous entries to its current entries.
+func New(name, filename string, previous, current []string) List {
+	previousSet := make(map[string]struct{}, len(previous))
+	for _, entry := range previous {
+		previousSet[entry] = struct{}{}
+	}
+	currentSet := make(map[string]struct{}, len(current))
+	for _, entry := range current {
+		currentSet[entry] = struct{}{}
+	}
+
+	list := List{
+		Name:     name,
+		Filename: filename,
+		Added:    []string{},
+		Removed:  []string{},
+	}
+	for entry := range currentSet {
+		if _, ok := previousSet[entry]; !ok {
+			list.Added = append(list.Added, entry)
+		}
+	}
+	for entry := range previousSet {
+		if _, ok := currentSet[entry]; !ok {
+			list.Removed = append(list.Removed, entry)
+		}
+	}
+	slices.Sort(list.Added)
+	slices.Sort(list.Removed)
+	return list
+}
+
+// Empty returns true if no entry was added or removed.
+func (l List) Empty() bool {
+	return len(l.Added) == 0 && len(l.Removed) == 0
+}
+
+// Summary returns a single line summary of the number
+// of entries added and removed.
+func (l List) Summary() string {
+	return fmt.Sprintf("%s: %d added, %d removed", l.Name, len(l.Added), len(l.Removed))
+}
+
+// Details returns the summary followed by the first entries added and
+// removed, up to maxEntries for each, one per line prefixed with + or -.
+func (l List) Details(maxEntries int) string {
+	lines := []string{l.Summary()}
+	lines = appendEntries(lines, "+ ", l.Added, maxEntries)
+	lines = appendEntries(lines, "- ", l.Removed, maxEntries)
+	return strings.Join(lines, "\n")
+}
+
+func appendEntries(lines []string, prefix string, entries []string, maxEntries int) []string {
+	for i, entry := range entries {
+		if i == maxEntries {
+			lines = append(lines, fmt.Sprintf("%sand %d more", prefix, len(entries)-maxEntries))
+			break
+		}
+		lines = append(lines, prefix+entry)
+	}
+	return lines
+}
+
+// Run holds the changes of the lists published by a run.
+type Run struct {
+	// Time is the time at which the run published the lists.
+	Time time.Time `json:"time"`
+	// Lists are the changes of each list changed.
+	Lists []List `json:"lists"`
+}
+
+// Append reads the JSON array of runs stored in the file at the previous
+// path given, if it exists, appends the run to it and writes it to the
+// file at the path given, creating its parent directory if it does not
+// exist. Both paths can be the same, and the file is replaced atomically.
+func Append(previousPath, path string, run Run) (err error) {
+	var runs []Run
+	data, err := os.ReadFile(previousPath) //nolint:gosec
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return err
+	default:
+		err = json.Unmarshal(data, &runs)
+		if err != nil {
+			return fmt.Errorf("decoding %s: %w", filepath.Base(path), err)
+		}
+	}
+	runs = append(runs, run)
+
+	data, err = json.MarshalIndent(runs, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding: %w", err)
+	}
+	data = append(data, '\n')
+
+	const dirPerms = 0o700
+	err = os.MkdirAll(filepath.Dir(path), dirPerms)
+	if err != nil {
+		return fmt.Errorf("creating directory: %w", err)
+	}
+
+	tempPath := path + ".tmp"
+	const perms = 0o600
+	err = os.WriteFile(tempPath, data, perms)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tempPath, path)
+}
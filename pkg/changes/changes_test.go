@@ -0,0 +1,86 @@
+package changes
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_List(t *testing.T) {
+	t.Parallel()
+
+	list := New("malicious hostnames", "malicious-hostnames.updated",
+		[]string{"a.com", "b.com", "c.com"},
+		[]string{"d.com", "a.com", "f.com", "e.com"})
+
+	expected := List{
+		Name:     "malicious hostnames",
+		Filename: "malicious-hostnames.updated",
+		Added:    []string{"d.com", "e.com", "f.com"},
+		Removed:  []string{"b.com", "c.com"},
+	}
+	assert.Equal(t, expected, list)
+	assert.False(t, list.Empty())
+	assert.Equal(t, "malicious hostnames: 3 added, 2 removed", list.Summary())
+
+	const expectedDetails = "malicious hostnames: 3 added, 2 removed\n" +
+		"+ d.com\n+ e.com\n+ and 1 more\n" +
+		"- b.com\n- c.com"
+	assert.Equal(t, expectedDetails, list.Details(2))
+
+	assert.True(t, New("", "", []string{"a.com"}, []string{"a.com"}).Empty())
+}
+
+func Test_Append(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "changes", "2024-05-06.json")
+	first := Run{
+		Time:  time.Date(2024, 5, 6, 1, 0, 0, 0, time.UTC),
+		Lists: []List{{Name: "a", Filename: "a", Added: []string{"x"}, Removed: []string{}}},
+	}
+	second := Run{
+		Time:  time.Date(2024, 5, 6, 2, 0, 0, 0, time.UTC),
+		Lists: []List{{Name: "b", Filename: "b", Added: []string{}, Removed: []string{"y"}}},
+	}
+
+	require.NoError(t, Append(path, path, first))
+	require.NoError(t, Append(path, path, second))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	const expected = `[
+  {
+    "time": "2024-05-06T01:00:00Z",
+    "lists": [
+      {
+        "name": "a",
+        "filename": "a",
+        "added": [
+          "x"
+        ],
+        "removed": []
+      }
+    ]
+  },
+  {
+    "time": "2024-05-06T02:00:00Z",
+    "lists": [
+      {
+        "name": "b",
+        "filename": "b",
+        "added": [],
+        "removed": [
+          "y"
+        ]
+      }
+    ]
+  }
+]
+`
+	assert.Equal(t, expected, string(data))
+}
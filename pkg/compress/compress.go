@@ -0,0 +1,153 @@
+// Package compress writes deterministic compressed copies of files,
+// so that the compressed copies only change when the files content changes.
+package compress
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Format is a compression format.
+type Format string
+
+const (
+	// FormatGzip is the gzip compression format, with levels from 1 to 9.
+	FormatGzip Format = "gzip"
+	// FormatZstd is the zstd compression format, with levels from 1 to 22.
+	FormatZstd Format = "zstd"
+	// FormatBrotli is the brotli compression format, with levels from 0 to 11.
+	FormatBrotli Format = "brotli"
+)
+
+// Formats returns all the compression formats supported.
+func Formats() []Format {
+	return []Format{FormatGzip, FormatZstd, FormatBrotli}
+}
+
+var (
+	ErrFormatNotValid = errors.New("compression format is not valid")
+	ErrLevelNotValid  = errors.New("compression level is not valid")
+)
+
+// Extension returns the file extension of the format, including its dot,
+// or an error if the format is not supported.
+func (f Format) Extension() (extension string, err error) {
+	switch f {
+	case FormatGzip:
+		return ".gz", nil
+	case FormatZstd:
+		return ".zst", nil
+	case FormatBrotli:
+		return ".br", nil
+	default:
+		return "", fmt.Errorf("%w: %q must be one of gzip, zstd or brotli",
+			ErrFormatNotValid, string(f))
+	}
+}
+
+// Validate returns an error if the format is not supported,
+// or if the level is out of the levels range of the format.
+func (f Format) Validate(level int) (err error) {
+	var minLevel, maxLevel int
+	switch f {
+	case FormatGzip:
+		minLevel, maxLevel = gzip.BestSpeed, gzip.BestCompression
+	case FormatZstd:
+		const zstdMaxLevel = 22
+		minLevel, maxLevel = 1, zstdMaxLevel
+	case FormatBrotli:
+		minLevel, maxLevel = brotli.BestSpeed, brotli.BestCompression
+	default:
+		return fmt.Errorf("%w: %q must be one of gzip, zstd or brotli",
+			ErrFormatNotValid, string(f))
+	}
+
+	if level < minLevel || level > maxLevel {
+		return fmt.Errorf("%w: %d for %s must be between %d and %d",
+			ErrLevelNotValid, level, f, minLevel, maxLevel)
+	}
+	return nil
+}
+
+// File writes a compressed copy of the file at the path given to the
+// compressed path given, atomically. The compressed copy is deterministic:
+// its content only depends on the file content, format and level.
+func File(path, compressedPath string, format Format, level int) (err error) {
+	tempPath := compressedPath + ".tmp"
+	err = writeCompressed(path, tempPath, format, level)
+	if err != nil {
+		_ = os.Remove(tempPath)
+		return err
+	}
+
+	err = os.Rename(tempPath, compressedPath)
+	if err != nil {
+		_ = os.Remove(tempPath)
+		return err
+	}
+	return nil
+}
+
+func writeCompressed(sourcePath, path string, format Format, level int) (err error) {
+	source, err := os.Open(sourcePath) //nolint:gosec
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	const perms = 0o600
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perms) //nolint:gosec
+	if err != nil {
+		return err
+	}
+
+	buffered := bufio.NewWriter(file)
+	err = compress(buffered, source, format, level)
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("compressing with %s: %w", format, err)
+	}
+
+	err = buffered.Flush()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// compress compresses the reader content to the writer, in a
+// deterministic way for the format and level given.
+func compress(writer io.Writer, reader io.Reader, format Format, level int) (err error) {
+	var compressor io.WriteCloser
+	switch format {
+	case FormatGzip:
+		// The gzip header is left with a zero modification time and no name.
+		compressor, err = gzip.NewWriterLevel(writer, level)
+	case FormatZstd:
+		compressor, err = zstd.NewWriter(writer,
+			zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)),
+			zstd.WithEncoderConcurrency(1))
+	case FormatBrotli:
+		compressor = brotli.NewWriterLevel(writer, level)
+	default:
+		return fmt.Errorf("%w: %s", ErrFormatNotValid, format)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(compressor, reader)
+	if err != nil {
+		_ = compressor.Close()
+		return err
+	}
+	return compressor.Close()
+}
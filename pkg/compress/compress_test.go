@@ -0,0 +1,84 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_File(t *testing.T) {
+	t.Parallel()
+
+	content := bytes.Repeat([]byte("a.example.com\nb.example.com\n"), 1000)
+	decompressors := map[Format]func(reader io.Reader) (io.Reader, error){
+		FormatGzip: func(reader io.Reader) (io.Reader, error) { return gzip.NewReader(reader) },
+		FormatZstd: func(reader io.Reader) (io.Reader, error) { return zstd.NewReader(reader) },
+		FormatBrotli: func(reader io.Reader) (io.Reader, error) {
+			return brotli.NewReader(reader), nil
+		},
+	}
+
+	for _, format := range Formats() {
+		t.Run(string(format), func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			path := filepath.Join(dir, "list")
+			require.NoError(t, os.WriteFile(path, content, 0o600))
+
+			extension, err := format.Extension()
+			require.NoError(t, err)
+			compressedPath := path + extension
+			err = File(path, compressedPath, format, 5)
+			require.NoError(t, err)
+			first, err := os.ReadFile(compressedPath)
+			require.NoError(t, err)
+
+			decompressor, err := decompressors[format](bytes.NewReader(first))
+			require.NoError(t, err)
+			decompressed, err := io.ReadAll(decompressor)
+			require.NoError(t, err)
+			assert.Equal(t, content, decompressed)
+
+			// Rewriting the file with a new modification time gives the same bytes.
+			require.NoError(t, os.WriteFile(path, content, 0o600))
+			later := time.Now().Add(time.Hour)
+			require.NoError(t, os.Chtimes(path, later, later))
+			err = File(path, compressedPath, format, 5)
+			require.NoError(t, err)
+			second, err := os.ReadFile(compressedPath)
+			require.NoError(t, err)
+			assert.Equal(t, first, second)
+		})
+	}
+}
+
+func Test_Format_Validate(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, FormatBrotli.Validate(0))
+	assert.EqualError(t, FormatGzip.Validate(0),
+		"compression level is not valid: 0 for gzip must be between 1 and 9")
+	assert.EqualError(t, Format("xz").Validate(1),
+		`compression format is not valid: "xz" must be one of gzip, zstd or brotli`)
+}
+
+func Test_Format_Extension(t *testing.T) {
+	t.Parallel()
+
+	extension, err := FormatZstd.Extension()
+	require.NoError(t, err)
+	assert.Equal(t, ".zst", extension)
+
+	_, err = Format("xz").Extension()
+	assert.ErrorIs(t, err, ErrFormatNotValid)
+}
@@ -9,10 +9,12 @@ import (
 	"net/http"
 )
 
+// NamedRootURL is the URL the named.root file is downloaded from.
+const NamedRootURL = "https://www.internic.net/domain/named.root"
+
 // DownloadNamedRoot downloads the named.root and returns it.
 func (d *DNSCrypto) DownloadNamedRoot(ctx context.Context) (namedRoot []byte, err error) {
-	const url = "https://www.internic.net/domain/named.root"
-	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, NamedRootURL, nil)
 	if err != nil {
 		return nil, err
 	}
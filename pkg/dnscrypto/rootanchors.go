@@ -11,10 +11,12 @@ import (
 	"time"
 )
 
+// RootAnchorsURL is the URL the root anchors XML file is downloaded from.
+const RootAnchorsURL = "https://data.iana.org/root-anchors/root-anchors.xml"
+
 // DownloadRootAnchorsXML fetches the root anchors XML file online and parses it.
 func (d *DNSCrypto) DownloadRootAnchorsXML(ctx context.Context) (rootAnchorsXML []byte, err error) {
-	const url = "https://data.iana.org/root-anchors/root-anchors.xml"
-	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, RootAnchorsURL, nil)
 	if err != nil {
 		return nil, err
 	}
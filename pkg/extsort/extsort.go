@@ -0,0 +1,227 @@
+// Package extsort sorts and deduplicates large sets of strings using
+// a bounded amount of memory, spilling sorted runs to temporary files
+// and merging them back.
+package extsort
+
+import (
+	"bufio"
+	"container/heap"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Sorter sorts and deduplicates strings. Strings must not contain
+// the new line character. A sorter is not safe for concurrent use.
+type Sorter struct {
+	dir         string
+	maxInMemory int
+	buffer      []string
+	runs        []*os.File
+}
+
+// New creates a new sorter keeping at most maxInMemory strings in memory
+// before spilling them as a sorted run to a temporary file in the directory
+// given, created if needed. If dir is empty, the default directory for
+// temporary files is used.
+// The sorter must be closed with [Sorter.Close] to remove its temporary files.
+func New(dir string, maxInMemory int) *Sorter {
+	return &Sorter{
+		dir:         dir,
+		maxInMemory: max(maxInMemory, 1),
+	}
+}
+
+// Add adds a string to the sorter.
+func (s *Sorter) Add(value string) (err error) {
+	s.buffer = append(s.buffer, value)
+	if len(s.buffer) < s.maxInMemory {
+		return nil
+	}
+	return s.spill()
+}
+
+// Each calls fn for each unique string added, in ascending order.
+// No string should be added once Each is called.
+func (s *Sorter) Each(fn func(value string)) (err error) {
+	s.sortBuffer()
+	if len(s.runs) == 0 {
+		for _, value := range s.buffer {
+			fn(value)
+		}
+		return nil
+	}
+
+	merger := make(mergeHeap, 0, len(s.runs)+1)
+	for _, file := range s.runs {
+		_, err = file.Seek(0, io.SeekStart)
+		if err != nil {
+			return fmt.Errorf("rewinding run file: %w", err)
+		}
+		source := &fileSource{reader: bufio.NewReader(file)}
+		merger, err = merger.push(source)
+		if err != nil {
+			return err
+		}
+	}
+	merger, err = merger.push(&sliceSource{values: s.buffer})
+	if err != nil {
+		return err
+	}
+	heap.Init(&merger)
+
+	previous := ""
+	first := true
+	for len(merger) > 0 {
+		head := merger[0]
+		if first || head.value != previous {
+			fn(head.value)
+			previous = head.value
+			first = false
+		}
+
+		var ok bool
+		head.value, ok, err = head.source.next()
+		switch {
+		case err != nil:
+			return err
+		case ok:
+			heap.Fix(&merger, 0)
+		default:
+			heap.Pop(&merger)
+		}
+	}
+	return nil
+}
+
+// Close removes the temporary files of the sorter.
+func (s *Sorter) Close() (err error) {
+	var errs []error
+	for _, file := range s.runs {
+		err = file.Close()
+		if err != nil {
+			errs = append(errs, err)
+		}
+		err = os.Remove(file.Name())
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	s.runs = nil
+	s.buffer = nil
+	return errors.Join(errs...)
+}
+
+// sortBuffer sorts and deduplicates the in memory buffer in place.
+func (s *Sorter) sortBuffer() {
+	slices.Sort(s.buffer)
+	s.buffer = slices.Compact(s.buffer)
+}
+
+func (s *Sorter) spill() (err error) {
+	s.sortBuffer()
+
+	if s.dir != "" {
+		const dirPerms = 0o700
+		err = os.MkdirAll(s.dir, dirPerms)
+		if err != nil {
+			return fmt.Errorf("creating directory: %w", err)
+		}
+	}
+
+	file, err := os.CreateTemp(s.dir, "extsort-*.run")
+	if err != nil {
+		return fmt.Errorf("creating run file: %w", err)
+	}
+	s.runs = append(s.runs, file)
+
+	writer := bufio.NewWriter(file)
+	for _, value := range s.buffer {
+		_, err = writer.WriteString(value)
+		if err == nil {
+			err = writer.WriteByte('\n')
+		}
+		if err != nil {
+			return fmt.Errorf("writing run file: %w", err)
+		}
+	}
+	err = writer.Flush()
+	if err != nil {
+		return fmt.Errorf("writing run file: %w", err)
+	}
+
+	clear(s.buffer)
+	s.buffer = s.buffer[:0]
+	return nil
+}
+
+type source interface {
+	next() (value string, ok bool, err error)
+}
+
+type fileSource struct {
+	reader *bufio.Reader
+}
+
+func (f *fileSource) next() (value string, ok bool, err error) {
+	value, err = f.reader.ReadString('\n')
+	switch {
+	case errors.Is(err, io.EOF) && value == "":
+		return "", false, nil
+	case err != nil && !errors.Is(err, io.EOF):
+		return "", false, fmt.Errorf("reading run file: %w", err)
+	}
+	return strings.TrimSuffix(value, "\n"), true, nil
+}
+
+type sliceSource struct {
+	values []string
+}
+
+func (s *sliceSource) next() (value string, ok bool, err error) {
+	if len(s.values) == 0 {
+		return "", false, nil
+	}
+	value = s.values[0]
+	s.values = s.values[1:]
+	return value, true, nil
+}
+
+type mergeItem struct {
+	value  string
+	source source
+}
+
+type mergeHeap []*mergeItem
+
+func (h mergeHeap) Len() int           { return len(h) }
+func (h mergeHeap) Less(i, j int) bool { return h[i].value < h[j].value }
+func (h mergeHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *mergeHeap) Push(x any) {
+	*h = append(*h, x.(*mergeItem)) //nolint:forcetypeassert
+}
+
+func (h *mergeHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return item
+}
+
+// push pushes the first value of the source given, if any,
+// without restoring the heap invariant.
+func (h mergeHeap) push(source source) (mergeHeap, error) {
+	value, ok, err := source.next()
+	if err != nil {
+		return nil, err
+	} else if !ok {
+		return h, nil
+	}
+	return append(h, &mergeItem{value: value, source: source}), nil
+}
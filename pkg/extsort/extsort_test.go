@@ -0,0 +1,120 @@
+package extsort
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Sorter(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		maxInMemory int
+		values      []string
+		sorted      []string
+	}{
+		"empty": {
+			maxInMemory: 2,
+		},
+		"in memory": {
+			maxInMemory: 10,
+			values:      []string{"c", "a", "b", "a", "c"},
+			sorted:      []string{"a", "b", "c"},
+		},
+		"spilled runs": {
+			maxInMemory: 2,
+			values:      []string{"e", "c", "a", "b", "a", "d", "c", "e", "f"},
+			sorted:      []string{"a", "b", "c", "d", "e", "f"},
+		},
+		"empty string": {
+			maxInMemory: 1,
+			values:      []string{"b", "", "a", ""},
+			sorted:      []string{"", "a", "b"},
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			dir := t.TempDir()
+
+			sorter := New(dir, testCase.maxInMemory)
+			for _, value := range testCase.values {
+				err := sorter.Add(value)
+				require.NoError(t, err)
+			}
+
+			var sorted []string
+			err := sorter.Each(func(value string) {
+				sorted = append(sorted, value)
+			})
+			require.NoError(t, err)
+			assert.Equal(t, testCase.sorted, sorted)
+
+			err = sorter.Close()
+			require.NoError(t, err)
+			entries, err := os.ReadDir(dir)
+			require.NoError(t, err)
+			assert.Empty(t, entries)
+		})
+	}
+}
+
+func makeValues(count, unique int) (values []string) {
+	values = make([]string, count)
+	random := rand.New(rand.NewPCG(1, 2)) //nolint:gosec
+	for i := range values {
+		values[i] = fmt.Sprintf("host%d.example.com", random.IntN(unique))
+	}
+	return values
+}
+
+// Benchmark_mapSort is the map and sorted copy approach
+// to compare with [Benchmark_Sorter].
+func Benchmark_mapSort(b *testing.B) {
+	values := makeValues(5_000_000, 2_000_000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for b.Loop() {
+		unique := make(map[string]bool)
+		for _, value := range values {
+			unique[value] = true
+		}
+		sorted := make([]string, 0, len(unique))
+		for value := range unique {
+			sorted = append(sorted, value)
+		}
+		slices.Sort(sorted)
+	}
+}
+
+func Benchmark_Sorter(b *testing.B) {
+	values := makeValues(5_000_000, 2_000_000)
+	dir := b.TempDir()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for b.Loop() {
+		sorter := New(dir, 1_000_000)
+		for _, value := range values {
+			err := sorter.Add(value)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+		count := 0
+		err := sorter.Each(func(string) { count++ })
+		if err != nil {
+			b.Fatal(err)
+		}
+		err = sorter.Close()
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
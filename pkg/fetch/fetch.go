@@ -0,0 +1,173 @@
+// Package fetch opens list sources from HTTP(S) URLs, local files and
+// local directories, decompressing and extracting them as needed.
+package fetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/qdm12/updated/pkg/unpack"
+)
+
+var (
+	ErrBadStatusCode      = errors.New("bad HTTP status code")
+	ErrSchemeNotSupported = errors.New("URL scheme is not supported")
+	ErrNoFileMatched      = errors.New("no file matched in directory")
+)
+
+// FileScheme is the URL scheme prefix for local files and directories.
+const FileScheme = "file://"
+
+// Open opens the source at the URL given, which can be an HTTP(S) URL,
+// or a file:// URL pointing to a local file or directory. For example
+// "file:///lists/a.txt" is the absolute path /lists/a.txt and
+// "file://lists/a.txt" is the relative path lists/a.txt.
+//
+// Content is decompressed and extracted with [unpack.Open], where members
+// are the patterns of the archive members to read. For a directory,
+// members are instead the [path.Match] patterns of the file paths relative
+// to the directory to read, and all the files of the directory and its
+// subdirectories are read if members is empty. Each file read from a
+// directory is itself decompressed and extracted as needed.
+//
+// The reader returned must be closed by the caller.
+func Open(ctx context.Context, client *http.Client, url string,
+	members []string,
+) (reader io.ReadCloser, err error) {
+	switch {
+	case strings.HasPrefix(url, "http://"), strings.HasPrefix(url, "https://"):
+		return openHTTP(ctx, client, url, members)
+	case strings.HasPrefix(url, FileScheme):
+		return openFile(strings.TrimPrefix(url, FileScheme), members)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrSchemeNotSupported, url)
+	}
+}
+
+func openHTTP(ctx context.Context, client *http.Client, url string,
+	members []string,
+) (reader io.ReadCloser, err error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	} else if response.StatusCode != http.StatusOK {
+		_ = response.Body.Close()
+		return nil, fmt.Errorf("%w: %d %s", ErrBadStatusCode, response.StatusCode, response.Status)
+	}
+
+	info := unpack.Info{
+		Name:            response.Request.URL.Path,
+		ContentEncoding: response.Header.Get("Content-Encoding"),
+		ContentType:     response.Header.Get("Content-Type"),
+	}
+	reader, err = unpack.Open(response.Body, info, members)
+	if err != nil {
+		return nil, fmt.Errorf("unpacking: %w", err)
+	}
+	return reader, nil
+}
+
+func openFile(filePath string, members []string) (reader io.ReadCloser, err error) {
+	stat, err := os.Stat(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if !stat.IsDir() {
+		file, err := os.Open(filePath) //nolint:gosec
+		if err != nil {
+			return nil, err
+		}
+		reader, err = unpack.Open(file, unpack.Info{Name: filePath}, members)
+		if err != nil {
+			return nil, fmt.Errorf("unpacking: %w", err)
+		}
+		return reader, nil
+	}
+
+	filePaths, err := listDirectory(filePath, members)
+	if err != nil {
+		return nil, err
+	}
+
+	return unpack.Concat(func() (reader io.ReadCloser, err error) {
+		if len(filePaths) == 0 {
+			return nil, io.EOF
+		}
+		filePath := filePaths[0]
+		filePaths = filePaths[1:]
+		file, err := os.Open(filePath) //nolint:gosec
+		if err != nil {
+			return nil, err
+		}
+		reader, err = unpack.Open(file, unpack.Info{Name: filePath}, nil)
+		if err != nil {
+			return nil, fmt.Errorf("unpacking %s: %w", filePath, err)
+		}
+		return reader, nil
+	}), nil
+}
+
+// listDirectory returns the paths of the regular files in the directory
+// and its subdirectories, in lexical order, whose path relative to the
+// directory matches any of the patterns given. All regular files are
+// returned if patterns is empty.
+func listDirectory(directory string, patterns []string) (filePaths []string, err error) {
+	for _, pattern := range patterns {
+		_, err = path.Match(pattern, "")
+		if err != nil {
+			return nil, fmt.Errorf("file pattern %q: %w", pattern, err)
+		}
+	}
+
+	err = filepath.WalkDir(directory, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		} else if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		relativePath, err := filepath.Rel(directory, filePath)
+		if err != nil {
+			return err
+		}
+		if matchAny(filepath.ToSlash(relativePath), patterns) {
+			filePaths = append(filePaths, filePath)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing directory: %w", err)
+	}
+
+	if len(filePaths) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNoFileMatched, directory)
+	}
+	return filePaths, nil
+}
+
+func matchAny(name string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		matched, _ := path.Match(pattern, name)
+		if matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,98 @@
+package fetch
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Open(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/list.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte("http.com\n"))
+	}))
+	t.Cleanup(server.Close)
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.txt":     "a.com\n",
+		"b.txt":     "b.com",
+		"c.md":      "c.com\n",
+		"sub/d.txt": "d.com\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o700))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	}
+
+	testCases := map[string]struct {
+		url        string
+		members    []string
+		content    string
+		errWrapped error
+	}{
+		"http": {
+			url:     server.URL + "/list.txt",
+			content: "http.com\n",
+		},
+		"http not found": {
+			url:        server.URL + "/missing.txt",
+			errWrapped: ErrBadStatusCode,
+		},
+		"file": {
+			url:     "file://" + filepath.Join(dir, "a.txt"),
+			content: "a.com\n",
+		},
+		"file not found": {
+			url:        "file://" + filepath.Join(dir, "missing.txt"),
+			errWrapped: os.ErrNotExist,
+		},
+		"directory": {
+			url:     "file://" + dir,
+			content: "a.com\n\nb.com\nc.com\n\nd.com\n",
+		},
+		"directory members": {
+			url:     "file://" + dir,
+			members: []string{"*.txt"},
+			content: "a.com\n\nb.com",
+		},
+		"directory no match": {
+			url:        "file://" + dir,
+			members:    []string{"*.csv"},
+			errWrapped: ErrNoFileMatched,
+		},
+		"unsupported scheme": {
+			url:        "ftp://example.com/list.txt",
+			errWrapped: ErrSchemeNotSupported,
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			reader, err := Open(t.Context(), server.Client(), testCase.url, testCase.members)
+			require.ErrorIs(t, err, testCase.errWrapped)
+			if testCase.errWrapped != nil {
+				return
+			}
+
+			content, err := io.ReadAll(reader)
+			require.NoError(t, err)
+			assert.Equal(t, testCase.content, string(content))
+			require.NoError(t, reader.Close())
+		})
+	}
+}
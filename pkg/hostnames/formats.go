@@ -0,0 +1,194 @@
+package hostnames
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// ParseFunc parses a single pre-cleaned and transformed line, and returns
+// the hostnames it blocks and the hostnames it explicitly allows.
+// Allowed hostnames apply to their subdomains as well.
+type ParseFunc func(line string) (blocked, allowed []string)
+
+const (
+	// FormatPlain is the format for lists with one hostname per line.
+	FormatPlain = "plain"
+	// FormatHosts is the format for hosts files, such as "0.0.0.0 a.com b.com".
+	FormatHosts = "hosts"
+	// FormatDnsmasq is the format for dnsmasq configuration files,
+	// such as "address=/a.com/0.0.0.0" or "server=/a.com/".
+	FormatDnsmasq = "dnsmasq"
+	// FormatUnbound is the format for Unbound configuration files,
+	// such as `local-zone: "a.com" redirect`.
+	FormatUnbound = "unbound"
+	// FormatAdblock is the format for AdBlock and uBlock domain rules,
+	// such as "||a.com^". Exception rules such as "@@||a.com^" are
+	// returned as allowed hostnames.
+	FormatAdblock = "adblock"
+	// FormatRPZ is the format for response policy zone files,
+	// such as "a.com CNAME .". Records with a "rpz-passthru." target
+	// are returned as allowed hostnames.
+	FormatRPZ = "rpz"
+)
+
+func defaultFormats() map[string]ParseFunc {
+	return map[string]ParseFunc{
+		FormatPlain:   parsePlain,
+		FormatHosts:   parseHosts,
+		FormatDnsmasq: parseDnsmasq,
+		FormatUnbound: parseUnbound,
+		FormatAdblock: parseAdblock,
+		FormatRPZ:     parseRPZ,
+	}
+}
+
+// RegisterFormat registers a custom format parser with the name given,
+// overriding any existing format of the same name.
+func (b *Builder) RegisterFormat(name string, parse ParseFunc) {
+	b.formatsMu.Lock()
+	defer b.formatsMu.Unlock()
+	b.formats[name] = parse
+}
+
+var ErrFormatUnknown = errors.New("format is unknown")
+
+func (b *Builder) getFormat(name string) (parse ParseFunc, err error) {
+	if name == "" {
+		name = FormatPlain
+	}
+	b.formatsMu.RLock()
+	defer b.formatsMu.RUnlock()
+	parse, ok := b.formats[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrFormatUnknown, name)
+	}
+	return parse, nil
+}
+
+func parsePlain(line string) (blocked, _ []string) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	return fields[:1], nil
+}
+
+func parseHosts(line string) (blocked, _ []string) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 { //nolint:mnd
+		return nil, nil
+	}
+	_, err := netip.ParseAddr(fields[0])
+	if err != nil {
+		return nil, nil
+	}
+	blocked = make([]string, 0, len(fields)-1)
+	for _, hostname := range fields[1:] {
+		if isLocalHostname(hostname) {
+			continue
+		}
+		blocked = append(blocked, hostname)
+	}
+	return blocked, nil
+}
+
+func isLocalHostname(hostname string) bool {
+	switch hostname {
+	case "localhost", "localhost.localdomain", "local", "broadcasthost",
+		"0.0.0.0", "ip6-localhost", "ip6-loopback", "ip6-localnet",
+		"ip6-mcastprefix", "ip6-allnodes", "ip6-allrouters", "ip6-allhosts":
+		return true
+	default:
+		return false
+	}
+}
+
+func parseDnsmasq(line string) (blocked, _ []string) {
+	// address=/a.com/b.com/0.0.0.0, server=/a.com/ or local=/a.com/
+	_, value, found := strings.Cut(line, "=")
+	if !found || !strings.HasPrefix(value, "/") {
+		return nil, nil
+	}
+	value = strings.TrimPrefix(value, "/")
+	lastSlashIndex := strings.LastIndexByte(value, '/')
+	if lastSlashIndex == -1 {
+		return nil, nil
+	}
+	for hostname := range strings.SplitSeq(value[:lastSlashIndex], "/") {
+		if hostname == "" {
+			continue
+		}
+		blocked = append(blocked, hostname)
+	}
+	return blocked, nil
+}
+
+func parseUnbound(line string) (blocked, _ []string) {
+	// local-zone: "a.com" redirect
+	value, found := strings.CutPrefix(line, "local-zone:")
+	if !found {
+		return nil, nil
+	}
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	hostname := strings.Trim(fields[0], `"`)
+	if len(fields) > 1 && (fields[1] == "transparent" || fields[1] == "typetransparent") {
+		return nil, nil
+	}
+	return []string{hostname}, nil
+}
+
+func parseAdblock(line string) (blocked, allowed []string) {
+	// ||a.com^, ||a.com^$important or @@||a.com^
+	value, isException := strings.CutPrefix(line, "@@")
+	value, found := strings.CutPrefix(value, "||")
+	if !found {
+		return nil, nil
+	}
+	value, _, _ = strings.Cut(value, "$")
+	hostname, found := strings.CutSuffix(value, "^")
+	if !found || strings.ContainsAny(hostname, "/*^|") {
+		return nil, nil
+	}
+	if isException {
+		return nil, []string{hostname}
+	}
+	return []string{hostname}, nil
+}
+
+func parseRPZ(line string) (blocked, allowed []string) {
+	// a.com CNAME . or *.a.com 3600 IN CNAME rpz-passthru.
+	commentIndex := strings.IndexByte(line, ';')
+	if commentIndex >= 0 {
+		line = line[:commentIndex]
+	}
+	fields := strings.Fields(line)
+	if len(fields) < 3 || strings.HasPrefix(fields[0], "$") || //nolint:mnd
+		fields[0] == "@" || strings.Contains(fields[0], "rpz-") {
+		return nil, nil
+	}
+
+	typeIndex := slices.IndexFunc(fields[1:len(fields)-1], func(field string) bool {
+		switch strings.ToUpper(field) {
+		case "CNAME", "A", "AAAA":
+			return true
+		default:
+			return false
+		}
+	})
+	if typeIndex == -1 {
+		return nil, nil
+	}
+	typeIndex++ // offset by the owner name field
+
+	hostname := strings.TrimPrefix(fields[0], "*.")
+	if fields[typeIndex+1] == "rpz-passthru." {
+		return nil, []string{hostname}
+	}
+	return []string{hostname}, nil
+}
@@ -0,0 +1,100 @@
+package hostnames
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_formats(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		parse   ParseFunc
+		line    string
+		blocked []string
+		allowed []string
+	}{
+		"plain": {
+			parse:   parsePlain,
+			line:    "a.com",
+			blocked: []string{"a.com"},
+		},
+		"hosts multiple hostnames": {
+			parse:   parseHosts,
+			line:    "0.0.0.0 a.com\tb.com",
+			blocked: []string{"a.com", "b.com"},
+		},
+		"hosts localhost": {
+			parse:   parseHosts,
+			line:    "::1 localhost",
+			blocked: []string{},
+		},
+		"hosts without IP address": {
+			parse: parseHosts,
+			line:  "a.com b.com",
+		},
+		"dnsmasq address": {
+			parse:   parseDnsmasq,
+			line:    "address=/a.com/b.com/0.0.0.0",
+			blocked: []string{"a.com", "b.com"},
+		},
+		"dnsmasq server": {
+			parse:   parseDnsmasq,
+			line:    "server=/a.com/",
+			blocked: []string{"a.com"},
+		},
+		"unbound local zone": {
+			parse:   parseUnbound,
+			line:    `local-zone: "a.com" redirect`,
+			blocked: []string{"a.com"},
+		},
+		"unbound local data": {
+			parse: parseUnbound,
+			line:  `local-data: "a.com A 0.0.0.0"`,
+		},
+		"unbound transparent": {
+			parse: parseUnbound,
+			line:  `local-zone: "a.com" transparent`,
+		},
+		"adblock": {
+			parse:   parseAdblock,
+			line:    "||a.com^$important",
+			blocked: []string{"a.com"},
+		},
+		"adblock exception": {
+			parse:   parseAdblock,
+			line:    "@@||a.com^",
+			allowed: []string{"a.com"},
+		},
+		"adblock path rule": {
+			parse: parseAdblock,
+			line:  "||a.com/ads^",
+		},
+		"rpz": {
+			parse:   parseRPZ,
+			line:    "*.a.com 3600 IN CNAME . ; comment",
+			blocked: []string{"a.com"},
+		},
+		"rpz passthru": {
+			parse:   parseRPZ,
+			line:    "a.com CNAME rpz-passthru.",
+			allowed: []string{"a.com"},
+		},
+		"rpz SOA": {
+			parse: parseRPZ,
+			line:  "@ SOA localhost. root.localhost. 1 3600 600 86400 60",
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			blocked, allowed := testCase.parse(testCase.line)
+
+			assert.Equal(t, testCase.blocked, blocked)
+			assert.Equal(t, testCase.allowed, allowed)
+		})
+	}
+}
@@ -4,93 +4,380 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"io"
-	"net/http"
+	"net/netip"
+	"os"
 	"regexp"
-	"sort"
+	"slices"
 	"strings"
 	"time"
+
+	"github.com/qdm12/updated/pkg/extsort"
+	"github.com/qdm12/updated/pkg/fetch"
+	"github.com/qdm12/updated/pkg/lastgood"
+	"github.com/qdm12/updated/pkg/provenance"
 )
 
-var regexHostname = regexp.MustCompile(`([a-zA-Z0-9]|[a-zA-Z0-9_][a-zA-Z0-9\-_]{0,61}[a-zA-Z0-9_])(\.([a-zA-Z0-9]|[a-zA-Z0-9_][a-zA-Z0-9\-_]{0,61}[a-zA-Z0-9]))*`) //nolint:lll
+// hostnameLabel matches a hostname label, which can
+// contain underscores, for example in SRV record names.
+const hostnameLabel = `([a-zA-Z0-9]|[a-zA-Z0-9_][a-zA-Z0-9\-_]{0,61}[a-zA-Z0-9_])`
+
+var regexHostname = regexp.MustCompile(`^` + hostnameLabel + `(\.` + hostnameLabel + `)*$`)
+
+// Result is the result of building hostnames from sources.
+type Result struct {
+	// Hostnames is the sorted list of unique hostnames blocked.
+	Hostnames []string
+	// Allowed is the sorted list of unique hostnames explicitly
+	// allowed by the sources, for example by AdBlock exception rules.
+	// They apply to their subdomains as well.
+	Allowed []string
+	// IPs are the IP addresses and CIDRs found in the sources
+	// instead of hostnames, in the order they are found.
+	IPs []string
+	// Rejected maps the URL of each source to its number of entries
+	// rejected because they are neither valid hostnames nor IP
+	// addresses or CIDRs. Sources without rejected entries are omitted.
+	Rejected map[string]int
+	// Stale lists the optional sources which failed.
+	Stale []lastgood.Stale
+	// Provenance maps each hostname to the indices of the sources
+	// producing it, and their raw lines if requested. It is nil if
+	// provenance tracking is off.
+	Provenance map[string]provenance.Entry
+	// IPsProvenance maps each IP address and CIDR of IPs to the indices
+	// of the sources producing it, and their raw lines if requested.
+	// It is nil if provenance tracking is off.
+	IPsProvenance map[string]provenance.Entry
+}
+
+// maxInMemoryHostnames is the maximum number of hostnames kept in
+// memory when sorting hostnames, before spilling them to disk.
+const maxInMemoryHostnames = 1 << 20
 
-func (b *Builder) buildForSources(ctx context.Context, title string,
-	sources []sourceType,
-) (hostnames []string, err error) {
+// Build builds a sorted list of unique hostnames from the sources given.
+// The provenance mode given sets whether to record the sources producing
+// each hostname, and their raw lines. Entries are added to the sorter as
+// they are parsed, so a source is never held whole in memory.
+// Entries of an optional source read before it fails are kept, in addition
+// to its last known good entries.
+// The title given, such as a category name, keeps the last known good
+// records of its sources separate from the ones of other titles.
+func (b *Builder) Build(ctx context.Context, title string,
+	sources []Source, provenanceMode provenance.Mode,
+) (result Result, err error) {
 	b.logger.Debugf("building %s hostnames...", title)
-	uniqueHostnames := make(map[string]bool)
+	sorter := extsort.New(b.tempDir, maxInMemoryHostnames)
+	defer func() {
+		closeErr := sorter.Close()
+		if closeErr != nil {
+			b.logger.Warn("removing temporary sort files: " + closeErr.Error())
+		}
+	}()
+	uniqueAllowed := make(map[string]struct{})
 	totalHostnames := 0
 
-	for _, source := range sources {
-		newHostnames, err := b.buildForSource(ctx, source.url,
-			source.preClean, source.checkLine, source.postClean)
-		if err != nil {
-			return nil, fmt.Errorf("building from %s: %w", source.url, err)
+	keepLines := provenanceMode == provenance.ModeLines
+	for sourceIndex, source := range sources {
+		sink := &sourceSink{
+			sorter:         sorter,
+			result:         &result,
+			allowed:        uniqueAllowed,
+			sourceIndex:    sourceIndex,
+			provenanceMode: provenanceMode,
+		}
+		err = b.buildForSource(ctx, title, source, keepLines, sink)
+		switch {
+		case err == nil:
+		case !source.Optional || ctx.Err() != nil:
+			return Result{}, fmt.Errorf("building from %s: %w", source.URL, err)
+		default:
+			var stale lastgood.Stale
+			stale, err = b.loadLastGood(title, source, err, sink)
+			if err != nil {
+				return Result{}, fmt.Errorf("sorting hostnames: %w", err)
+			}
+			b.logger.Warn(stale.String())
+			result.Stale = append(result.Stale, stale)
 		}
 
-		for _, hostname := range newHostnames {
-			totalHostnames++
-			uniqueHostnames[hostname] = true
+		totalHostnames += sink.hostnames
+		if sink.rejected > 0 {
+			b.logger.Infof("rejected %d invalid entries from %s", sink.rejected, source.URL)
+			if result.Rejected == nil {
+				result.Rejected = make(map[string]int)
+			}
+			result.Rejected[source.URL] = sink.rejected
 		}
 	}
 
-	var sortedHostnames sort.StringSlice
-	for hostname := range uniqueHostnames {
-		if !regexHostname.MatchString(hostname) {
-			continue
+	if provenanceMode == provenance.ModeOff {
+		err = sorter.Each(func(hostname string) {
+			result.Hostnames = append(result.Hostnames, hostname)
+		})
+	} else {
+		result.Hostnames, result.Provenance, err = collectProvenance(sorter)
+	}
+	if err != nil {
+		return Result{}, fmt.Errorf("sorting hostnames: %w", err)
+	}
+
+	result.Allowed = make([]string, 0, len(uniqueAllowed))
+	for hostname := range uniqueAllowed {
+		if isValidHostname(hostname) {
+			result.Allowed = append(result.Allowed, hostname)
 		}
-		sortedHostnames = append(sortedHostnames, hostname)
 	}
-	sortedHostnames.Sort()
+	slices.Sort(result.Allowed)
 
-	b.logger.Infof("built %s hostnames: %d fetched, %d unique", title, totalHostnames, sortedHostnames.Len())
+	b.logger.Infof("built %s hostnames: %d fetched, %d unique, %d allowed, %d IP entries",
+		title, totalHostnames, len(result.Hostnames), len(result.Allowed), len(result.IPs))
 
-	return sortedHostnames, nil
+	return result, nil
 }
 
-var ErrBadStatusCode = errors.New("bad HTTP status code")
+// sourceSink adds the entries parsed from a source to the sorter and the
+// result as they are parsed, and writes them to the last known good record
+// of the source if any.
+type sourceSink struct {
+	sorter         *extsort.Sorter
+	result         *Result
+	allowed        map[string]struct{}
+	sourceIndex    int
+	provenanceMode provenance.Mode
+	// lastGood is the last known good record being written, and is nil
+	// if the source is not optional or when replaying its record.
+	lastGood *lastgood.Writer
+	// lastGoodErr is the first error writing the last known good record.
+	lastGoodErr error
+	// hostnames is the number of valid hostnames added.
+	hostnames int
+	// rejected is the number of entries rejected because they are
+	// neither valid hostnames nor IP addresses or CIDRs.
+	rejected int
+}
 
-func (b *Builder) buildForSource(ctx context.Context, url string,
-	preClean cleanLineFunc, checkLine checkLineFunc, postClean cleanLineFunc,
-) (hostnames []string, err error) {
-	b.logger.Debug("building hostnames " + url + "...")
-	tStart := time.Now()
+// addEntry adds the entry given to the sorter if it is a valid hostname,
+// as a provenance record if provenance tracking is on, or to the result
+// IPs if it is an IP address or CIDR. Line is the raw line of the entry,
+// and can be empty if not kept.
+func (s *sourceSink) addEntry(entry, line string) (err error) {
+	if s.lastGood != nil && s.lastGoodErr == nil {
+		s.lastGoodErr = s.lastGood.Add(entry)
+	}
 
-	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-	if err != nil {
-		return nil, err
+	switch {
+	case isValidHostname(entry):
+		s.hostnames++
+		if s.provenanceMode != provenance.ModeOff {
+			entry = provenance.EncodeRecord(entry, s.sourceIndex, line)
+		}
+		err = s.sorter.Add(entry)
+		if err != nil {
+			return fmt.Errorf("sorting hostnames: %w", err)
+		}
+	case isIPEntry(entry):
+		s.result.addIP(entry, s.sourceIndex, line, s.provenanceMode)
+	default:
+		s.rejected++
+	}
+	return nil
+}
+
+// addAllowed adds a hostname explicitly allowed by the source.
+func (s *sourceSink) addAllowed(hostname string) (err error) {
+	if s.lastGood != nil && s.lastGoodErr == nil {
+		s.lastGoodErr = s.lastGood.Allow(hostname)
+	}
+	s.allowed[hostname] = struct{}{}
+	return nil
+}
+
+// isValidHostname returns true if the hostname has at most 253 characters,
+// labels of 1 to 63 characters and a top level domain which is not
+// all-numeric, so IP addresses are not valid hostnames.
+func isValidHostname(hostname string) bool {
+	const maxLength = 253
+	if len(hostname) > maxLength || !regexHostname.MatchString(hostname) {
+		return false
+	}
+	tld := hostname[strings.LastIndexByte(hostname, '.')+1:]
+	return strings.ContainsFunc(tld, func(r rune) bool {
+		return r < '0' || r > '9'
+	})
+}
+
+// isIPEntry returns true if the entry is an IP address or a CIDR.
+func isIPEntry(entry string) bool {
+	_, err := netip.ParseAddr(entry)
+	if err == nil {
+		return true
+	}
+	_, err = netip.ParsePrefix(entry)
+	return err == nil
+}
+
+// addIP adds the IP address or CIDR to the result, recording its
+// provenance if provenance tracking is on.
+func (r *Result) addIP(ip string, sourceIndex int, line string, provenanceMode provenance.Mode) {
+	r.IPs = append(r.IPs, ip)
+	if provenanceMode == provenance.ModeOff {
+		return
+	}
+	if r.IPsProvenance == nil {
+		r.IPsProvenance = make(map[string]provenance.Entry)
 	}
+	entry := r.IPsProvenance[ip]
+	entry.Add(sourceIndex, line)
+	r.IPsProvenance[ip] = entry
+}
+
+// collectProvenance collects the sorted unique hostnames and their
+// provenance from the sorter containing provenance records.
+func collectProvenance(sorter *extsort.Sorter) (hostnames []string,
+	provenances map[string]provenance.Entry, err error,
+) {
+	provenances = make(map[string]provenance.Entry)
+	var decodeErr error
+	err = sorter.Each(func(record string) {
+		if decodeErr != nil {
+			return
+		}
+		hostname, sourceIndex, line, err := provenance.DecodeRecord(record)
+		if err != nil {
+			decodeErr = err
+			return
+		}
 
-	response, err := b.client.Do(request)
+		entry, exists := provenances[hostname]
+		if !exists {
+			hostnames = append(hostnames, hostname)
+		}
+		entry.Add(sourceIndex, line)
+		provenances[hostname] = entry
+	})
 	if err != nil {
-		return nil, err
-	} else if response.StatusCode != http.StatusOK {
-		_ = response.Body.Close()
-		return nil, fmt.Errorf("%w: %d %s", ErrBadStatusCode, response.StatusCode, response.Status)
+		return nil, nil, err
+	} else if decodeErr != nil {
+		return nil, nil, decodeErr
 	}
+	return hostnames, provenances, nil
+}
+
+// lastGoodKey returns the key of the last known good record of the source
+// for the title given, so sources shared by several titles such as categories
+// keep separate records of their entries transformed and allowed for each.
+func lastGoodKey(title string, source Source) string {
+	return "hostnames " + title + " " + source.URL
+}
 
-	content, err := io.ReadAll(response.Body)
+// createLastGood returns a writer for the last known good record of the
+// source given, or nil if the source is not optional or if there is no
+// fallback. Failing to create it is logged and does not fail the source.
+func (b *Builder) createLastGood(title string, source Source) (writer *lastgood.Writer) {
+	if !source.Optional || b.fallback == nil {
+		return nil
+	}
+	writer, err := b.fallback.Create(lastGoodKey(title, source))
 	if err != nil {
-		_ = response.Body.Close()
-		return nil, err
+		b.logger.Warn("saving last known good hostnames of " + source.URL + ": " + err.Error())
+		return nil
+	}
+	return writer
+}
+
+// finishLastGood commits the last known good record written by the sink
+// if the source was built successfully, and aborts it otherwise.
+func (b *Builder) finishLastGood(source Source, sink *sourceSink, buildErr error) {
+	writer := sink.lastGood
+	sink.lastGood = nil
+	if writer == nil {
+		return
 	}
 
-	err = response.Body.Close()
+	err := sink.lastGoodErr
+	switch {
+	case buildErr != nil:
+		writer.Abort()
+		return
+	case err != nil:
+		writer.Abort()
+	default:
+		err = writer.Commit()
+	}
 	if err != nil {
-		return nil, err
+		b.logger.Warn("saving last known good hostnames of " + source.URL + ": " + err.Error())
+	}
+}
+
+// loadLastGood adds the last known good entries of the source given to the
+// sink, once it failed with the build error given. It only returns an error
+// if the sink fails to add an entry.
+func (b *Builder) loadLastGood(title string, source Source, buildErr error, sink *sourceSink) (
+	stale lastgood.Stale, err error,
+) {
+	stale = lastgood.Stale{URL: source.URL, Err: buildErr}
+	if b.fallback == nil {
+		return stale, nil
 	}
 
-	lines := strings.Split(string(content), "\n")
-	hostnames = make([]string, 0, len(lines))
-	for _, line := range lines {
-		line = preCleanLine(line, preClean)
-		if isLineValid(line, checkLine) {
-			line = postCleanLine(line, postClean)
-			hostnames = append(hostnames, line)
+	var sinkErr error
+	savedAt, err := b.fallback.Load(lastGoodKey(title, source), func(entry string, allowed bool) error {
+		if allowed {
+			sinkErr = sink.addAllowed(entry)
+		} else {
+			sinkErr = sink.addEntry(entry, "")
 		}
+		return sinkErr
+	})
+	switch {
+	case sinkErr != nil:
+		return stale, sinkErr
+	case errors.Is(err, os.ErrNotExist):
+		return stale, nil
+	case err != nil:
+		b.logger.Warn("loading last known good hostnames of " + source.URL + ": " + err.Error())
+		return stale, nil
+	}
+
+	stale.Fallback = true
+	stale.Age = time.Since(savedAt)
+	return stale, nil
+}
+
+// buildForSource parses the entries of the source and adds them to the
+// sink as they are parsed. If keepLines is true, the raw line of each
+// entry is given to the sink.
+func (b *Builder) buildForSource(ctx context.Context, title string, source Source, keepLines bool,
+	sink *sourceSink,
+) (err error) {
+	url := source.URL
+	b.logger.Debug("building hostnames " + url + "...")
+	tStart := time.Now()
+
+	parse, err := b.getFormat(source.Format)
+	if err != nil {
+		return err
+	}
+
+	body, err := fetch.Open(ctx, b.client, url, source.Members)
+	if err != nil {
+		return err
+	}
+
+	sink.lastGood = b.createLastGood(title, source)
+	err = parseLines(body, source.Transform, parse, keepLines, sink)
+	if err != nil {
+		_ = body.Close()
+		err = fmt.Errorf("reading lines: %w", err)
+	} else {
+		err = body.Close()
+	}
+	b.finishLastGood(source, sink, err)
+	if err != nil {
+		return err
 	}
 
 	b.logger.Infof("built hostnames %s during %s", url, time.Since(tStart))
 
-	return hostnames, nil
+	return nil
 }
@@ -0,0 +1,190 @@
+package hostnames
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/qdm12/updated/pkg/fetch"
+	"github.com/qdm12/updated/pkg/lastgood"
+	"github.com/qdm12/updated/pkg/provenance"
+	"github.com/qdm12/updated/pkg/transform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type noopLogger struct{}
+
+func (noopLogger) Debug(string)          {}
+func (noopLogger) Debugf(string, ...any) {}
+func (noopLogger) Info(string)           {}
+func (noopLogger) Infof(string, ...any)  {}
+func (noopLogger) Warn(string)           {}
+
+func Test_Builder_Build_optionalFallback(t *testing.T) {
+	t.Parallel()
+
+	fail := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte("0.0.0.0 b.com a.com\n@@||c.com^\n"))
+	}))
+	t.Cleanup(server.Close)
+
+	builder := New(server.Client(), noopLogger{}, lastgood.New(t.TempDir()), t.TempDir())
+	sources := []Source{{URL: server.URL, Format: FormatHosts, Optional: true}}
+
+	result, err := builder.Build(t.Context(), "test", sources, provenance.ModeOff)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a.com", "b.com"}, result.Hostnames)
+	assert.Empty(t, result.Stale)
+
+	fail = true
+	result, err = builder.Build(t.Context(), "test", sources, provenance.ModeOff)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a.com", "b.com"}, result.Hostnames)
+	require.Len(t, result.Stale, 1)
+	assert.True(t, result.Stale[0].Fallback)
+
+	sources[0].Optional = false
+	_, err = builder.Build(t.Context(), "test", sources, provenance.ModeOff)
+	require.ErrorIs(t, err, fetch.ErrBadStatusCode)
+}
+
+func Test_Builder_Build_optionalFallbackSharedSource(t *testing.T) {
+	t.Parallel()
+
+	var fail atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if fail.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte("a.com\nads.b.com\n"))
+	}))
+	t.Cleanup(server.Close)
+
+	builder := New(server.Client(), noopLogger{}, lastgood.New(t.TempDir()), t.TempDir())
+	rejectAds, err := transform.Compile([]transform.Step{{RejectPrefixes: []string{"ads."}}})
+	require.NoError(t, err)
+	categorySources := map[string][]Source{
+		"all":    {{URL: server.URL, Optional: true}},
+		"no-ads": {{URL: server.URL, Transform: rejectAds, Optional: true}},
+	}
+	expected := map[string][]string{
+		"all":    {"a.com", "ads.b.com"},
+		"no-ads": {"a.com"},
+	}
+
+	for range 2 {
+		var wg sync.WaitGroup
+		for category, sources := range categorySources {
+			wg.Go(func() {
+				result, err := builder.Build(t.Context(), category, sources, provenance.ModeOff)
+				assert.NoError(t, err)
+				assert.Equal(t, expected[category], result.Hostnames, category)
+				assert.Equal(t, fail.Load(), len(result.Stale) == 1, category)
+			})
+		}
+		wg.Wait()
+		fail.Store(true)
+	}
+}
+
+func Test_Builder_Build_localSources(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"hosts":             "0.0.0.0 a.com # comment\n127.0.0.1 localhost\n",
+		"lists/b.txt":       "B.com\nc.com.\n",
+		"lists/ignored.md":  "ignored.com\n",
+		"lists/sub/d.txt":   "d.com",
+		"adblock/rules.txt": "||e.com^\n@@||a.com^\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o700))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	}
+
+	builder := New(nil, noopLogger{}, nil, t.TempDir())
+	sources := []Source{
+		{URL: "file://" + filepath.Join(dir, "hosts"), Format: FormatHosts},
+		{URL: "file://" + filepath.Join(dir, "lists"), Members: []string{"*.txt", "*/*.txt"}},
+		{URL: "file://" + filepath.Join(dir, "adblock"), Format: FormatAdblock},
+	}
+
+	result, err := builder.Build(t.Context(), "test", sources, provenance.ModeOff)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a.com", "b.com", "c.com", "d.com", "e.com"}, result.Hostnames)
+	assert.Equal(t, []string{"a.com"}, result.Allowed)
+
+	result, err = builder.Build(t.Context(), "test", sources[:2], provenance.ModeLines)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a.com", "b.com", "c.com", "d.com"}, result.Hostnames)
+	assert.Equal(t, map[string]provenance.Entry{
+		"a.com": {Sources: []int{0}, Lines: []string{"0.0.0.0 a.com # comment"}},
+		"b.com": {Sources: []int{1}, Lines: []string{"B.com"}},
+		"c.com": {Sources: []int{1}, Lines: []string{"c.com."}},
+		"d.com": {Sources: []int{1}, Lines: []string{"d.com"}},
+	}, result.Provenance)
+}
+
+func Test_Builder_Build_ipEntries(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "list.txt")
+	const content = "a.com\n1.2.3.4\n5.6.7.0/24\n2001:db8::1\nbad..com\n-bad.com\n1.2.3\n" +
+		"_dmarc.b.com\nlabel-of-64-characters-is-too-long-abcdefghijklmnopqrstuvwxyz-01.com\n"
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	builder := New(nil, noopLogger{}, nil, t.TempDir())
+	sources := []Source{{URL: "file://" + path}}
+
+	result, err := builder.Build(t.Context(), "test", sources, provenance.ModeLines)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"_dmarc.b.com", "a.com"}, result.Hostnames)
+	assert.Equal(t, []string{"1.2.3.4", "5.6.7.0/24", "2001:db8::1"}, result.IPs)
+	assert.Equal(t, map[string]int{sources[0].URL: 4}, result.Rejected)
+	expectedProvenance := provenance.Entry{Sources: []int{0}, Lines: []string{"5.6.7.0/24"}}
+	assert.Equal(t, expectedProvenance, result.IPsProvenance["5.6.7.0/24"])
+}
+
+func Test_isValidHostname(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		hostname string
+		valid    bool
+	}{
+		"simple":                              {"example.com", true},
+		"single label":                        {"localhost", true},
+		"hyphen inside label":                 {"my-host.example.com", true},
+		"hyphen ending label":                 {"my-.example.com", false},
+		"underscore starting first label":     {"_dmarc.example.com", true},
+		"underscore ending first label":       {"a_.example.com", true},
+		"underscore starting later label":     {"example._tcp.com", true},
+		"underscore ending later label":       {"example.a_.com", true},
+		"underscore only in later label":      {"example._.com", false},
+		"empty label":                         {"example..com", false},
+		"numeric top level domain":            {"1.2.3.4", false},
+		"label longer than 63 characters":     {strings.Repeat("a", 64) + ".com", false},
+		"hostname longer than 253 characters": {strings.Repeat("a.", 127) + "com", false},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			valid := isValidHostname(tc.hostname)
+			assert.Equal(t, tc.valid, valid)
+		})
+	}
+}
@@ -3,12 +3,20 @@ package hostnames
 
 import (
 	"net/http"
+	"sync"
+	"time"
+
+	"github.com/qdm12/updated/pkg/lastgood"
 )
 
 // Builder builds hostnames lists.
 type Builder struct {
-	client *http.Client
-	logger Logger
+	client    *http.Client
+	logger    Logger
+	fallback  Fallback
+	tempDir   string
+	formats   map[string]ParseFunc
+	formatsMu sync.RWMutex
 }
 
 // Logger represents a minimal logger interface.
@@ -17,12 +25,26 @@ type Logger interface {
 	Debugf(format string, args ...any)
 	Info(s string)
 	Infof(format string, args ...any)
+	Warn(s string)
+}
+
+// Fallback saves and loads the last known good entries of sources.
+type Fallback interface {
+	Create(key string) (writer *lastgood.Writer, err error)
+	Load(key string, fn func(entry string, allowed bool) error) (savedAt time.Time, err error)
 }
 
 // New returns a new builder of hostnames lists.
-func New(client *http.Client, logger Logger) *Builder {
+// The fallback is used to save and load the last known good entries
+// of optional sources, and can be nil to disable this feature.
+// The temporary directory is used to sort large lists of hostnames
+// on disk, and can be empty to use the default temporary directory.
+func New(client *http.Client, logger Logger, fallback Fallback, tempDir string) *Builder {
 	return &Builder{
-		client: client,
-		logger: logger,
+		client:   client,
+		logger:   logger,
+		fallback: fallback,
+		tempDir:  tempDir,
+		formats:  defaultFormats(),
 	}
 }
@@ -1,48 +1,90 @@
 package hostnames
 
 import (
+	"bufio"
+	"io"
 	"strings"
-)
 
-type cleanLineFunc func(line string) (cleaned string)
+	"github.com/qdm12/updated/pkg/transform"
+)
 
-type checkLineFunc func(line string) (ok bool)
+func preCleanLine(line string) (cleaned string) {
+	line, _, _ = strings.Cut(line, "#")
+	line = strings.TrimSpace(line)
+	return strings.ToLower(line)
+}
 
-func preCleanLine(line string, customClean cleanLineFunc) (cleaned string) {
-	line = strings.ToLower(line)
+func cleanLine(line string, pipeline transform.Pipeline) (cleaned string, ok bool) {
+	line = preCleanLine(line)
+	if line == "" {
+		return "", false
+	}
 
-	// remove comment
-	var lineWithoutComment string
-	for _, r := range line {
-		if r == '#' {
-			break
-		}
-		lineWithoutComment += string(r)
+	line, ok = pipeline.Apply(line)
+	if !ok {
+		return "", false
 	}
-	line = lineWithoutComment
 
 	line = strings.TrimSpace(line)
+	return line, line != ""
+}
 
-	if customClean != nil {
-		line = customClean(line)
-	}
-
-	return line
+// entrySink receives the entries parsed from a source.
+type entrySink interface {
+	addEntry(entry, line string) error
+	addAllowed(hostname string) error
 }
 
-func isLineValid(line string, customIsLineValid checkLineFunc) (valid bool) {
-	if line == "" {
-		return false
-	} else if customIsLineValid == nil {
-		return true
+// parseLines reads lines from the reader one at a time, parses each of
+// them once cleaned and transformed using the parse function given, and
+// adds the entries parsed to the sink. If keepLines is true, the raw line
+// of each entry is given to the sink.
+func parseLines(reader io.Reader, pipeline transform.Pipeline, parse ParseFunc,
+	keepLines bool, sink entrySink,
+) (err error) {
+	scanner := bufio.NewScanner(reader)
+	const maxLineLength = 1024 * 1024
+	scanner.Buffer(nil, maxLineLength)
+	var blocked, allowed []string
+	for scanner.Scan() {
+		rawLine := scanner.Text()
+		line, ok := cleanLine(rawLine, pipeline)
+		if !ok {
+			continue
+		}
+		newBlocked, newAllowed := parse(line)
+		blocked = appendCleanedHostnames(blocked[:0], newBlocked)
+		allowed = appendCleanedHostnames(allowed[:0], newAllowed)
+		if keepLines {
+			rawLine = strings.TrimSpace(rawLine)
+		} else {
+			rawLine = ""
+		}
+		for _, hostname := range blocked {
+			err = sink.addEntry(hostname, rawLine)
+			if err != nil {
+				return err
+			}
+		}
+		for _, hostname := range allowed {
+			err = sink.addAllowed(hostname)
+			if err != nil {
+				return err
+			}
+		}
 	}
-	return customIsLineValid(line)
+	return scanner.Err()
 }
 
-func postCleanLine(line string, customClean cleanLineFunc) (cleaned string) {
-	line = strings.TrimSpace(line)
-	if customClean != nil {
-		line = customClean(line)
+// appendCleanedHostnames appends the hostnames given to the hostnames slice,
+// once trimmed from surrounding spaces and their trailing dot.
+func appendCleanedHostnames(hostnames, toAppend []string) []string {
+	for _, hostname := range toAppend {
+		hostname = strings.TrimSuffix(strings.TrimSpace(hostname), ".")
+		if hostname == "" {
+			continue
+		}
+		hostnames = append(hostnames, hostname)
 	}
-	return line
+	return hostnames
 }
@@ -0,0 +1,123 @@
+package hostnames
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/qdm12/updated/pkg/extsort"
+	"github.com/qdm12/updated/pkg/transform"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_preCleanLine(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		line        string
+		cleanedLine string
+	}{
+		"empty input":     {"", ""},
+		"comment only":    {"# comment", ""},
+		"comment removed": {" A.com # comment", "a.com"},
+		"lowercased":      {"0.0.0.0 A.COM", "0.0.0.0 a.com"},
+		"multibyte runes": {"ÀB.com#x", "àb.com"},
+		"trailing spaces": {"a.com \t", "a.com"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			cleanedLine := preCleanLine(tc.line)
+			assert.Equal(t, tc.cleanedLine, cleanedLine)
+		})
+	}
+}
+
+// makeHostsContent returns a synthetic hosts file content
+// with the number of lines given.
+func makeHostsContent(lines int) []byte {
+	const unique = 2_000_000
+	random := rand.New(rand.NewPCG(1, 2)) //nolint:gosec
+	buffer := bytes.NewBuffer(nil)
+	for i := range lines {
+		if i%100 == 0 {
+			buffer.WriteString("# Comment line\n")
+			continue
+		}
+		fmt.Fprintf(buffer, "0.0.0.0 Host%d.Example.com # comment\n", random.IntN(unique))
+	}
+	return buffer.Bytes()
+}
+
+// legacyPreCleanLine is the former implementation of [preCleanLine],
+// kept to compare performance in benchmarks.
+func legacyPreCleanLine(line string) (cleaned string) {
+	line = strings.ToLower(line)
+	var lineWithoutComment string
+	for _, r := range line {
+		if r == '#' {
+			break
+		}
+		lineWithoutComment += string(r)
+	}
+	return strings.TrimSpace(lineWithoutComment)
+}
+
+// Benchmark_legacyBuild benchmarks the former read all, split lines,
+// map and sorted copy approach, to compare with [Benchmark_streamingBuild].
+func Benchmark_legacyBuild(b *testing.B) {
+	content := makeHostsContent(5_000_000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for b.Loop() {
+		data, err := io.ReadAll(bytes.NewReader(content))
+		if err != nil {
+			b.Fatal(err)
+		}
+		unique := make(map[string]bool)
+		for _, line := range strings.Split(string(data), "\n") {
+			line = legacyPreCleanLine(line)
+			if line == "" {
+				continue
+			}
+			blocked, _ := parseHosts(line)
+			for _, hostname := range appendCleanedHostnames(nil, blocked) {
+				unique[hostname] = true
+			}
+		}
+		var sorted sort.StringSlice
+		for hostname := range unique {
+			sorted = append(sorted, hostname)
+		}
+		sorted.Sort()
+	}
+}
+
+func Benchmark_streamingBuild(b *testing.B) {
+	content := makeHostsContent(5_000_000)
+	dir := b.TempDir()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for b.Loop() {
+		sorter := extsort.New(dir, maxInMemoryHostnames)
+		sink := &sourceSink{sorter: sorter, result: &Result{}, allowed: map[string]struct{}{}}
+		err := parseLines(bytes.NewReader(content), transform.Pipeline{}, parseHosts, false, sink)
+		if err != nil {
+			b.Fatal(err)
+		}
+		var sorted []string
+		err = sorter.Each(func(hostname string) {
+			sorted = append(sorted, hostname)
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+		err = sorter.Close()
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
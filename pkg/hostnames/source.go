@@ -1,8 +1,22 @@
 package hostnames
 
-type sourceType struct {
-	url       string
-	preClean  cleanLineFunc
-	checkLine checkLineFunc
-	postClean cleanLineFunc
+import "github.com/qdm12/updated/pkg/transform"
+
+// Source is a source of hostnames.
+type Source struct {
+	// URL is the address to fetch the hostnames from.
+	URL string
+	// Format is the name of the format of the source, such as
+	// [FormatHosts]. It defaults to [FormatPlain] if left empty.
+	Format string
+	// Members are the [path.Match] patterns of the archive members to
+	// read if the source is a zip or tar archive. All the archive regular
+	// files are read if left empty.
+	Members []string
+	// Transform is applied on each pre-cleaned line,
+	// before the line is parsed according to the format.
+	Transform transform.Pipeline
+	// Optional indicates the source can fail without failing the
+	// whole build, in which case its last known good entries are used.
+	Optional bool
 }
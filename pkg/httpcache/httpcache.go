@@ -0,0 +1,284 @@
+// Package httpcache provides an HTTP round tripper caching response
+// bodies on disk and revalidating them using conditional requests.
+package httpcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const (
+	// HeaderCache is the response header set to [CacheHit] on responses
+	// served from the cache after a 304 Not Modified response.
+	HeaderCache = "X-Updated-Cache"
+	// CacheHit is the value of the [HeaderCache] header for responses
+	// served from the cache.
+	CacheHit = "hit"
+)
+
+// Transport is an HTTP round tripper caching bodies of successful GET
+// responses having an ETag or Last-Modified header, and sending
+// If-None-Match and If-Modified-Since headers on subsequent requests.
+// A 304 Not Modified response is replaced by a 200 OK response
+// with the cached body.
+type Transport struct {
+	dir    string
+	next   http.RoundTripper
+	logger Logger
+}
+
+// Logger represents a minimal logger interface.
+type Logger interface {
+	Debug(s string)
+	Info(s string)
+	Warn(s string)
+}
+
+// New creates a new caching transport storing its cache in the directory
+// given, and using the next round tripper given to do the requests.
+func New(dir string, next http.RoundTripper, logger Logger) *Transport {
+	return &Transport{
+		dir:    dir,
+		next:   next,
+		logger: logger,
+	}
+}
+
+type metadata struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"lastModified,omitempty"`
+	ContentType  string    `json:"contentType,omitempty"`
+	StoredAt     time.Time `json:"storedAt"`
+}
+
+// RoundTrip implements the [http.RoundTripper] interface.
+func (t *Transport) RoundTrip(request *http.Request) (response *http.Response, err error) {
+	if request.Method != http.MethodGet {
+		return t.next.RoundTrip(request)
+	}
+
+	url := request.URL.String()
+	key := cacheKey(url)
+	cached, err := t.readMetadata(key)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		t.logger.Warn("reading HTTP cache metadata for " + url + ": " + err.Error())
+	default:
+		request = request.Clone(request.Context())
+		if cached.ETag != "" {
+			request.Header.Set("If-None-Match", cached.ETag)
+		}
+		if cached.LastModified != "" {
+			request.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
+	found := err == nil
+
+	response, err = t.next.RoundTrip(request)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case response.StatusCode == http.StatusNotModified && found:
+		return t.cachedResponse(response, key, cached)
+	case response.StatusCode != http.StatusOK:
+		return response, nil
+	}
+
+	if found {
+		t.logger.Info(url + " changed since " + cached.StoredAt.Format(time.RFC3339))
+	}
+
+	newMetadata := metadata{
+		URL:          url,
+		ETag:         response.Header.Get("ETag"),
+		LastModified: response.Header.Get("Last-Modified"),
+		ContentType:  response.Header.Get("Content-Type"),
+	}
+	if newMetadata.ETag == "" && newMetadata.LastModified == "" {
+		return response, nil
+	}
+
+	err = os.MkdirAll(t.dir, 0o700) //nolint:mnd
+	if err != nil {
+		t.logger.Warn("creating HTTP cache directory: " + err.Error())
+		return response, nil
+	}
+	tempFile, err := os.CreateTemp(t.dir, key+".*.tmp")
+	if err != nil {
+		t.logger.Warn("creating HTTP cache temporary file: " + err.Error())
+		return response, nil
+	}
+	response.Body = &teeBody{
+		body:      response.Body,
+		tempFile:  tempFile,
+		transport: t,
+		key:       key,
+		metadata:  newMetadata,
+	}
+	return response, nil
+}
+
+func (t *Transport) cachedResponse(notModified *http.Response, key string,
+	cached metadata,
+) (response *http.Response, err error) {
+	_, _ = io.Copy(io.Discard, notModified.Body)
+	_ = notModified.Body.Close()
+
+	body, err := os.Open(t.bodyPath(key))
+	if err != nil {
+		return nil, fmt.Errorf("opening cached body: %w", err)
+	}
+	stat, err := body.Stat()
+	if err != nil {
+		_ = body.Close()
+		return nil, fmt.Errorf("getting cached body size: %w", err)
+	}
+
+	t.logger.Info(cached.URL + " unchanged since " + cached.StoredAt.Format(time.RFC3339))
+
+	header := notModified.Header.Clone()
+	header.Set(HeaderCache, CacheHit)
+	header.Set("Content-Length", strconv.FormatInt(stat.Size(), 10))
+	if cached.ContentType != "" {
+		header.Set("Content-Type", cached.ContentType)
+	}
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         notModified.Proto,
+		ProtoMajor:    notModified.ProtoMajor,
+		ProtoMinor:    notModified.ProtoMinor,
+		Header:        header,
+		Body:          body,
+		ContentLength: stat.Size(),
+		Request:       notModified.Request,
+	}, nil
+}
+
+func cacheKey(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return hex.EncodeToString(sum[:])
+}
+
+func (t *Transport) bodyPath(key string) string {
+	return filepath.Join(t.dir, key+".body")
+}
+
+func (t *Transport) metadataPath(key string) string {
+	return filepath.Join(t.dir, key+".json")
+}
+
+func (t *Transport) readMetadata(key string) (data metadata, err error) {
+	file, err := os.Open(t.metadataPath(key))
+	if err != nil {
+		return metadata{}, err
+	}
+	decoder := json.NewDecoder(file)
+	err = decoder.Decode(&data)
+	if err != nil {
+		_ = file.Close()
+		return metadata{}, fmt.Errorf("decoding metadata: %w", err)
+	}
+	err = file.Close()
+	if err != nil {
+		return metadata{}, err
+	}
+
+	_, err = os.Stat(t.bodyPath(key))
+	if err != nil {
+		return metadata{}, err
+	}
+	return data, nil
+}
+
+func (t *Transport) store(key string, tempBodyPath string, data metadata) (err error) {
+	data.StoredAt = time.Now()
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("encoding metadata: %w", err)
+	}
+
+	// Remove the metadata first so a crash cannot leave
+	// the old metadata paired with the new body.
+	err = os.Remove(t.metadataPath(key))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("removing old metadata: %w", err)
+	}
+
+	err = os.Rename(tempBodyPath, t.bodyPath(key))
+	if err != nil {
+		return fmt.Errorf("moving body: %w", err)
+	}
+
+	const perms = 0o600
+	err = os.WriteFile(t.metadataPath(key), encoded, perms)
+	if err != nil {
+		return fmt.Errorf("writing metadata: %w", err)
+	}
+	return nil
+}
+
+// teeBody writes the response body to a temporary file as it is read,
+// and stores it in the cache once the body is fully read and closed.
+type teeBody struct {
+	body      io.ReadCloser
+	tempFile  *os.File
+	transport *Transport
+	key       string
+	metadata  metadata
+	complete  bool
+	failed    bool
+}
+
+func (b *teeBody) Read(p []byte) (n int, err error) {
+	n, err = b.body.Read(p)
+	if n > 0 && !b.failed {
+		_, writeErr := b.tempFile.Write(p[:n])
+		if writeErr != nil {
+			b.failed = true
+			b.transport.logger.Warn("writing HTTP cache body: " + writeErr.Error())
+		}
+	}
+	if errors.Is(err, io.EOF) {
+		b.complete = true
+	}
+	return n, err
+}
+
+func (b *teeBody) Close() error {
+	err := b.body.Close()
+
+	tempPath := b.tempFile.Name()
+	closeErr := b.tempFile.Close()
+	if closeErr != nil {
+		b.failed = true
+	}
+
+	if !b.complete || b.failed || err != nil {
+		_ = os.Remove(tempPath)
+		return err
+	}
+
+	storeErr := b.transport.store(b.key, tempPath, b.metadata)
+	if storeErr != nil {
+		_ = os.Remove(tempPath)
+		b.transport.logger.Warn("storing HTTP cache entry for " + b.metadata.URL + ": " + storeErr.Error())
+	} else {
+		b.transport.logger.Debug("stored HTTP cache entry for " + b.metadata.URL)
+	}
+	return nil
+}
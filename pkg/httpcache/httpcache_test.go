@@ -0,0 +1,59 @@
+package httpcache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type noopLogger struct{}
+
+func (noopLogger) Debug(string) {}
+func (noopLogger) Info(string)  {}
+func (noopLogger) Warn(string)  {}
+
+func Test_Transport(t *testing.T) {
+	t.Parallel()
+
+	requestsCount := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestsCount++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte("content"))
+	}))
+	t.Cleanup(server.Close)
+
+	client := &http.Client{
+		Transport: New(t.TempDir(), http.DefaultTransport, noopLogger{}),
+	}
+
+	get := func() (body string, header http.Header) {
+		request, err := http.NewRequestWithContext(t.Context(), http.MethodGet, server.URL, nil)
+		require.NoError(t, err)
+		response, err := client.Do(request)
+		require.NoError(t, err)
+		data, err := io.ReadAll(response.Body)
+		require.NoError(t, err)
+		require.NoError(t, response.Body.Close())
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		return string(data), response.Header
+	}
+
+	body, header := get()
+	assert.Equal(t, "content", body)
+	assert.Empty(t, header.Get(HeaderCache))
+
+	body, header = get()
+	assert.Equal(t, "content", body)
+	assert.Equal(t, CacheHit, header.Get(HeaderCache))
+
+	assert.Equal(t, 2, requestsCount)
+}
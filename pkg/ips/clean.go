@@ -1,96 +1,221 @@
 package ips
 
 import (
-	"net"
-	"sort"
+	"maps"
+	"net/netip"
+	"slices"
 
-	"github.com/yl2chen/cidranger"
+	"github.com/qdm12/updated/pkg/provenance"
 )
 
-// CleanIPs removes duplicates IPs and CIDRs, and IPs contained in CIDRs.
-func (b *Builder) CleanIPs(ips []string) (cleanIPs []string, removedCount int, warnings []string) {
-	uniqueIPs := makeUniqueIPs(ips)
-	uniqueCIDRs := makeUniqueCIDRs(ips)
-	ranger, err := buildCIDRRanger(uniqueCIDRs)
-	if err != nil {
-		warnings = append(warnings, err.Error())
-	}
-	uniqueIPs, newWarnings := removeIPsInCIDRs(uniqueIPs, ranger)
-	warnings = append(warnings, newWarnings...)
-	// TODO CIDR inside CIDR?
-	// TODO Combine CIDRs (check mask only)
-	cleanIPs = sortStringSlice(append(uniqueCIDRs, uniqueIPs...))
-	return cleanIPs, len(ips) - len(cleanIPs), warnings
+// Aggregation holds the options of the aggregation of
+// adjacent IP addresses and CIDRs into larger CIDRs.
+type Aggregation struct {
+	// MaxWidthIPv4 is the shortest prefix length of the IPv4 CIDRs
+	// produced by aggregation, from 0 to 32. For example with 24,
+	// adjacent entries are aggregated up to /24 CIDRs but not further.
+	// CIDRs shorter than it found in the entries are kept as they are.
+	// Setting it to 32 disables the aggregation of IPv4 entries.
+	MaxWidthIPv4 int
+	// MaxWidthIPv6 is the shortest prefix length of the IPv6 CIDRs
+	// produced by aggregation, from 0 to 128. Setting it to 128
+	// disables the aggregation of IPv6 entries.
+	MaxWidthIPv6 int
+}
+
+// CleanStats holds the number of entries removed by each step of
+// the cleaning of IP addresses and CIDRs.
+type CleanStats struct {
+	// Invalid is the number of entries which are not
+	// valid IP addresses or CIDRs.
+	Invalid int
+	// Duplicates is the number of duplicate entries.
+	Duplicates int
+	// Covered is the number of IP addresses and CIDRs
+	// fully contained in another CIDR.
+	Covered int
+	// Aggregated is the number of entries removed by aggregating
+	// adjacent IP addresses and CIDRs into larger CIDRs.
+	Aggregated int
+}
+
+// Removed returns the total number of entries removed.
+func (s CleanStats) Removed() int {
+	return s.Invalid + s.Duplicates + s.Covered + s.Aggregated
 }
 
-func makeUniqueIPs(lines []string) (uniqueIPs []string) {
-	IPMap := make(map[string]struct{})
-	for _, line := range lines {
-		// only process single IP
-		if IP := net.ParseIP(line); IP != nil {
-			IPMap[IP.String()] = struct{}{}
+// CleanIPs removes invalid and duplicate IP addresses and CIDRs, removes
+// IP addresses and CIDRs contained in other CIDRs, and aggregates adjacent
+// entries into the minimal set of CIDRs covering the same addresses,
+// within the aggregation widths given. The entries returned are sorted
+// and single addresses are written without their prefix length.
+// IPv4-mapped IPv6 addresses are considered as IPv4 addresses.
+func (b *Builder) CleanIPs(ips []string, aggregation Aggregation) (cleanIPs []string, stats CleanStats) {
+	unique := make(map[netip.Prefix]struct{}, len(ips))
+	for _, ip := range ips {
+		prefix, err := ParsePrefix(ip)
+		if err != nil {
+			stats.Invalid++
+			continue
 		}
+		unique[prefix] = struct{}{}
 	}
-	for IP := range IPMap {
-		uniqueIPs = append(uniqueIPs, IP)
+	stats.Duplicates = len(ips) - stats.Invalid - len(unique)
+
+	var prefixes4, prefixes6 []netip.Prefix
+	for prefix := range unique {
+		if prefix.Addr().Is4() {
+			prefixes4 = append(prefixes4, prefix)
+		} else {
+			prefixes6 = append(prefixes6, prefix)
+		}
 	}
-	return uniqueIPs
+
+	prefixes4 = removeCovered(prefixes4, &stats)
+	prefixes6 = removeCovered(prefixes6, &stats)
+	prefixes4 = aggregate(prefixes4, aggregation.MaxWidthIPv4, &stats)
+	prefixes6 = aggregate(prefixes6, aggregation.MaxWidthIPv6, &stats)
+
+	cleanIPs = make([]string, 0, len(prefixes4)+len(prefixes6))
+	for _, prefix := range slices.Concat(prefixes4, prefixes6) {
+		cleanIPs = append(cleanIPs, formatPrefix(prefix))
+	}
+	slices.Sort(cleanIPs)
+	return cleanIPs, stats
 }
 
-func makeUniqueCIDRs(lines []string) (uniqueCIDRs []string) {
-	CIDRMap := make(map[string]struct{})
-	for _, line := range lines {
-		// only process CIDR
-		_, CIDRPtr, err := net.ParseCIDR(line)
-		if err == nil {
-			CIDRMap[CIDRPtr.String()] = struct{}{}
+// removeCovered removes the prefixes contained in other prefixes,
+// and returns the remaining prefixes of a single family sorted in
+// ascending order.
+func removeCovered(prefixes []netip.Prefix, stats *CleanStats) (kept []netip.Prefix) {
+	slices.SortFunc(prefixes, comparePrefixes)
+	for _, prefix := range prefixes {
+		if len(kept) > 0 && kept[len(kept)-1].Overlaps(prefix) {
+			// Prefixes are sorted by address and then by ascending prefix
+			// length, so an overlapping prefix is contained in the last one.
+			stats.Covered++
 			continue
 		}
+		kept = append(kept, prefix)
 	}
-	for CIDR := range CIDRMap {
-		uniqueCIDRs = append(uniqueCIDRs, CIDR)
+	return kept
+}
+
+// aggregate aggregates the non overlapping prefixes of a single family
+// given into the minimal set of prefixes covering the same addresses,
+// without producing prefixes shorter than maxWidth. Prefixes already
+// shorter than maxWidth are kept as they are.
+func aggregate(prefixes []netip.Prefix, maxWidth int, stats *CleanStats) (aggregated []netip.Prefix) {
+	if len(prefixes) == 0 {
+		return nil
 	}
-	return uniqueCIDRs
+
+	var ranges []addressRange
+	for _, prefix := range prefixes {
+		if prefix.Bits() < maxWidth {
+			aggregated = append(aggregated, prefix)
+			continue
+		}
+		ranges = append(ranges, prefixToRange(prefix))
+	}
+
+	is4 := prefixes[0].Addr().Is4()
+	for _, prefix := range mergeRanges(ranges, is4) {
+		aggregated = append(aggregated, splitPrefix(prefix, maxWidth)...)
+	}
+	stats.Aggregated += len(prefixes) - len(aggregated)
+	slices.SortFunc(aggregated, comparePrefixes)
+	return aggregated
 }
 
-//nolint:ireturn
-func buildCIDRRanger(cidrs []string) (ranger cidranger.Ranger, err error) {
-	ranger = cidranger.NewPCTrieRanger()
-	for _, CIDR := range cidrs {
-		_, CIDRPtr, err := net.ParseCIDR(CIDR)
-		if err == nil {
-			err = ranger.Insert(cidranger.NewBasicRangerEntry(*CIDRPtr))
-			if err != nil {
-				return nil, err
-			}
+// splitPrefix splits the prefix given into prefixes with a
+// length of bits, if it is shorter than bits.
+func splitPrefix(prefix netip.Prefix, bits int) (prefixes []netip.Prefix) {
+	if prefix.Bits() >= bits {
+		return []netip.Prefix{prefix}
+	}
+	r := prefixToRange(prefix)
+	is4 := prefix.Addr().Is4()
+	step := pow2(prefix.Addr().BitLen() - bits)
+	for current := r.start; ; {
+		prefixes = append(prefixes, netip.PrefixFrom(current.toAddr(is4), bits))
+		next, overflow := current.add(step)
+		if overflow || next.cmp(r.end) > 0 {
+			return prefixes
 		}
+		current = next
+	}
+}
+
+// comparePrefixes sorts prefixes of a single family
+// by address and then by ascending prefix length.
+func comparePrefixes(a, b netip.Prefix) int {
+	if c := a.Addr().Compare(b.Addr()); c != 0 {
+		return c
 	}
-	return ranger, nil
+	return a.Bits() - b.Bits()
 }
 
-func removeIPsInCIDRs(ips []string, ranger cidranger.Ranger) (cleanedIPs []string, warnings []string) {
-	for _, IP := range ips {
-		netIP := net.ParseIP(IP)
-		if netIP == nil {
+// formatPrefix formats the prefix as an IP address if it contains
+// a single address, and as a CIDR otherwise.
+func formatPrefix(prefix netip.Prefix) string {
+	if prefix.IsSingleIP() {
+		return prefix.Addr().String()
+	}
+	return prefix.String()
+}
+
+// AttributeProvenance returns the provenance of each of the entries given
+// cleaned by [Builder.CleanIPs], merging the provenance of the original
+// entries they contain, such as aggregated IP addresses.
+func AttributeProvenance(entries []string, provenances map[string]provenance.Entry,
+) (attributed map[string]provenance.Entry) {
+	type entryRange struct {
+		addressRange
+		entry string
+	}
+	var ranges4, ranges6 []entryRange
+	for _, entry := range entries {
+		prefix, err := ParsePrefix(entry)
+		if err != nil {
 			continue
 		}
-		contains, err := ranger.Contains(netIP)
+		r := entryRange{addressRange: prefixToRange(prefix), entry: entry}
+		if prefix.Addr().Is4() {
+			ranges4 = append(ranges4, r)
+		} else {
+			ranges6 = append(ranges6, r)
+		}
+	}
+	compareStarts := func(a, b entryRange) int { return a.start.cmp(b.start) }
+	slices.SortFunc(ranges4, compareStarts)
+	slices.SortFunc(ranges6, compareStarts)
+
+	attributed = make(map[string]provenance.Entry, len(entries))
+	// Iterate in a sorted order so merged lines are in a deterministic order.
+	for _, original := range slices.Sorted(maps.Keys(provenances)) {
+		prefix, err := ParsePrefix(original)
 		if err != nil {
-			warnings = append(warnings, err.Error())
 			continue
 		}
-		if !contains {
-			cleanedIPs = append(cleanedIPs, IP)
+		ranges := ranges6
+		if prefix.Addr().Is4() {
+			ranges = ranges4
 		}
-	}
-	return cleanedIPs, warnings
-}
 
-func sortStringSlice(slice []string) []string {
-	var sorted sort.StringSlice
-	for _, element := range slice {
-		sorted = append(sorted, element)
+		// Cleaned entries do not overlap, so the only entry which can
+		// contain the original entry is the last one starting before it.
+		target := entryRange{addressRange: prefixToRange(prefix)}
+		index, found := slices.BinarySearchFunc(ranges, target, compareStarts)
+		if !found {
+			index--
+		}
+		if index < 0 || ranges[index].end.cmp(target.end) < 0 {
+			continue
+		}
+		entry := attributed[ranges[index].entry]
+		entry.Merge(provenances[original])
+		attributed[ranges[index].entry] = entry
 	}
-	sorted.Sort()
-	return sorted
+	return attributed
 }
@@ -0,0 +1,99 @@
+package ips
+
+import (
+	"testing"
+
+	"github.com/qdm12/updated/pkg/provenance"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Builder_CleanIPs(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		ips         []string
+		aggregation Aggregation
+		cleanIPs    []string
+		stats       CleanStats
+	}{
+		"empty": {
+			cleanIPs: []string{},
+		},
+		"invalid and duplicates": {
+			ips:         []string{"1.2.3.4", "x", "1.2.3.4", "::ffff:1.2.3.4", "1.2.3.4/32"},
+			aggregation: Aggregation{MaxWidthIPv4: 32, MaxWidthIPv6: 128},
+			cleanIPs:    []string{"1.2.3.4"},
+			stats:       CleanStats{Invalid: 1, Duplicates: 3},
+		},
+		"nested CIDRs": {
+			ips:         []string{"10.1.2.3", "10.0.0.0/8", "10.1.0.0/16", "10.2.0.0/16", "2001:db8::/32", "2001:db8::1"},
+			aggregation: Aggregation{MaxWidthIPv4: 32, MaxWidthIPv6: 128},
+			cleanIPs:    []string{"10.0.0.0/8", "2001:db8::/32"},
+			stats:       CleanStats{Covered: 4},
+		},
+		"aggregation": {
+			ips: []string{
+				"1.2.3.0", "1.2.3.1", "1.2.3.2", "1.2.3.3",
+				"1.2.4.0/25", "1.2.4.128/25", "1.2.5.0/24",
+				"2001:db8::", "2001:db8::1",
+			},
+			aggregation: Aggregation{MaxWidthIPv4: 24, MaxWidthIPv6: 64},
+			cleanIPs:    []string{"1.2.3.0/30", "1.2.4.0/24", "1.2.5.0/24", "2001:db8::/127"},
+			stats:       CleanStats{Aggregated: 5},
+		},
+		"aggregation width": {
+			ips:         []string{"1.2.0.0/17", "1.2.128.0/17", "1.3.0.0/16", "1.4.0.0/16"},
+			aggregation: Aggregation{MaxWidthIPv4: 16},
+			cleanIPs:    []string{"1.2.0.0/16", "1.3.0.0/16", "1.4.0.0/16"},
+			stats:       CleanStats{Aggregated: 1},
+		},
+		"mixed families": {
+			ips: []string{
+				"2001:db8::1", "1.2.3.5", "::ffff:1.2.3.4", "2001:db8::/127",
+				"2001:db8:1::/48", "2001:db8:1:2::/64", "1.2.3.6/31",
+			},
+			aggregation: Aggregation{MaxWidthIPv4: 24, MaxWidthIPv6: 48},
+			cleanIPs:    []string{"1.2.3.4/30", "2001:db8:1::/48", "2001:db8::/127"},
+			stats:       CleanStats{Covered: 2, Aggregated: 2},
+		},
+		"wide CIDRs kept": {
+			ips:         []string{"1.0.0.0/8", "2.0.0.0/8", "3.0.0.0/24", "3.0.1.0/24"},
+			aggregation: Aggregation{MaxWidthIPv4: 24},
+			cleanIPs:    []string{"1.0.0.0/8", "2.0.0.0/8", "3.0.0.0/24", "3.0.1.0/24"},
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			builder := &Builder{}
+			cleanIPs, stats := builder.CleanIPs(testCase.ips, testCase.aggregation)
+			assert.Equal(t, testCase.cleanIPs, cleanIPs)
+			assert.Equal(t, testCase.stats, stats)
+		})
+	}
+}
+
+func Test_AttributeProvenance(t *testing.T) {
+	t.Parallel()
+
+	provenances := map[string]provenance.Entry{
+		"1.2.3.0":    {Sources: []int{0}, Lines: []string{"1.2.3.0"}},
+		"1.2.3.1":    {Sources: []int{1}, Lines: []string{"1.2.3.1 # x"}},
+		"10.0.0.0/8": {Sources: []int{1}},
+		"10.1.2.3":   {Sources: []int{2}},
+		"2001:db8::": {Sources: []int{0}},
+		"9.9.9.9":    {Sources: []int{0}},
+	}
+	entries := []string{"1.2.3.0/31", "10.0.0.0/8", "2001:db8::"}
+
+	attributed := AttributeProvenance(entries, provenances)
+
+	expected := map[string]provenance.Entry{
+		"1.2.3.0/31": {Sources: []int{0, 1}, Lines: []string{"1.2.3.0", "1.2.3.1 # x"}},
+		"10.0.0.0/8": {Sources: []int{1, 2}},
+		"2001:db8::": {Sources: []int{0}},
+	}
+	assert.Equal(t, expected, attributed)
+}
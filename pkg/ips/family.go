@@ -0,0 +1,19 @@
+package ips
+
+// SplitFamilies splits the IP addresses and CIDRs given into IPv4
+// and IPv6 entries, keeping their order. IPv4-mapped IPv6 addresses
+// are considered as IPv4 addresses, and invalid entries are ignored.
+func SplitFamilies(entries []string) (ipv4, ipv6 []string) {
+	ipv4, ipv6 = []string{}, []string{}
+	for _, entry := range entries {
+		prefix, err := ParsePrefix(entry)
+		switch {
+		case err != nil:
+		case prefix.Addr().Is4():
+			ipv4 = append(ipv4, entry)
+		default:
+			ipv6 = append(ipv6, entry)
+		}
+	}
+	return ipv4, ipv6
+}
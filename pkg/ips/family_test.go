@@ -0,0 +1,42 @@
+package ips
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_SplitFamilies(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		entries []string
+		ipv4    []string
+		ipv6    []string
+	}{
+		"empty": {
+			ipv4: []string{},
+			ipv6: []string{},
+		},
+		"mixed": {
+			entries: []string{"2001:db8::/32", "1.2.3.4", "::1", "1.2.3.0/24", "::ffff:5.6.7.8", "x"},
+			ipv4:    []string{"1.2.3.4", "1.2.3.0/24", "::ffff:5.6.7.8"},
+			ipv6:    []string{"2001:db8::/32", "::1"},
+		},
+		"IPv6 only": {
+			entries: []string{"2001:db8::1"},
+			ipv4:    []string{},
+			ipv6:    []string{"2001:db8::1"},
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ipv4, ipv6 := SplitFamilies(testCase.entries)
+			assert.Equal(t, testCase.ipv4, ipv4)
+			assert.Equal(t, testCase.ipv6, ipv6)
+		})
+	}
+}
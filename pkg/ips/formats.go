@@ -0,0 +1,119 @@
+package ips
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// ParseFunc parses a single pre-cleaned and transformed line, and returns
+// the IP addresses and CIDRs it contains.
+type ParseFunc func(line string) (entries []string)
+
+const (
+	// FormatPlain is the format for lists with one IP address or CIDR
+	// as first field of each line, such as "1.2.3.4", "1.2.3.0/24 ; SBL1"
+	// or "1.2.3.4	5". Comments starting with ';' are ignored.
+	FormatPlain = "plain"
+	// FormatRanges is the format for lists of IP address ranges, such as
+	// "1.2.3.0-1.2.3.255" or "1.2.3.0 1.2.3.255". Ranges are converted
+	// to the minimal list of CIDRs covering them.
+	FormatRanges = "ranges"
+	// FormatP2P is the PeerGuardian text format, such as
+	// "Some description:1.2.3.0-1.2.3.255".
+	FormatP2P = "p2p"
+)
+
+func defaultFormats() map[string]ParseFunc {
+	return map[string]ParseFunc{
+		FormatPlain:  parsePlain,
+		FormatRanges: parseRanges,
+		FormatP2P:    parseP2P,
+	}
+}
+
+// RegisterFormat registers a custom format parser with the name given,
+// overriding any existing format of the same name.
+func (b *Builder) RegisterFormat(name string, parse ParseFunc) {
+	b.formatsMu.Lock()
+	defer b.formatsMu.Unlock()
+	b.formats[name] = parse
+}
+
+var ErrFormatUnknown = errors.New("format is unknown")
+
+func (b *Builder) getFormat(name string) (parse ParseFunc, err error) {
+	if name == "" {
+		name = FormatPlain
+	}
+	b.formatsMu.RLock()
+	defer b.formatsMu.RUnlock()
+	parse, ok := b.formats[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrFormatUnknown, name)
+	}
+	return parse, nil
+}
+
+func parsePlain(line string) (entries []string) {
+	commentIndex := strings.IndexByte(line, ';')
+	if commentIndex >= 0 {
+		line = line[:commentIndex]
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil
+	}
+	return fields[:1]
+}
+
+func parseRanges(line string) (entries []string) {
+	var startString, endString string
+	fields := strings.Fields(strings.ReplaceAll(line, "-", " "))
+	switch len(fields) {
+	case 1:
+		return fields
+	case 2: //nolint:mnd
+		startString, endString = fields[0], fields[1]
+	default:
+		return nil
+	}
+
+	start, err := netip.ParseAddr(startString)
+	if err != nil {
+		return nil
+	}
+	end, err := netip.ParseAddr(endString)
+	if err != nil || start.Is4() != end.Is4() || end.Less(start) {
+		return nil
+	}
+
+	prefixes := rangeToPrefixes(start, end)
+	entries = make([]string, len(prefixes))
+	for i, prefix := range prefixes {
+		entries[i] = prefix.String()
+	}
+	return entries
+}
+
+func parseP2P(line string) (entries []string) {
+	// Both the description and IPv6 addresses can contain colons,
+	// so each colon is tried from the left as the separator.
+	start := 0
+	for {
+		colonIndex := strings.IndexByte(line[start:], ':')
+		if colonIndex == -1 {
+			return nil
+		}
+		start += colonIndex + 1
+		rangeString := line[start:]
+		if !strings.Contains(rangeString, "-") {
+			return nil
+		}
+		entries = parseRanges(rangeString)
+		if entries != nil {
+			return entries
+		}
+	}
+}
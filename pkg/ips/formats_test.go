@@ -0,0 +1,86 @@
+package ips
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_formats(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		parse   ParseFunc
+		line    string
+		entries []string
+	}{
+		"plain": {
+			parse:   parsePlain,
+			line:    "1.2.3.0/24 ; SBL1",
+			entries: []string{"1.2.3.0/24"},
+		},
+		"plain with count": {
+			parse:   parsePlain,
+			line:    "1.2.3.4\t5",
+			entries: []string{"1.2.3.4"},
+		},
+		"range": {
+			parse:   parseRanges,
+			line:    "1.2.3.0-1.2.4.1",
+			entries: []string{"1.2.3.0/24", "1.2.4.0/31"},
+		},
+		"range with spaces": {
+			parse:   parseRanges,
+			line:    "1.2.3.1 - 1.2.3.4",
+			entries: []string{"1.2.3.1/32", "1.2.3.2/31", "1.2.3.4/32"},
+		},
+		"IPv6 range": {
+			parse:   parseRanges,
+			line:    "2001:db8::-2001:db8::ffff",
+			entries: []string{"2001:db8::/112"},
+		},
+		"full IPv4 range": {
+			parse:   parseRanges,
+			line:    "0.0.0.0-255.255.255.255",
+			entries: []string{"0.0.0.0/0"},
+		},
+		"inverted range": {
+			parse: parseRanges,
+			line:  "1.2.3.4-1.2.3.0",
+		},
+		"p2p": {
+			parse:   parseP2P,
+			line:    "Some: description:1.2.3.0-1.2.3.255",
+			entries: []string{"1.2.3.0/24"},
+		},
+		"plain IPv6": {
+			parse:   parsePlain,
+			line:    "2001:db8::/32 ; SBL2",
+			entries: []string{"2001:db8::/32"},
+		},
+		"p2p IPv6": {
+			parse:   parseP2P,
+			line:    "Some: description:2001:db8::-2001:db8::ff",
+			entries: []string{"2001:db8::/120"},
+		},
+		"p2p IPv6 address range": {
+			parse:   parseP2P,
+			line:    "x:2001:db8::1-2001:db8::1",
+			entries: []string{"2001:db8::1/128"},
+		},
+		"p2p mixed families range": {
+			parse: parseP2P,
+			line:  "x:1.2.3.4-2001:db8::1",
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			entries := testCase.parse(testCase.line)
+
+			assert.Equal(t, testCase.entries, entries)
+		})
+	}
+}
@@ -4,117 +4,366 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"io"
-	"net"
-	"net/http"
-	"strings"
+	"net/netip"
+	"os"
 	"time"
+
+	"github.com/qdm12/updated/pkg/extsort"
+	"github.com/qdm12/updated/pkg/fetch"
+	"github.com/qdm12/updated/pkg/lastgood"
+	"github.com/qdm12/updated/pkg/provenance"
 )
 
-type sourceType struct {
-	url                 string
-	preClean            func(line string) string
-	checkLine           func(line string) bool
-	customPostCleanLine func(line string) string
+// Result is the result of building IP addresses from sources.
+type Result struct {
+	// IPs is the sorted list of unique IP addresses and CIDR ranges
+	// built. Ranges may overlap and should be cleaned with
+	// [Builder.CleanIPs].
+	IPs []string
+	// Stale lists the optional sources which failed.
+	Stale []lastgood.Stale
+	// Provenance maps each IP address and CIDR range to the indices
+	// of the sources producing it, and their raw lines if requested.
+	// It is nil if provenance tracking is off.
+	Provenance map[string]provenance.Entry
 }
 
-func (b *Builder) buildForSources(ctx context.Context, title string, sources []sourceType) (ips []string, err error) {
+// maxInMemoryIPs is the maximum number of IP addresses and CIDR ranges
+// kept in memory when sorting them, before spilling them to disk.
+const maxInMemoryIPs = 1 << 20
+
+// Build builds a list of IP addresses and CIDR ranges from the sources given.
+// The provenance mode given sets whether to record the sources producing
+// each IP address and CIDR range, and their raw lines. Entries are added
+// to the sorter as they are parsed, so a source is never held whole in
+// memory. Entries of an optional source read before it fails are kept, in
+// addition to its last known good entries. The title given, such as a
+// category name, keeps the last known good records of its sources separate
+// from the ones of other titles.
+func (b *Builder) Build(ctx context.Context, title string, sources []Source,
+	provenanceMode provenance.Mode,
+) (result Result, err error) {
 	b.logger.Infof("building %s IPs...", title)
-	for _, source := range sources {
-		newIPs, err := b.buildForSource(
-			ctx, source.url,
-			source.preClean,
-			source.checkLine,
-			source.customPostCleanLine,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("building from %s: %w", source.url, err)
+	sorter := extsort.New(b.tempDir, maxInMemoryIPs)
+	defer func() {
+		closeErr := sorter.Close()
+		if closeErr != nil {
+			b.logger.Warn("removing temporary sort files: " + closeErr.Error())
+		}
+	}()
+	totalIPs := 0
+
+	keepLines := provenanceMode == provenance.ModeLines
+	for sourceIndex, source := range sources {
+		sink := &sourceSink{
+			sorter:         sorter,
+			sourceIndex:    sourceIndex,
+			provenanceMode: provenanceMode,
 		}
-		ips = append(ips, newIPs...)
+		err = b.buildForSource(ctx, title, source, keepLines, sink)
+		switch {
+		case err == nil:
+		case !source.Optional || ctx.Err() != nil:
+			return Result{}, fmt.Errorf("building from %s: %w", source.URL, err)
+		default:
+			var stale lastgood.Stale
+			stale, err = b.loadLastGood(title, source, err, sink)
+			if err != nil {
+				return Result{}, fmt.Errorf("sorting IPs: %w", err)
+			}
+			b.logger.Warn(stale.String())
+			result.Stale = append(result.Stale, stale)
+		}
+		totalIPs += sink.ips
 	}
-	b.logger.Infof("built %s IPs: %d IP address lines fetched", title, len(ips))
-	return ips, nil
+
+	if provenanceMode == provenance.ModeOff {
+		err = sorter.Each(func(ip string) {
+			result.IPs = append(result.IPs, ip)
+		})
+	} else {
+		result.IPs, result.Provenance, err = collectProvenance(sorter)
+	}
+	if err != nil {
+		return Result{}, fmt.Errorf("sorting IPs: %w", err)
+	}
+
+	b.logger.Infof("built %s IPs: %d fetched, %d unique", title, totalIPs, len(result.IPs))
+	return result, nil
 }
 
-var ErrBadStatusCode = errors.New("bad HTTP status code")
+// sourceSink adds the IP addresses and CIDR ranges of a source to the
+// sorter as they are parsed, and writes them to the last known good
+// record of the source if any.
+type sourceSink struct {
+	sorter         *extsort.Sorter
+	sourceIndex    int
+	provenanceMode provenance.Mode
+	// lastGood is the last known good record being written, and is nil
+	// if the source is not optional or when replaying its record.
+	lastGood *lastgood.Writer
+	// lastGoodErr is the first error writing the last known good record.
+	lastGoodErr error
+	// ips is the number of IP addresses and CIDR ranges added.
+	ips int
+}
 
-func (b *Builder) buildForSource(ctx context.Context, url string,
-	preClean cleanLineFunc, checkLine checkLineFunc, postClean cleanLineFunc,
-) (ips []string, err error) {
-	b.logger.Debug("building IPs from " + url + "...")
-	tStart := time.Now()
+// addEntry adds the public IP address or CIDR range given to the sorter,
+// as a provenance record if provenance tracking is on. Line is the raw
+// line of the entry, and can be empty if not kept.
+func (s *sourceSink) addEntry(ip, line string) (err error) {
+	if s.lastGood != nil && s.lastGoodErr == nil {
+		s.lastGoodErr = s.lastGood.Add(ip)
+	}
 
-	content, err := getContent(ctx, b.client, url)
+	s.ips++
+	if s.provenanceMode != provenance.ModeOff {
+		ip = provenance.EncodeRecord(ip, s.sourceIndex, line)
+	}
+	err = s.sorter.Add(ip)
 	if err != nil {
-		return nil, fmt.Errorf("getting content: %w", err)
+		return fmt.Errorf("sorting IPs: %w", err)
 	}
+	return nil
+}
 
-	lines := strings.Split(string(content), "\n")
-	ips = make([]string, 0, len(lines))
-
-	for _, line := range lines {
-		line = preCleanLine(line, preClean)
+// collectProvenance collects the sorted unique IP addresses and CIDR
+// ranges and their provenance from the sorter containing provenance records.
+func collectProvenance(sorter *extsort.Sorter) (ips []string,
+	provenances map[string]provenance.Entry, err error,
+) {
+	provenances = make(map[string]provenance.Entry)
+	var decodeErr error
+	err = sorter.Each(func(record string) {
+		if decodeErr != nil {
+			return
+		}
+		ip, sourceIndex, line, err := provenance.DecodeRecord(record)
+		if err != nil {
+			decodeErr = err
+			return
+		}
 
-		if !isLineValid(line, checkLine) {
-			continue
+		entry, exists := provenances[ip]
+		if !exists {
+			ips = append(ips, ip)
 		}
+		entry.Add(sourceIndex, line)
+		provenances[ip] = entry
+	})
+	if err != nil {
+		return nil, nil, err
+	} else if decodeErr != nil {
+		return nil, nil, decodeErr
+	}
+	return ips, provenances, nil
+}
 
-		line = postCleanLine(line, postClean)
+// lastGoodKey returns the key of the last known good record of the source
+// for the title given, so sources shared by several titles such as categories
+// keep separate records of their entries transformed for each.
+func lastGoodKey(title string, source Source) string {
+	return "ips " + title + " " + source.URL
+}
 
-		// check for single IP
-		if IP := net.ParseIP(line); IP != nil {
-			if !netIPIsPrivate(IP) {
-				ips = append(ips, IP.String())
-			}
-			continue
-		}
+// createLastGood returns a writer for the last known good record of the
+// source given, or nil if the source is not optional or if there is no
+// fallback. Failing to create it is logged and does not fail the source.
+func (b *Builder) createLastGood(title string, source Source) (writer *lastgood.Writer) {
+	if !source.Optional || b.fallback == nil {
+		return nil
+	}
+	writer, err := b.fallback.Create(lastGoodKey(title, source))
+	if err != nil {
+		b.logger.Warn("saving last known good IPs of " + source.URL + ": " + err.Error())
+		return nil
+	}
+	return writer
+}
 
-		// check for CIDR
-		IP, CIDRPtr, err := net.ParseCIDR(line)
-		if err == nil {
-			if !netIPIsPrivate(IP) {
-				ips = append(ips, CIDRPtr.String())
-			}
-			continue
-		}
+// finishLastGood commits the last known good record written by the sink
+// if the source was built successfully, and aborts it otherwise.
+func (b *Builder) finishLastGood(source Source, sink *sourceSink, buildErr error) {
+	writer := sink.lastGood
+	sink.lastGood = nil
+	if writer == nil {
+		return
+	}
+
+	err := sink.lastGoodErr
+	switch {
+	case buildErr != nil:
+		writer.Abort()
+		return
+	case err != nil:
+		writer.Abort()
+	default:
+		err = writer.Commit()
+	}
+	if err != nil {
+		b.logger.Warn("saving last known good IPs of " + source.URL + ": " + err.Error())
+	}
+}
 
-		b.logger.Warn("Not an IP address nor an IP subnet: " + line)
+// loadLastGood adds the last known good entries of the source given to the
+// sink, once it failed with the build error given. It only returns an error
+// if the sink fails to add an entry.
+func (b *Builder) loadLastGood(title string, source Source, buildErr error, sink *sourceSink) (
+	stale lastgood.Stale, err error,
+) {
+	stale = lastgood.Stale{URL: source.URL, Err: buildErr}
+	if b.fallback == nil {
+		return stale, nil
 	}
 
-	b.logger.Info("built IPs from " + url + " during " + time.Since(tStart).String())
+	var sinkErr error
+	savedAt, err := b.fallback.Load(lastGoodKey(title, source), func(entry string, allowed bool) error {
+		if !allowed {
+			sinkErr = sink.addEntry(entry, "")
+		}
+		return sinkErr
+	})
+	switch {
+	case sinkErr != nil:
+		return stale, sinkErr
+	case errors.Is(err, os.ErrNotExist):
+		return stale, nil
+	case err != nil:
+		b.logger.Warn("loading last known good IPs of " + source.URL + ": " + err.Error())
+		return stale, nil
+	}
 
-	return ips, nil
+	stale.Fallback = true
+	stale.Age = time.Since(savedAt)
+	return stale, nil
 }
 
-func getContent(ctx context.Context, client *http.Client, url string) (content []byte, err error) {
-	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+// buildForSource parses the IP addresses and CIDR ranges of the source and
+// adds the public ones to the sink as they are parsed. If keepLines is true,
+// the raw line of each entry is given to the sink.
+func (b *Builder) buildForSource(ctx context.Context, title string, source Source, keepLines bool,
+	sink *sourceSink,
+) (err error) {
+	url := source.URL
+	b.logger.Debug("building IPs from " + url + "...")
+	tStart := time.Now()
+
+	parse, err := b.getFormat(source.Format)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	response, err := client.Do(request)
+	body, err := fetch.Open(ctx, b.client, url, source.Members)
 	if err != nil {
-		return nil, err
-	} else if response.StatusCode != http.StatusOK {
-		_ = response.Body.Close()
-		return nil, fmt.Errorf("%w: %d %s", ErrBadStatusCode, response.StatusCode, response.Status)
+		return err
 	}
 
-	content, err = io.ReadAll(response.Body)
+	sink.lastGood = b.createLastGood(title, source)
+	err = scanLines(body, source.Transform, func(rawLine, line string) error {
+		if !keepLines {
+			rawLine = ""
+		}
+		for _, entry := range parse(line) {
+			ip, ok := b.publicEntry(entry)
+			if !ok {
+				continue
+			}
+			err := sink.addEntry(ip, rawLine)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		_ = response.Body.Close()
-		return nil, err
+		_ = body.Close()
+		err = fmt.Errorf("reading lines: %w", err)
+	} else {
+		err = body.Close()
 	}
-
-	err = response.Body.Close()
+	b.finishLastGood(source, sink, err)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return content, nil
+	b.logger.Info("built IPs from " + url + " during " + time.Since(tStart).String())
+
+	return nil
+}
+
+// PublicIPs returns the public IPv4 and IPv6 addresses and CIDRs
+// of the entries given, such as IP entries found in hostnames sources.
+// IPv4-mapped IPv6 addresses are returned as IPv4 addresses.
+func (b *Builder) PublicIPs(entries []string) (ips []string) {
+	for _, entry := range entries {
+		ips = b.appendEntry(ips, entry)
+	}
+	return ips
 }
 
-func netIPIsPrivate(ip net.IP) bool {
-	return ip.IsPrivate() || ip.IsLoopback() ||
-		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast()
+// appendEntry appends the IPv4 or IPv6 address or CIDR given to the
+// entries, unless it is not public. IPv4-mapped IPv6 addresses are
+// appended as IPv4 addresses.
+func (b *Builder) appendEntry(ips []string, entry string) []string {
+	ip, ok := b.publicEntry(entry)
+	if ok {
+		ips = append(ips, ip)
+	}
+	return ips
+}
+
+// publicEntry returns the IPv4 or IPv6 address or CIDR given and true,
+// or false if it is not public. IPv4-mapped IPv6 addresses are
+// returned as IPv4 addresses.
+func (b *Builder) publicEntry(entry string) (ip string, ok bool) {
+	// check for single IP
+	if address, err := netip.ParseAddr(entry); err == nil {
+		address = address.WithZone("").Unmap()
+		if isPrivate(address) || address.IsUnspecified() {
+			return "", false
+		}
+		return address.String(), true
+	}
+
+	// check for CIDR
+	prefix, err := ParsePrefix(entry)
+	if err == nil {
+		if isPrivate(prefix.Addr()) {
+			return "", false
+		}
+		return prefix.String(), true
+	}
+
+	b.logger.Warn("Not an IP address nor an IP subnet: " + entry)
+	return "", false
+}
+
+// isPrivate returns true if the IPv4 or IPv6 address given is
+// private, loopback, link local or in another non public IPv6 range.
+func isPrivate(address netip.Addr) bool {
+	address = address.Unmap()
+	if address.IsPrivate() || address.IsLoopback() ||
+		address.IsLinkLocalUnicast() || address.IsLinkLocalMulticast() ||
+		address.IsInterfaceLocalMulticast() {
+		return true
+	}
+	if address.Is6() {
+		for _, prefix := range nonPublicIPv6Prefixes() {
+			if prefix.Contains(address) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// nonPublicIPv6Prefixes returns the non public IPv6 prefixes not
+// already covered by the [netip.Addr] methods, such as unique local
+// addresses fc00::/7 and link local addresses fe80::/10.
+func nonPublicIPv6Prefixes() []netip.Prefix {
+	return []netip.Prefix{
+		netip.MustParsePrefix("100::/64"),       // discard only
+		netip.MustParsePrefix("64:ff9b:1::/48"), // local use IPv4/IPv6 translation
+		netip.MustParsePrefix("fec0::/10"),      // deprecated site local
+	}
 }
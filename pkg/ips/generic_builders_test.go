@@ -0,0 +1,262 @@
+package ips
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qdm12/updated/pkg/fetch"
+	"github.com/qdm12/updated/pkg/lastgood"
+	"github.com/qdm12/updated/pkg/provenance"
+	"github.com/qdm12/updated/pkg/resolvecache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type noopLogger struct{}
+
+func (noopLogger) Debug(string)         {}
+func (noopLogger) Info(string)          {}
+func (noopLogger) Infof(string, ...any) {}
+func (noopLogger) Warn(string)          {}
+
+func Test_Builder_Build_localSources(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	plainPath := filepath.Join(dir, "plain.netset")
+	err := os.WriteFile(plainPath, []byte("1.2.3.4 # comment\n10.0.0.1\n5.6.7.0/24\n"), 0o600)
+	require.NoError(t, err)
+	rangesPath := filepath.Join(dir, "ranges", "list.txt")
+	require.NoError(t, os.MkdirAll(filepath.Dir(rangesPath), 0o700))
+	err = os.WriteFile(rangesPath, []byte("8.8.8.0-8.8.8.255\n"), 0o600)
+	require.NoError(t, err)
+
+	builder := New(nil, noopLogger{}, nil, t.TempDir())
+	sources := []Source{
+		{URL: "file://" + plainPath},
+		{URL: "file://" + filepath.Dir(rangesPath), Format: FormatRanges},
+	}
+
+	result, err := builder.Build(t.Context(), "test", sources, provenance.ModeOff)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1.2.3.4", "5.6.7.0/24", "8.8.8.0/24"}, result.IPs)
+	assert.Empty(t, result.Stale)
+
+	sources = []Source{{URL: "file://" + filepath.Join(dir, "missing")}}
+	_, err = builder.Build(t.Context(), "test", sources, provenance.ModeOff)
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func Test_Builder_Build_optionalFallback(t *testing.T) {
+	t.Parallel()
+
+	var fail atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if fail.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte("5.6.7.8\n1.2.3.4\n5.6.7.8\n"))
+	}))
+	t.Cleanup(server.Close)
+
+	builder := New(server.Client(), noopLogger{}, lastgood.New(t.TempDir()), t.TempDir())
+	sources := []Source{{URL: server.URL, Optional: true}}
+
+	result, err := builder.Build(t.Context(), "test", sources, provenance.ModeSources)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1.2.3.4", "5.6.7.8"}, result.IPs)
+	assert.Equal(t, []int{0}, result.Provenance["5.6.7.8"].Sources)
+	assert.Empty(t, result.Stale)
+
+	fail.Store(true)
+	result, err = builder.Build(t.Context(), "test", sources, provenance.ModeOff)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1.2.3.4", "5.6.7.8"}, result.IPs)
+	require.Len(t, result.Stale, 1)
+	assert.True(t, result.Stale[0].Fallback)
+
+	sources[0].Optional = false
+	_, err = builder.Build(t.Context(), "test", sources, provenance.ModeOff)
+	require.ErrorIs(t, err, fetch.ErrBadStatusCode)
+}
+
+func Test_Builder_appendEntry(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		entry string
+		ips   []string
+	}{
+		"public IPv4":            {entry: "1.2.3.4", ips: []string{"1.2.3.4"}},
+		"public IPv6":            {entry: "2001:db8::1", ips: []string{"2001:db8::1"}},
+		"IPv6 not canonical":     {entry: "2001:DB8:0:0::1", ips: []string{"2001:db8::1"}},
+		"IPv4-mapped IPv6":       {entry: "::ffff:1.2.3.4", ips: []string{"1.2.3.4"}},
+		"IPv4 CIDR not masked":   {entry: "1.2.3.4/24", ips: []string{"1.2.3.0/24"}},
+		"IPv6 CIDR":              {entry: "2001:db8::1/32", ips: []string{"2001:db8::/32"}},
+		"IPv4-mapped IPv6 CIDR":  {entry: "::ffff:1.2.3.0/120", ips: []string{"1.2.3.0/24"}},
+		"private IPv4":           {entry: "10.0.0.1"},
+		"loopback IPv4":          {entry: "127.0.0.1"},
+		"unspecified IPv4":       {entry: "0.0.0.0"},
+		"unique local IPv6":      {entry: "fd00::1"},
+		"unique local IPv6 CIDR": {entry: "fc00::/7"},
+		"loopback IPv6":          {entry: "::1"},
+		"unspecified IPv6":       {entry: "::"},
+		"link local IPv6":        {entry: "fe80::1%eth0"},
+		"site local IPv6":        {entry: "fec0::1"},
+		"discard only IPv6":      {entry: "100::1"},
+		"invalid":                {entry: "1.2.3"},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			builder := New(nil, noopLogger{}, nil, t.TempDir())
+			ips := builder.appendEntry(nil, testCase.entry)
+			assert.Equal(t, testCase.ips, ips)
+		})
+	}
+}
+
+func Test_Builder_BuildIPsFromHostnames(t *testing.T) {
+	t.Parallel()
+
+	builder := New(nil, noopLogger{}, nil, t.TempDir())
+	builder.lookupIP = func(ctx context.Context, host string) ([]netip.Addr, time.Duration, error) {
+		switch host {
+		case "mixed.com":
+			return []netip.Addr{netip.MustParseAddr("1.2.3.4"), netip.MustParseAddr("2001:db8::1")}, 0, nil
+		case "sinkholed.com":
+			return []netip.Addr{
+				netip.MustParseAddr("0.0.0.0"), netip.MustParseAddr("::"),
+				netip.MustParseAddr("::1"), netip.MustParseAddr("::ffff:127.0.0.1"),
+			}, 0, nil
+		case "missing.com":
+			return nil, 0, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+		case "servfail.com":
+			return nil, 0, &net.DNSError{Err: "server misbehaving", Name: host, IsTemporary: true}
+		case "slow.com":
+			<-ctx.Done()
+			return nil, 0, ctx.Err()
+		default:
+			return nil, 0, errors.New("unexpected")
+		}
+	}
+
+	hostnames := []string{"mixed.com", "sinkholed.com", "missing.com", "servfail.com", "slow.com", "other.com"}
+	settings := ResolveSettings{Limiter: NewResolveLimiter(2, 1000), Timeout: time.Millisecond}
+	ips, stats, err := builder.BuildIPsFromHostnames(t.Context(), hostnames, settings)
+	require.NoError(t, err)
+	slices.Sort(ips)
+	assert.Equal(t, []string{"1.2.3.4", "2001:db8::1"}, ips)
+	expectedStats := ResolveStats{Resolved: 2, NXDomain: 1, Timeout: 1, ServFail: 1, Other: 1}
+	assert.Equal(t, expectedStats, stats)
+}
+
+func Test_Builder_BuildIPsFromHostnames_canceled(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(t.Context())
+	builder := New(nil, noopLogger{}, nil, t.TempDir())
+	builder.lookupIP = func(ctx context.Context, host string) ([]netip.Addr, time.Duration, error) {
+		if host == "cancel.com" {
+			cancel()
+			return nil, 0, ctx.Err()
+		}
+		return []netip.Addr{netip.MustParseAddr("1.2.3.4")}, 0, nil
+	}
+
+	hostnames := []string{"a.com", "cancel.com", "b.com", "c.com"}
+	settings := ResolveSettings{Limiter: NewResolveLimiter(1, 0), Timeout: time.Second}
+	ips, _, err := builder.BuildIPsFromHostnames(ctx, hostnames, settings)
+	require.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, []string{"1.2.3.4"}, ips)
+}
+
+func Test_Builder_BuildIPsFromHostnames_cache(t *testing.T) {
+	t.Parallel()
+
+	cachePath := filepath.Join(t.TempDir(), "resolve.json")
+	cache, err := resolvecache.New(cachePath, time.Hour, 24*time.Hour)
+	require.NoError(t, err)
+
+	var lookups atomic.Int32
+	failing := false
+	builder := New(nil, noopLogger{}, nil, t.TempDir())
+	builder.lookupIP = func(_ context.Context, host string) ([]netip.Addr, time.Duration, error) {
+		lookups.Add(1)
+		if failing {
+			return nil, 0, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+		}
+		return []netip.Addr{netip.MustParseAddr("1.2.3.4")}, time.Minute, nil
+	}
+
+	settings := ResolveSettings{Limiter: NewResolveLimiter(1, 0), Timeout: time.Second, Cache: cache}
+	hostnames := []string{"a.com"}
+	ips, stats, err := builder.BuildIPsFromHostnames(t.Context(), hostnames, settings)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1.2.3.4"}, ips)
+	assert.Equal(t, ResolveStats{Resolved: 1}, stats)
+
+	// Fresh for the minimum freshness of one hour, despite the TTL of one minute.
+	ips, stats, err = builder.BuildIPsFromHostnames(t.Context(), hostnames, settings)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1.2.3.4"}, ips)
+	assert.Equal(t, ResolveStats{Cached: 1}, stats)
+	assert.Equal(t, int32(1), lookups.Load())
+
+	// Hostname stops resolving, its IP address is kept for the grace period.
+	cache, err = resolvecache.New(cachePath, 0, 24*time.Hour)
+	require.NoError(t, err)
+	cache.Resolved("a.com", []string{"1.2.3.4"}, 0, time.Now().Add(-time.Hour))
+	settings.Cache = cache
+	failing = true
+	ips, stats, err = builder.BuildIPsFromHostnames(t.Context(), hostnames, settings)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1.2.3.4"}, ips)
+	assert.Equal(t, ResolveStats{NXDomain: 1, Kept: 1}, stats)
+}
+
+func Test_Builder_BuildIPsFromHostnames_sharedLimiter(t *testing.T) {
+	t.Parallel()
+
+	var inFlight, maxInFlight atomic.Int32
+	builder := New(nil, noopLogger{}, nil, t.TempDir())
+	builder.lookupIP = func(_ context.Context, _ string) ([]netip.Addr, time.Duration, error) {
+		current := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			previous := maxInFlight.Load()
+			if current <= previous || maxInFlight.CompareAndSwap(previous, current) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		return []netip.Addr{netip.MustParseAddr("1.2.3.4")}, 0, nil
+	}
+
+	settings := ResolveSettings{Limiter: NewResolveLimiter(2, 0), Timeout: time.Second}
+	hostnames := []string{"a.com", "b.com", "c.com", "d.com", "e.com", "f.com"}
+	var wg sync.WaitGroup
+	for range 3 {
+		wg.Go(func() {
+			_, stats, err := builder.BuildIPsFromHostnames(t.Context(), hostnames, settings)
+			assert.NoError(t, err)
+			assert.Equal(t, len(hostnames), stats.Resolved)
+		})
+	}
+	wg.Wait()
+	assert.LessOrEqual(t, maxInFlight.Load(), int32(2))
+}
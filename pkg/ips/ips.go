@@ -2,17 +2,31 @@
 package ips
 
 import (
-	"net"
+	"context"
 	"net/http"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/qdm12/updated/pkg/lastgood"
 )
 
 // Builder builds IP lists.
 type Builder struct {
-	client   *http.Client
-	logger   Logger
-	lookupIP func(host string) ([]net.IP, error)
+	client    *http.Client
+	logger    Logger
+	lookupIP  LookupIPFunc
+	fallback  Fallback
+	tempDir   string
+	formats   map[string]ParseFunc
+	formatsMu sync.RWMutex
 }
 
+// LookupIPFunc returns the IP addresses of a host, and the duration
+// during which they can be cached, or 0 if it is unknown.
+type LookupIPFunc func(ctx context.Context, host string) (
+	addresses []netip.Addr, ttl time.Duration, err error)
+
 // Logger represents a minimal logger interface.
 type Logger interface {
 	Debug(s string)
@@ -21,11 +35,31 @@ type Logger interface {
 	Warn(s string)
 }
 
+// Fallback saves and loads the last known good entries of sources.
+type Fallback interface {
+	Create(key string) (writer *lastgood.Writer, err error)
+	Load(key string, fn func(entry string, allowed bool) error) (savedAt time.Time, err error)
+}
+
+// ResolveCache caches the IP addresses of hostnames across runs.
+type ResolveCache interface {
+	Get(hostname string, now time.Time) (ips []string, fresh bool)
+	Resolved(hostname string, ips []string, ttl time.Duration, now time.Time)
+	Failed(hostname string, notFound bool, now time.Time) (ips []string)
+}
+
 // New returns a new builder of IP lists.
-func New(client *http.Client, logger Logger) *Builder {
+// The fallback is used to save and load the last known good entries
+// of optional sources, and can be nil to disable this feature.
+// The temporary directory is used to sort large lists of IP addresses
+// on disk, and can be empty to use the default temporary directory.
+func New(client *http.Client, logger Logger, fallback Fallback, tempDir string) *Builder {
 	return &Builder{
 		client:   client,
 		logger:   logger,
-		lookupIP: net.LookupIP,
+		lookupIP: lookupNetIP,
+		fallback: fallback,
+		tempDir:  tempDir,
+		formats:  defaultFormats(),
 	}
 }
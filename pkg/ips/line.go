@@ -1,46 +1,53 @@
 package ips
 
 import (
+	"bufio"
+	"io"
 	"strings"
-)
 
-type cleanLineFunc func(line string) (cleaned string)
+	"github.com/qdm12/updated/pkg/transform"
+)
 
-type checkLineFunc func(line string) (ok bool)
+func preCleanLine(line string) string {
+	line, _, _ = strings.Cut(line, "#")
+	return strings.TrimSpace(line)
+}
 
-func preCleanLine(line string, preClean cleanLineFunc) string {
-	// remove comment
-	var lineWithoutComment string
-	for _, r := range line {
-		if r == '#' {
-			break
-		}
-		lineWithoutComment += string(r)
+func cleanLine(line string, pipeline transform.Pipeline) (cleaned string, ok bool) {
+	line = preCleanLine(line)
+	if line == "" {
+		return "", false
 	}
-	line = lineWithoutComment
-
-	line = strings.TrimSpace(line)
 
-	if preClean != nil {
-		line = preClean(line)
+	line, ok = pipeline.Apply(line)
+	if !ok {
+		return "", false
 	}
 
-	return line
-}
-
-func isLineValid(line string, checkLine checkLineFunc) bool {
-	if line == "" {
-		return false
-	} else if checkLine == nil {
-		return true
-	}
-	return checkLine(line)
+	line = strings.TrimSpace(line)
+	return line, line != ""
 }
 
-func postCleanLine(line string, postClean cleanLineFunc) string {
-	line = strings.TrimSpace(line)
-	if postClean != nil {
-		line = postClean(line)
+// scanLines reads lines from the reader one at a time, and calls
+// fn for each of them once cleaned and transformed, together with
+// the raw line trimmed from surrounding spaces. It stops at the
+// first error returned by fn.
+func scanLines(reader io.Reader, pipeline transform.Pipeline,
+	fn func(rawLine, line string) error,
+) (err error) {
+	scanner := bufio.NewScanner(reader)
+	const maxLineLength = 1024 * 1024
+	scanner.Buffer(nil, maxLineLength)
+	for scanner.Scan() {
+		rawLine := scanner.Text()
+		line, ok := cleanLine(rawLine, pipeline)
+		if !ok {
+			continue
+		}
+		err = fn(strings.TrimSpace(rawLine), line)
+		if err != nil {
+			return err
+		}
 	}
-	return line
+	return scanner.Err()
 }
@@ -1,25 +1,112 @@
 package ips
 
 import (
+	"bytes"
+	"fmt"
+	"math/rand/v2"
+	"slices"
 	"testing"
 
+	"github.com/qdm12/updated/pkg/extsort"
+	"github.com/qdm12/updated/pkg/transform"
+
 	"github.com/stretchr/testify/assert"
 )
 
 func Test_preCleanLine(t *testing.T) {
 	t.Parallel()
 	tests := map[string]struct {
-		line               string
-		customPreCleanLine func(line string) string
-		cleanedLine        string
+		line        string
+		cleanedLine string
 	}{
-		"empty input": {"", func(line string) string { return line }, ""},
+		"empty input":     {"", ""},
+		"comment removed": {" 1.2.3.4 # comment", "1.2.3.4"},
 	}
 	for name, tc := range tests {
 		t.Run(name, func(t *testing.T) {
 			t.Parallel()
-			cleanedLine := preCleanLine(tc.line, tc.customPreCleanLine)
+			cleanedLine := preCleanLine(tc.line)
 			assert.Equal(t, tc.cleanedLine, cleanedLine)
 		})
 	}
 }
+
+// makeIPsContent returns a synthetic IP list content
+// with the number of lines given.
+func makeIPsContent(lines int) []byte {
+	const unique = 2_000_000
+	random := rand.New(rand.NewPCG(1, 2)) //nolint:gosec
+	buffer := bytes.NewBuffer(nil)
+	for i := range lines {
+		if i%100 == 0 {
+			buffer.WriteString("# Comment line\n")
+			continue
+		}
+		n := random.IntN(unique)
+		fmt.Fprintf(buffer, "%d.%d.%d.0/24 # comment\n", 1+n>>16, n>>8&0xff, n&0xff)
+	}
+	return buffer.Bytes()
+}
+
+// Benchmark_legacyBuild benchmarks the former approach collecting every
+// entry of the source in memory before deduplicating them, to compare
+// with [Benchmark_streamingBuild].
+func Benchmark_legacyBuild(b *testing.B) {
+	content := makeIPsContent(5_000_000)
+	builder := New(nil, noopLogger{}, nil, "")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for b.Loop() {
+		var ips []string
+		err := scanLines(bytes.NewReader(content), transform.Pipeline{}, func(_, line string) error {
+			for _, entry := range parsePlain(line) {
+				ips = builder.appendEntry(ips, entry)
+			}
+			return nil
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+		slices.Sort(ips)
+		_ = slices.Compact(ips)
+	}
+}
+
+func Benchmark_streamingBuild(b *testing.B) {
+	content := makeIPsContent(5_000_000)
+	builder := New(nil, noopLogger{}, nil, "")
+	dir := b.TempDir()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for b.Loop() {
+		sorter := extsort.New(dir, maxInMemoryIPs)
+		sink := &sourceSink{sorter: sorter}
+		err := scanLines(bytes.NewReader(content), transform.Pipeline{}, func(_, line string) error {
+			for _, entry := range parsePlain(line) {
+				ip, ok := builder.publicEntry(entry)
+				if !ok {
+					continue
+				}
+				err := sink.addEntry(ip, "")
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+		var sorted []string
+		err = sorter.Each(func(ip string) {
+			sorted = append(sorted, ip)
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+		err = sorter.Close()
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
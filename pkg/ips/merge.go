@@ -0,0 +1,140 @@
+package ips
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+)
+
+var ErrEntryNotValid = errors.New("IP address or CIDR is not valid")
+
+// MergePrefixes parses the IP addresses and CIDRs given, merges overlapping
+// and adjacent ones, and returns the minimal lists of IPv4 and IPv6 prefixes
+// covering the same addresses, in ascending order. The prefixes returned do
+// not overlap, so they can be loaded as is in firewall interval sets.
+// IPv4-mapped IPv6 addresses are considered as IPv4 addresses.
+func MergePrefixes(entries []string) (ipv4, ipv6 []netip.Prefix, err error) {
+	var ranges4, ranges6 []addressRange
+	for _, entry := range entries {
+		prefix, err := ParsePrefix(entry)
+		if err != nil {
+			return nil, nil, err
+		}
+		r := prefixToRange(prefix)
+		if prefix.Addr().Is4() {
+			ranges4 = append(ranges4, r)
+		} else {
+			ranges6 = append(ranges6, r)
+		}
+	}
+
+	return mergeRanges(ranges4, true), mergeRanges(ranges6, false), nil
+}
+
+// ParsePrefix parses the IP address or CIDR given as a masked prefix.
+// IPv4-mapped IPv6 prefixes are unmapped to IPv4 prefixes, and are not
+// valid if shorter than /96, since they then cover more than the IPv4
+// address space.
+func ParsePrefix(entry string) (prefix netip.Prefix, err error) {
+	prefix, err = netip.ParsePrefix(entry)
+	if err != nil {
+		address, addrErr := netip.ParseAddr(entry)
+		if addrErr != nil {
+			return netip.Prefix{}, fmt.Errorf("%w: %s", ErrEntryNotValid, entry)
+		}
+		prefix = netip.PrefixFrom(address, address.BitLen())
+	}
+	if prefix.Addr().Is4In6() {
+		const ipv4In6Bits = 96
+		if prefix.Bits() < ipv4In6Bits {
+			return netip.Prefix{}, fmt.Errorf("%w: %s is an IPv4-mapped prefix shorter than /%d",
+				ErrEntryNotValid, entry, ipv4In6Bits)
+		}
+		prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-ipv4In6Bits)
+	}
+	return prefix.Masked(), nil
+}
+
+// addressRange is a range of addresses from start to end included.
+type addressRange struct {
+	start, end uint128
+}
+
+func prefixToRange(prefix netip.Prefix) addressRange {
+	start := uint128FromAddr(prefix.Addr())
+	hostBits := prefix.Addr().BitLen() - prefix.Bits()
+	var size uint128
+	if hostBits == 128 { //nolint:mnd
+		size = uint128{hi: ^uint64(0), lo: ^uint64(0)}
+	} else {
+		size = pow2(hostBits).sub(uint128{lo: 1})
+	}
+	end, _ := start.add(size)
+	return addressRange{start: start, end: end}
+}
+
+// mergeRanges merges the overlapping and adjacent ranges given, and returns
+// the prefixes covering the merged ranges.
+func mergeRanges(ranges []addressRange, is4 bool) (prefixes []netip.Prefix) {
+	if len(ranges) == 0 {
+		return nil
+	}
+
+	slices.SortFunc(ranges, func(a, b addressRange) int {
+		return a.start.cmp(b.start)
+	})
+
+	merged := ranges[:1]
+	for _, r := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		next, overflow := last.end.add(uint128{lo: 1})
+		if overflow || r.start.cmp(next) <= 0 {
+			if r.end.cmp(last.end) > 0 {
+				last.end = r.end
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+
+	for _, r := range merged {
+		prefixes = append(prefixes, rangeToPrefixes(r.start.toAddr(is4), r.end.toAddr(is4))...)
+	}
+	return prefixes
+}
+
+// SubtractPrefixes returns the minimal list of prefixes covering the
+// addresses of prefix not contained in any of the excluded prefixes,
+// in ascending order. Excluded prefixes of the other IP family are ignored.
+func SubtractPrefixes(prefix netip.Prefix, excluded []netip.Prefix) (remaining []netip.Prefix) {
+	prefix = prefix.Masked()
+	is4 := prefix.Addr().Is4()
+	full := prefixToRange(prefix)
+	ranges := make([]addressRange, 0, len(excluded))
+	for _, excludedPrefix := range excluded {
+		if excludedPrefix.Addr().Is4() != is4 || !excludedPrefix.Overlaps(prefix) {
+			continue
+		}
+		ranges = append(ranges, prefixToRange(excludedPrefix.Masked()))
+	}
+	slices.SortFunc(ranges, func(a, b addressRange) int {
+		return a.start.cmp(b.start)
+	})
+
+	current := full.start
+	for _, r := range ranges {
+		if r.end.cmp(current) < 0 {
+			continue
+		}
+		if r.start.cmp(current) > 0 {
+			last := r.start.sub(uint128{lo: 1})
+			remaining = append(remaining, rangeToPrefixes(current.toAddr(is4), last.toAddr(is4))...)
+		}
+		if r.end.cmp(full.end) >= 0 {
+			return remaining
+		}
+		current, _ = r.end.add(uint128{lo: 1})
+	}
+	return append(remaining, rangeToPrefixes(current.toAddr(is4), full.end.toAddr(is4))...)
+}
@@ -0,0 +1,80 @@
+package ips
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_MergePrefixes(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		entries    []string
+		ipv4       []netip.Prefix
+		ipv6       []netip.Prefix
+		errMessage string
+	}{
+		"empty": {},
+		"nested CIDRs": {
+			entries: []string{"10.0.0.0/8", "10.1.0.0/16", "10.1.2.3"},
+			ipv4:    []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+		},
+		"adjacent CIDRs": {
+			entries: []string{"1.2.3.0/25", "1.2.3.128/25", "1.2.4.0"},
+			ipv4: []netip.Prefix{
+				netip.MustParsePrefix("1.2.3.0/24"),
+				netip.MustParsePrefix("1.2.4.0/32"),
+			},
+		},
+		"overlapping CIDRs not aligned": {
+			entries: []string{"1.2.3.0/24", "1.2.3.128/25", "1.2.2.255"},
+			ipv4: []netip.Prefix{
+				netip.MustParsePrefix("1.2.2.255/32"),
+				netip.MustParsePrefix("1.2.3.0/24"),
+			},
+		},
+		"mixed families": {
+			entries: []string{"2001:db8::/33", "2001:db8:8000::/33", "::ffff:1.2.3.4", "::1"},
+			ipv4:    []netip.Prefix{netip.MustParsePrefix("1.2.3.4/32")},
+			ipv6: []netip.Prefix{
+				netip.MustParsePrefix("::1/128"),
+				netip.MustParsePrefix("2001:db8::/32"),
+			},
+		},
+		"all addresses": {
+			entries: []string{"::/0", "2001:db8::1", "0.0.0.0/0", "1.2.3.4"},
+			ipv4:    []netip.Prefix{netip.MustParsePrefix("0.0.0.0/0")},
+			ipv6:    []netip.Prefix{netip.MustParsePrefix("::/0")},
+		},
+		"IPv4-mapped CIDR": {
+			entries: []string{"::ffff:1.2.3.0/120"},
+			ipv4:    []netip.Prefix{netip.MustParsePrefix("1.2.3.0/24")},
+		},
+		"malformed": {
+			entries:    []string{"x"},
+			errMessage: "IP address or CIDR is not valid: x",
+		},
+		"IPv4-mapped CIDR shorter than /96": {
+			entries: []string{"1.2.3.4", "::ffff:0:0/80"},
+			errMessage: "IP address or CIDR is not valid: " +
+				"::ffff:0:0/80 is an IPv4-mapped prefix shorter than /96",
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ipv4, ipv6, err := MergePrefixes(testCase.entries)
+			assert.Equal(t, testCase.ipv4, ipv4)
+			assert.Equal(t, testCase.ipv6, ipv6)
+			if testCase.errMessage != "" {
+				assert.EqualError(t, err, testCase.errMessage)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package ips
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/netip"
+	"os"
+	"slices"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// Protection holds the options protecting shared IP addresses, such as
+// the IP addresses of CDNs and hosting providers, from being added to
+// the IPs lists when resolving hostnames.
+type Protection struct {
+	// MaxDomains is the maximum number of unrelated domains with hostnames
+	// resolving to an IP address for the IP address to be kept. Hostnames
+	// are unrelated if their registrable domains differ, for example
+	// "ads.example.com" and "tracker.example.net". 0 disables the limit.
+	MaxDomains int
+	// Ranges are the protected ranges, and resolved IP addresses
+	// inside any of them are dropped.
+	Ranges []ProtectedRange
+}
+
+// ProtectedRange is a protected IP address range.
+type ProtectedRange struct {
+	// Prefix is the CIDR of the range.
+	Prefix netip.Prefix
+	// Source is the source of the range, such as a file path.
+	Source string
+}
+
+var ErrProtectedRangesEmpty = errors.New("no protected range found")
+
+// ReadProtectedRanges reads the protected ranges of the file at the path
+// given. For a JSON file, such as the IP ranges files published by cloud
+// providers, every string value which is an IP address or a CIDR is a
+// protected range. Otherwise, the file has an IP address or CIDR per line,
+// and other lines are ignored.
+func ReadProtectedRanges(path string) (ranges []ProtectedRange, err error) {
+	data, err := os.ReadFile(path) //nolint:gosec
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []string
+	var document any
+	if json.Unmarshal(data, &document) == nil {
+		entries = jsonStrings(document, entries)
+	} else {
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			entries = append(entries, parsePlain(scanner.Text())...)
+		}
+		err = scanner.Err()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, entry := range entries {
+		prefix, err := ParsePrefix(entry)
+		if err != nil {
+			continue
+		}
+		ranges = append(ranges, ProtectedRange{Prefix: prefix, Source: path})
+	}
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("%w: in %s", ErrProtectedRangesEmpty, path)
+	}
+	return ranges, nil
+}
+
+// jsonStrings appends all the string values found in the decoded
+// JSON value to the strings given.
+func jsonStrings(value any, strings []string) []string {
+	switch value := value.(type) {
+	case string:
+		return append(strings, value)
+	case []any:
+		for _, element := range value {
+			strings = jsonStrings(element, strings)
+		}
+	case map[string]any:
+		for _, element := range value {
+			strings = jsonStrings(element, strings)
+		}
+	}
+	return strings
+}
+
+// protect returns the IP addresses of the hostnames given, dropping the
+// IP addresses shared by too many unrelated domains and the IP addresses
+// inside protected ranges. Each IP address dropped is logged with the
+// reason it is dropped.
+func (b *Builder) protect(hostnameIPs map[string][]string, protection Protection,
+	stats *ResolveStats,
+) (ips []string) {
+	domains := make(map[string]map[string]struct{})
+	for hostname, hostIPs := range hostnameIPs {
+		domain, err := publicsuffix.EffectiveTLDPlusOne(hostname)
+		if err != nil {
+			domain = hostname
+		}
+		for _, ip := range hostIPs {
+			if domains[ip] == nil {
+				domains[ip] = make(map[string]struct{})
+			}
+			domains[ip][domain] = struct{}{}
+		}
+	}
+
+	protected := newRangesIndex(protection.Ranges)
+	for _, ip := range slices.Sorted(maps.Keys(domains)) {
+		ipDomains := domains[ip]
+		if protection.MaxDomains > 0 && len(ipDomains) > protection.MaxDomains {
+			stats.Shared++
+			const maxExamples = 3
+			examples := slices.Sorted(maps.Keys(ipDomains))
+			examples = examples[:min(maxExamples, len(examples))]
+			b.logger.Infof("dropping resolved IP address %s: shared by %d unrelated domains such as %v",
+				ip, len(ipDomains), examples)
+			continue
+		}
+		if protectedRange, ok := protected.find(ip); ok {
+			stats.Protected++
+			b.logger.Infof("dropping resolved IP address %s: in protected range %s from %s",
+				ip, protectedRange.Prefix, protectedRange.Source)
+			continue
+		}
+		ips = append(ips, ip)
+	}
+	return ips
+}
+
+// rangesIndex indexes protected ranges by prefix length, to find
+// the range containing an IP address with a lookup per length.
+type rangesIndex struct {
+	bits   []int
+	ranges map[netip.Prefix]ProtectedRange
+}
+
+func newRangesIndex(ranges []ProtectedRange) (index rangesIndex) {
+	index.ranges = make(map[netip.Prefix]ProtectedRange, len(ranges))
+	for _, protectedRange := range ranges {
+		prefix := protectedRange.Prefix.Masked()
+		if _, exists := index.ranges[prefix]; !exists {
+			index.ranges[prefix] = protectedRange
+		}
+		if !slices.Contains(index.bits, prefix.Bits()) {
+			index.bits = append(index.bits, prefix.Bits())
+		}
+	}
+	slices.Sort(index.bits)
+	return index
+}
+
+// find returns the widest protected range containing the IP address given.
+func (i rangesIndex) find(ip string) (protectedRange ProtectedRange, ok bool) {
+	address, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ProtectedRange{}, false
+	}
+	for _, bits := range i.bits {
+		prefix, err := address.Prefix(bits)
+		if err != nil {
+			continue
+		}
+		protectedRange, ok = i.ranges[prefix]
+		if ok {
+			return protectedRange, true
+		}
+	}
+	return ProtectedRange{}, false
+}
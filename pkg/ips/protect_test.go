@@ -0,0 +1,85 @@
+package ips
+
+import (
+	"context"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ReadProtectedRanges(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	jsonPath := filepath.Join(dir, "ip-ranges.json")
+	const jsonData = `{
+  "syncToken": "1700000000",
+  "prefixes": [{"ip_prefix": "3.5.140.0/22", "region": "ap-northeast-2"}],
+  "ipv6_prefixes": [{"ipv6_prefix": "2600:1f00::/24", "region": "us-east-1"}]
+}`
+	require.NoError(t, os.WriteFile(jsonPath, []byte(jsonData), 0o600))
+	textPath := filepath.Join(dir, "cloudflare.txt")
+	require.NoError(t, os.WriteFile(textPath, []byte("# Cloudflare\n104.16.0.0/13\n172.64.0.0/13 ; comment\n"), 0o600))
+
+	ranges, err := ReadProtectedRanges(jsonPath)
+	require.NoError(t, err)
+	expected := []ProtectedRange{
+		{Prefix: netip.MustParsePrefix("3.5.140.0/22"), Source: jsonPath},
+		{Prefix: netip.MustParsePrefix("2600:1f00::/24"), Source: jsonPath},
+	}
+	assert.ElementsMatch(t, expected, ranges)
+
+	ranges, err = ReadProtectedRanges(textPath)
+	require.NoError(t, err)
+	expected = []ProtectedRange{
+		{Prefix: netip.MustParsePrefix("104.16.0.0/13"), Source: textPath},
+		{Prefix: netip.MustParsePrefix("172.64.0.0/13"), Source: textPath},
+	}
+	assert.Equal(t, expected, ranges)
+
+	emptyPath := filepath.Join(dir, "empty.json")
+	require.NoError(t, os.WriteFile(emptyPath, []byte(`{"prefixes": []}`), 0o600))
+	_, err = ReadProtectedRanges(emptyPath)
+	require.ErrorIs(t, err, ErrProtectedRangesEmpty)
+}
+
+func Test_Builder_BuildIPsFromHostnames_protection(t *testing.T) {
+	t.Parallel()
+
+	builder := New(nil, noopLogger{}, nil, t.TempDir())
+	builder.lookupIP = func(_ context.Context, host string) ([]netip.Addr, time.Duration, error) {
+		switch host {
+		case "ads.one.com", "tracker.one.com", "ads.two.com":
+			return []netip.Addr{netip.MustParseAddr("1.1.1.1")}, 0, nil
+		case "ads.three.co.uk":
+			return []netip.Addr{netip.MustParseAddr("1.1.1.1"), netip.MustParseAddr("2.2.2.2")}, 0, nil
+		case "cdn.four.com":
+			return []netip.Addr{netip.MustParseAddr("104.16.1.1"), netip.MustParseAddr("3.3.3.3")}, 0, nil
+		default:
+			return nil, 0, nil
+		}
+	}
+
+	hostnames := []string{"ads.one.com", "tracker.one.com", "ads.two.com", "ads.three.co.uk", "cdn.four.com"}
+	settings := ResolveSettings{
+		Limiter: NewResolveLimiter(2, 0),
+		Timeout: time.Second,
+		Protection: Protection{
+			MaxDomains: 2,
+			Ranges: []ProtectedRange{
+				{Prefix: netip.MustParsePrefix("104.16.0.0/13"), Source: "cloudflare.txt"},
+			},
+		},
+	}
+	ips, stats, err := builder.BuildIPsFromHostnames(t.Context(), hostnames, settings)
+	require.NoError(t, err)
+	slices.Sort(ips)
+	assert.Equal(t, []string{"2.2.2.2", "3.3.3.3"}, ips)
+	assert.Equal(t, ResolveStats{Resolved: 5, Shared: 1, Protected: 1}, stats)
+}
@@ -0,0 +1,326 @@
+package ips
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// ResolveSettings holds the settings of the resolution of hostnames.
+type ResolveSettings struct {
+	// Limiter limits the number of concurrent lookups and the rate at
+	// which they start. It can be shared between concurrent calls to
+	// [Builder.BuildIPsFromHostnames], and must not be nil.
+	Limiter *ResolveLimiter
+	// Timeout is the timeout of each hostname lookup.
+	Timeout time.Duration
+	// Cache is the cache of the IP addresses of hostnames,
+	// and can be nil to resolve all the hostnames.
+	Cache ResolveCache
+	// Protection holds the options protecting shared
+	// IP addresses from being returned.
+	Protection Protection
+}
+
+// ResolveStats holds the statistics of the resolution of hostnames.
+type ResolveStats struct {
+	// Cached is the number of hostnames with fresh
+	// cached IP addresses, which are not resolved.
+	Cached int
+	// Resolved is the number of hostnames resolved successfully.
+	Resolved int
+	// NXDomain is the number of hostnames which do not exist
+	// or have no IP address.
+	NXDomain int
+	// Timeout is the number of lookups which timed out.
+	Timeout int
+	// ServFail is the number of lookups which failed with
+	// a temporary server failure.
+	ServFail int
+	// Other is the number of lookups which failed for another reason.
+	Other int
+	// Kept is the number of hostnames which failed to resolve
+	// but whose cached IP addresses are kept for a grace period.
+	Kept int
+	// Shared is the number of IP addresses dropped because
+	// too many unrelated domains resolve to them.
+	Shared int
+	// Protected is the number of IP addresses dropped
+	// because they are in protected ranges.
+	Protected int
+}
+
+// ResolveLimiter limits the number of concurrent lookups and the
+// number of lookups started per second. It is safe for concurrent use.
+type ResolveLimiter struct {
+	slots    chan struct{}
+	interval time.Duration
+	next     time.Time
+	mu       sync.Mutex
+}
+
+// NewResolveLimiter creates a limiter allowing at most concurrency
+// lookups at the same time, and starting at most qps lookups per
+// second, where 0 disables this rate limit. Concurrency must be at
+// least 1.
+func NewResolveLimiter(concurrency, qps int) *ResolveLimiter {
+	var interval time.Duration
+	if qps > 0 {
+		interval = time.Second / time.Duration(qps)
+	}
+	return &ResolveLimiter{
+		slots:    make(chan struct{}, max(concurrency, 1)),
+		interval: interval,
+	}
+}
+
+// acquire waits for a lookup slot and for the rate limit to allow a new
+// lookup, and returns an error if the context is canceled meanwhile.
+// The slot must be released with [ResolveLimiter.release] on success.
+func (l *ResolveLimiter) acquire(ctx context.Context) (err error) {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case l.slots <- struct{}{}:
+	}
+
+	if l.interval == 0 {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	start := now
+	if l.next.After(now) {
+		start = l.next
+	}
+	l.next = start.Add(l.interval)
+	l.mu.Unlock()
+
+	delay := start.Sub(now)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		l.release()
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (l *ResolveLimiter) release() {
+	<-l.slots
+}
+
+func (s ResolveStats) String() string {
+	return fmt.Sprintf("%d cached, %d resolved, %d NXDOMAIN, %d timeouts, %d SERVFAIL "+
+		"and %d other errors, with %d kept from the cache; "+
+		"%d shared and %d protected IP addresses dropped",
+		s.Cached, s.Resolved, s.NXDomain, s.Timeout, s.ServFail, s.Other, s.Kept,
+		s.Shared, s.Protected)
+}
+
+func (s *ResolveStats) add(err error) {
+	var dnsErr *net.DNSError
+	isDNSErr := errors.As(err, &dnsErr)
+	switch {
+	case err == nil:
+		s.Resolved++
+	case errors.Is(err, context.DeadlineExceeded), isDNSErr && dnsErr.IsTimeout:
+		s.Timeout++
+	case isDNSErr && dnsErr.IsNotFound:
+		s.NXDomain++
+	case isDNSErr && dnsErr.IsTemporary:
+		s.ServFail++
+	default:
+		s.Other++
+	}
+}
+
+// SetLookupIP sets the function used to look up the IP addresses of
+// hostnames, which defaults to the system resolver. It must not be
+// called concurrently with [Builder.BuildIPsFromHostnames].
+func (b *Builder) SetLookupIP(lookupIP LookupIPFunc) {
+	b.lookupIP = lookupIP
+}
+
+// BuildIPsFromHostnames builds a list of IPv4 and IPv6 addresses obtained
+// by resolving some hostnames given, with a pool of workers. Addresses
+// which are not public, such as the 0.0.0.0 or ::1 addresses of sinkholed
+// hostnames, are ignored. Hostnames with fresh IP addresses in the cache
+// of the settings are not resolved, and the cache is updated with the
+// resolution results. Shared IP addresses are then dropped following the
+// protection settings. If the context is canceled, the addresses found
+// so far are returned together with the context error.
+func (b *Builder) BuildIPsFromHostnames(ctx context.Context, hostnames []string,
+	settings ResolveSettings,
+) (ips []string, stats ResolveStats, err error) {
+	b.logger.Infof("finding IP addresses from %d hostnames...", len(hostnames))
+
+	total := len(hostnames)
+	hostnameIPs := make(map[string][]string, total)
+	hostnames = cachedIPs(settings.Cache, hostnames, hostnameIPs)
+	stats.Cached = total - len(hostnames)
+
+	workersCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := b.resolveAll(workersCtx, hostnames, settings)
+
+	const progressSteps = 10
+	progressStep := max(len(hostnames)/progressSteps, 1)
+	done := 0
+	for result := range results {
+		done++
+		if ctx.Err() == nil {
+			stats.add(result.err)
+			result.ips = cacheResult(settings.Cache, result, &stats)
+		}
+		if len(result.ips) > 0 {
+			hostnameIPs[result.hostname] = result.ips
+		}
+		if done%progressStep == 0 && done < len(hostnames) {
+			b.logger.Infof("resolved %d of %d hostnames", done, len(hostnames))
+		}
+	}
+
+	ips = b.protect(hostnameIPs, settings.Protection, &stats)
+	if ctx.Err() != nil {
+		return ips, stats, ctx.Err()
+	}
+	b.logger.Infof("found %d IP addresses from %d hostnames", len(ips), total)
+	return ips, stats, nil
+}
+
+// cachedIPs sets the fresh cached IP addresses of the hostnames given in
+// the hostnameIPs map, and returns the hostnames without fresh cached IP
+// addresses to resolve.
+func cachedIPs(cache ResolveCache, hostnames []string, hostnameIPs map[string][]string,
+) (toResolve []string) {
+	if cache == nil {
+		return hostnames
+	}
+	now := time.Now()
+	toResolve = make([]string, 0, len(hostnames))
+	for _, hostname := range hostnames {
+		cached, fresh := cache.Get(hostname, now)
+		if !fresh {
+			toResolve = append(toResolve, hostname)
+			continue
+		} else if len(cached) > 0 {
+			hostnameIPs[hostname] = cached
+		}
+	}
+	return toResolve
+}
+
+type resolveResult struct {
+	hostname string
+	ips      []string
+	ttl      time.Duration
+	err      error
+}
+
+// resolveAll resolves the hostnames given with a pool of workers, within
+// the limits of the limiter of the settings, and sends the results to the
+// channel returned. The channel is closed once all the hostnames are
+// resolved or once the context is canceled.
+func (b *Builder) resolveAll(ctx context.Context, hostnames []string,
+	settings ResolveSettings,
+) <-chan resolveResult {
+	hostnamesCh := make(chan string)
+	go feedHostnames(ctx, hostnames, hostnamesCh)
+
+	results := make(chan resolveResult)
+	var wg sync.WaitGroup
+	for range min(cap(settings.Limiter.slots), len(hostnames)) {
+		wg.Go(func() {
+			for hostname := range hostnamesCh {
+				if ctx.Err() != nil {
+					continue // drain remaining hostnames
+				}
+				err := settings.Limiter.acquire(ctx)
+				if err != nil {
+					continue
+				}
+				ips, ttl, err := b.resolve(ctx, hostname, settings.Timeout)
+				settings.Limiter.release()
+				results <- resolveResult{hostname: hostname, ips: ips, ttl: ttl, err: err}
+			}
+		})
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	return results
+}
+
+// cacheResult updates the cache with the resolution result given, if the
+// cache is not nil, and returns the IP addresses to use for the hostname,
+// which are the cached IP addresses kept for a grace period if the
+// resolution failed.
+func cacheResult(cache ResolveCache, result resolveResult, stats *ResolveStats) (ips []string) {
+	if cache == nil {
+		return result.ips
+	}
+	now := time.Now()
+	if result.err == nil {
+		cache.Resolved(result.hostname, result.ips, result.ttl, now)
+		return result.ips
+	}
+
+	var dnsErr *net.DNSError
+	notFound := errors.As(result.err, &dnsErr) && dnsErr.IsNotFound
+	ips = cache.Failed(result.hostname, notFound, now)
+	if len(ips) > 0 {
+		stats.Kept++
+	}
+	return ips
+}
+
+// feedHostnames sends the hostnames given to the channel given, until
+// the context is canceled. It closes the channel once done.
+func feedHostnames(ctx context.Context, hostnames []string, ch chan<- string) {
+	defer close(ch)
+	for _, hostname := range hostnames {
+		select {
+		case <-ctx.Done():
+			return
+		case ch <- hostname:
+		}
+	}
+}
+
+// resolve returns the public IP addresses of the hostname given,
+// and the duration during which they can be cached.
+func (b *Builder) resolve(ctx context.Context, hostname string, timeout time.Duration) (
+	ips []string, ttl time.Duration, err error,
+) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	addresses, ttl, err := b.lookupIP(ctx, hostname)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, address := range addresses {
+		address = address.Unmap()
+		if isPrivate(address) || address.IsUnspecified() {
+			continue
+		}
+		ips = append(ips, address.String())
+	}
+	return ips, ttl, nil
+}
+
+func lookupNetIP(ctx context.Context, host string) ([]netip.Addr, time.Duration, error) {
+	addresses, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	return addresses, 0, err
+}
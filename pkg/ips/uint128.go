@@ -0,0 +1,111 @@
+package ips
+
+import (
+	"math/bits"
+	"net/netip"
+)
+
+// uint128 is used to do arithmetic on IPv4 and IPv6 addresses.
+// IPv4 addresses only use the 32 lowest bits of lo.
+type uint128 struct {
+	hi, lo uint64
+}
+
+func uint128FromAddr(addr netip.Addr) uint128 {
+	b := addr.As16()
+	if addr.Is4() {
+		return uint128{lo: uint64(b[12])<<24 | uint64(b[13])<<16 | uint64(b[14])<<8 | uint64(b[15])}
+	}
+	var u uint128
+	for i := range 8 {
+		u.hi = u.hi<<8 | uint64(b[i])
+		u.lo = u.lo<<8 | uint64(b[i+8])
+	}
+	return u
+}
+
+func (u uint128) toAddr(is4 bool) netip.Addr {
+	if is4 {
+		return netip.AddrFrom4([4]byte{byte(u.lo >> 24), byte(u.lo >> 16), byte(u.lo >> 8), byte(u.lo)}) //nolint:gosec
+	}
+	var b [16]byte
+	for i := range 8 {
+		b[7-i] = byte(u.hi >> (8 * i))  //nolint:gosec
+		b[15-i] = byte(u.lo >> (8 * i)) //nolint:gosec
+	}
+	return netip.AddrFrom16(b)
+}
+
+func (u uint128) cmp(other uint128) int {
+	switch {
+	case u.hi < other.hi:
+		return -1
+	case u.hi > other.hi:
+		return 1
+	case u.lo < other.lo:
+		return -1
+	case u.lo > other.lo:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func (u uint128) add(other uint128) (sum uint128, overflow bool) {
+	var carry uint64
+	sum.lo, carry = bits.Add64(u.lo, other.lo, 0)
+	sum.hi, carry = bits.Add64(u.hi, other.hi, carry)
+	return sum, carry != 0
+}
+
+func (u uint128) sub(other uint128) (difference uint128) {
+	var borrow uint64
+	difference.lo, borrow = bits.Sub64(u.lo, other.lo, 0)
+	difference.hi, _ = bits.Sub64(u.hi, other.hi, borrow)
+	return difference
+}
+
+func (u uint128) trailingZeros() int {
+	if u.lo != 0 {
+		return bits.TrailingZeros64(u.lo)
+	}
+	return 64 + bits.TrailingZeros64(u.hi) //nolint:mnd
+}
+
+// pow2 returns 2 to the power of n, for n in [0, 127].
+func pow2(n int) uint128 {
+	if n >= 64 { //nolint:mnd
+		return uint128{hi: 1 << (n - 64)}
+	}
+	return uint128{lo: 1 << n}
+}
+
+// rangeToPrefixes returns the minimal list of prefixes covering
+// exactly the addresses from start to end included. Both addresses
+// must be of the same family, and start must be lower or equal to end.
+func rangeToPrefixes(start, end netip.Addr) (prefixes []netip.Prefix) {
+	is4 := start.Is4()
+	bitLen := start.BitLen()
+	current := uint128FromAddr(start)
+	last := uint128FromAddr(end)
+	for current.cmp(last) <= 0 {
+		hostBits := min(current.trailingZeros(), bitLen)
+		for hostBits > 0 {
+			blockLast, overflow := current.add(pow2(hostBits).sub(uint128{lo: 1}))
+			if !overflow && blockLast.cmp(last) <= 0 {
+				break
+			}
+			hostBits--
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(current.toAddr(is4), bitLen-hostBits))
+		if hostBits == 128 { //nolint:mnd
+			break
+		}
+		var overflow bool
+		current, overflow = current.add(pow2(hostBits))
+		if overflow || (is4 && current.hi == 0 && current.lo > 0xffffffff) {
+			break
+		}
+	}
+	return prefixes
+}
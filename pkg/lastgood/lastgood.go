@@ -0,0 +1,248 @@
+// Package lastgood stores the last known good entries of list sources
+// on disk, to fall back on them when a source fails.
+package lastgood
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Store stores and loads last known good records in a directory.
+type Store struct {
+	dir string
+}
+
+// New creates a new store using the directory given.
+// The directory is created when the first record is saved.
+func New(dir string) *Store {
+	return &Store{
+		dir: dir,
+	}
+}
+
+var ErrRecordNotValid = errors.New("record is not valid")
+
+// Record lines start with one of these prefixes, followed by the entry.
+// The first line of a record is its saved time.
+const (
+	entryPrefix   = "+"
+	allowedPrefix = "!"
+)
+
+// Writer writes a record line by line to a temporary file, which replaces
+// the record once committed. Entries must not contain new line characters.
+type Writer struct {
+	path     string
+	tempPath string
+	file     *os.File
+	writer   *bufio.Writer
+}
+
+// Create creates a writer for the record of the key given,
+// with the current time as saved time. The writer must be either
+// committed with [Writer.Commit] or aborted with [Writer.Abort].
+func (s *Store) Create(key string) (writer *Writer, err error) {
+	const dirPerms = 0o700
+	err = os.MkdirAll(s.dir, dirPerms)
+	if err != nil {
+		return nil, fmt.Errorf("creating directory: %w", err)
+	}
+
+	// The temporary file name is unique so concurrent writers
+	// of the same record do not write to the same file.
+	path := s.path(key)
+	file, err := os.CreateTemp(s.dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("creating record: %w", err)
+	}
+
+	writer = &Writer{
+		path:     path,
+		tempPath: file.Name(),
+		file:     file,
+		writer:   bufio.NewWriter(file),
+	}
+	_, err = writer.writer.WriteString(time.Now().Format(time.RFC3339Nano) + "\n")
+	if err != nil {
+		writer.Abort()
+		return nil, fmt.Errorf("writing record: %w", err)
+	}
+	return writer, nil
+}
+
+// Add adds an entry to the record.
+func (w *Writer) Add(entry string) (err error) {
+	_, err = w.writer.WriteString(entryPrefix + entry + "\n")
+	return err
+}
+
+// Allow adds an allowed entry to the record.
+func (w *Writer) Allow(entry string) (err error) {
+	_, err = w.writer.WriteString(allowedPrefix + entry + "\n")
+	return err
+}
+
+// Commit flushes, syncs and closes the record written, and replaces
+// the previous record with it.
+func (w *Writer) Commit() (err error) {
+	err = w.writer.Flush()
+	if err != nil {
+		w.Abort()
+		return fmt.Errorf("writing record: %w", err)
+	}
+
+	err = w.file.Sync()
+	if err != nil {
+		w.Abort()
+		return fmt.Errorf("syncing record: %w", err)
+	}
+
+	err = w.file.Close()
+	if err != nil {
+		_ = os.Remove(w.tempPath)
+		return fmt.Errorf("closing record: %w", err)
+	}
+
+	err = os.Rename(w.tempPath, w.path)
+	if err != nil {
+		return fmt.Errorf("moving record: %w", err)
+	}
+	return nil
+}
+
+// Abort closes and removes the record written,
+// keeping the previous record if any.
+func (w *Writer) Abort() {
+	_ = w.file.Close()
+	_ = os.Remove(w.tempPath)
+}
+
+// Save saves the entries and allowed entries for the key given,
+// with the current time as saved time.
+func (s *Store) Save(key string, entries, allowed []string) (err error) {
+	writer, err := s.Create(key)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		err = writer.Add(entry)
+		if err != nil {
+			writer.Abort()
+			return fmt.Errorf("writing record: %w", err)
+		}
+	}
+
+	for _, entry := range allowed {
+		err = writer.Allow(entry)
+		if err != nil {
+			writer.Abort()
+			return fmt.Errorf("writing record: %w", err)
+		}
+	}
+
+	return writer.Commit()
+}
+
+// Load reads the record for the key given one line at a time, calling fn
+// for each of its entries, with allowed set to true for allowed entries.
+// It returns the saved time of the record, or an error wrapping
+// [os.ErrNotExist] if no record exists for the key.
+func (s *Store) Load(key string, fn func(entry string, allowed bool) error) (
+	savedAt time.Time, err error,
+) {
+	file, err := os.Open(s.path(key))
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	savedAt, err = readRecord(file, fn)
+	if err != nil {
+		_ = file.Close()
+		return time.Time{}, err
+	}
+
+	err = file.Close()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("closing record: %w", err)
+	}
+	return savedAt, nil
+}
+
+func readRecord(reader io.Reader, fn func(entry string, allowed bool) error) (
+	savedAt time.Time, err error,
+) {
+	scanner := bufio.NewScanner(reader)
+	const maxLineLength = 1024 * 1024
+	scanner.Buffer(nil, maxLineLength)
+	if !scanner.Scan() {
+		err = scanner.Err()
+		if err != nil {
+			return time.Time{}, fmt.Errorf("reading record: %w", err)
+		}
+		return time.Time{}, fmt.Errorf("%w: saved time missing", ErrRecordNotValid)
+	}
+	savedAt, err = time.Parse(time.RFC3339Nano, scanner.Text())
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %w", ErrRecordNotValid, err)
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		var entry string
+		var allowed bool
+		switch {
+		case strings.HasPrefix(line, entryPrefix):
+			entry = strings.TrimPrefix(line, entryPrefix)
+		case strings.HasPrefix(line, allowedPrefix):
+			entry, allowed = strings.TrimPrefix(line, allowedPrefix), true
+		default:
+			return time.Time{}, fmt.Errorf("%w: line %q", ErrRecordNotValid, line)
+		}
+		err = fn(entry, allowed)
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
+	err = scanner.Err()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("reading record: %w", err)
+	}
+	return savedAt, nil
+}
+
+func (s *Store) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:])+".txt")
+}
+
+// Stale describes an optional source which failed to build.
+type Stale struct {
+	// URL is the URL of the source.
+	URL string
+	// Err is the error encountered building the source.
+	Err error
+	// Fallback is true if the last known good entries of the
+	// source were used instead, and false if none were available.
+	Fallback bool
+	// Age is the age of the last known good entries used.
+	Age time.Duration
+}
+
+// String returns a human readable description of the stale source.
+func (s Stale) String() string {
+	if !s.Fallback {
+		return fmt.Sprintf("source %s failed and has no last known good entries: %s",
+			s.URL, s.Err)
+	}
+	return fmt.Sprintf("source %s failed, using its last known good entries from %s ago: %s",
+		s.URL, s.Age.Round(time.Second), s.Err)
+}
@@ -0,0 +1,215 @@
+// Package manifest builds and writes a JSON manifest describing files
+// of a directory, with a version number increasing on every change.
+package manifest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// Manifest describes the files of a directory.
+type Manifest struct {
+	// Version is increased by one every time any file changes.
+	Version uint64 `json:"version"`
+	// UpdatedAt is the time at which the version was last increased.
+	UpdatedAt time.Time `json:"updatedAt"`
+	// Files are the files of the directory, sorted by name.
+	Files []File `json:"files"`
+}
+
+// File describes a single file.
+type File struct {
+	// Name is the filename.
+	Name string `json:"name"`
+	// SHA256 is the hexadecimal SHA-256 checksum of the file.
+	SHA256 string `json:"sha256"`
+	// Size is the file size in bytes.
+	Size int64 `json:"size"`
+	// BuiltAt is the time at which the file content last changed.
+	BuiltAt time.Time `json:"builtAt"`
+	Metadata
+}
+
+// Metadata is the information about a file known
+// by the program writing it.
+type Metadata struct {
+	// Entries is the number of entries of the file, if it is a list.
+	Entries *int `json:"entries,omitempty"`
+	// Sources are the URLs of the sources contributing to the file.
+	Sources []string `json:"sources,omitempty"`
+	// PinnedChecksum is the pinned checksum the file
+	// was verified against, if any.
+	PinnedChecksum *Checksum `json:"pinnedChecksum,omitempty"`
+}
+
+// Checksum is a checksum value with its algorithm.
+type Checksum struct {
+	// Algorithm is the checksum algorithm, such as "md5" or "sha256".
+	Algorithm string `json:"algorithm"`
+	// Value is the hexadecimal checksum value.
+	Value string `json:"value"`
+}
+
+// Read reads the manifest at the path given, and returns an
+// empty manifest if the file does not exist.
+func Read(path string) (manifest Manifest, err error) {
+	data, err := os.ReadFile(path) //nolint:gosec
+	if errors.Is(err, fs.ErrNotExist) {
+		return Manifest{}, nil
+	} else if err != nil {
+		return Manifest{}, err
+	}
+
+	err = json.Unmarshal(data, &manifest)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("decoding manifest: %w", err)
+	}
+	return manifest, nil
+}
+
+// Write writes the manifest to the path given, atomically.
+func (m Manifest) Write(path string) (err error) {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding manifest: %w", err)
+	}
+	data = append(data, '\n')
+
+	tempPath := path + ".tmp"
+	const perms = 0o600
+	err = os.WriteFile(tempPath, data, perms)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tempPath, path)
+}
+
+// Build builds the manifest of the files with the names given found in the
+// directories given, where a file of a directory replaces the file with the
+// same name of the directories before it, for example to describe staged
+// files before they replace their published files. Names not found in any
+// of the directories are ignored, so other files of the directories, such
+// as the files of a repository, are never described. Metadata maps
+// filenames to their metadata for the files written since the previous
+// manifest. Files without metadata and with an unchanged checksum keep
+// their previous metadata. The version is increased compared to the
+// previous manifest only if any file changed, was added or was removed,
+// in which case changed is true.
+func Build(dirs, names []string, previous Manifest, metadata map[string]Metadata,
+	now time.Time,
+) (manifest Manifest, changed bool, err error) {
+	paths, err := findFiles(dirs, names)
+	if err != nil {
+		return Manifest{}, false, err
+	}
+	names = slices.Sorted(maps.Keys(paths))
+
+	previousFiles := make(map[string]File, len(previous.Files))
+	for _, file := range previous.Files {
+		previousFiles[file.Name] = file
+	}
+
+	for _, name := range names {
+		file, err := describe(paths[name])
+		if err != nil {
+			return Manifest{}, false, fmt.Errorf("describing %s: %w", name, err)
+		}
+
+		previousFile, existed := previousFiles[name]
+		fileMetadata, written := metadata[name]
+		switch {
+		case existed && previousFile.SHA256 == file.SHA256:
+			file.BuiltAt = previousFile.BuiltAt
+			file.Metadata = previousFile.Metadata
+			if written {
+				file.Metadata = fileMetadata
+			}
+		default:
+			file.BuiltAt = now
+			file.Metadata = fileMetadata
+			changed = true
+		}
+		if !changed && !metadataEqual(file.Metadata, previousFile.Metadata) {
+			changed = true
+		}
+		manifest.Files = append(manifest.Files, file)
+	}
+
+	if len(manifest.Files) != len(previous.Files) {
+		changed = true
+	}
+
+	manifest.Version = previous.Version
+	manifest.UpdatedAt = previous.UpdatedAt
+	if changed {
+		manifest.Version++
+		manifest.UpdatedAt = now
+	}
+	return manifest, changed, nil
+}
+
+// findFiles maps each of the names given to the path of its regular file
+// in the last of the directories containing it, if any.
+func findFiles(dirs, names []string) (paths map[string]string, err error) {
+	paths = make(map[string]string, len(names))
+	for _, name := range names {
+		for _, dir := range slices.Backward(dirs) {
+			path := filepath.Join(dir, name)
+			info, err := os.Stat(path)
+			switch {
+			case errors.Is(err, fs.ErrNotExist):
+				continue
+			case err != nil:
+				return nil, err
+			case !info.Mode().IsRegular():
+				continue
+			}
+			paths[name] = path
+			break
+		}
+	}
+	return paths, nil
+}
+
+func describe(path string) (file File, err error) {
+	f, err := os.Open(path) //nolint:gosec
+	if err != nil {
+		return File{}, err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return File{}, err
+	}
+
+	return File{
+		Name:   filepath.Base(path),
+		SHA256: hex.EncodeToString(hash.Sum(nil)),
+		Size:   size,
+	}, nil
+}
+
+func metadataEqual(a, b Metadata) bool {
+	switch {
+	case (a.Entries == nil) != (b.Entries == nil),
+		a.Entries != nil && *a.Entries != *b.Entries,
+		!slices.Equal(a.Sources, b.Sources),
+		(a.PinnedChecksum == nil) != (b.PinnedChecksum == nil),
+		a.PinnedChecksum != nil && *a.PinnedChecksum != *b.PinnedChecksum:
+		return false
+	default:
+		return true
+	}
+}
@@ -0,0 +1,76 @@
+package manifest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Build(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	write := func(name, content string) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600))
+	}
+	write("a.updated", "a.com\nb.com")
+	write("named.root", "root")
+	write("README.md", "x")
+	write("manifest.json", "{}")
+
+	entries := 2
+	metadata := map[string]Metadata{
+		"a.updated": {Entries: &entries, Sources: []string{"https://x.com/a"}},
+	}
+	first := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	names := []string{"a.updated", "named.root", "missing.updated"}
+
+	manifest, changed, err := Build([]string{dir}, names, Manifest{}, metadata, first)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	expected := Manifest{
+		Version:   1,
+		UpdatedAt: first,
+		Files: []File{{
+			Name:     "a.updated",
+			SHA256:   "643baa4dba41515c8872a78134cccf32c1a0b654e59d3c4da542c4c5d9d48449",
+			Size:     11,
+			BuiltAt:  first,
+			Metadata: metadata["a.updated"],
+		}, {
+			Name:    "named.root",
+			SHA256:  "4813494d137e1631bba301d5acab6e7bb7aa74ce1185d456565ef51d737677b2",
+			Size:    4,
+			BuiltAt: first,
+		}},
+	}
+	assert.Equal(t, expected, manifest)
+
+	second := first.Add(time.Hour)
+	unchanged, changed, err := Build([]string{dir}, names, manifest, nil, second)
+	require.NoError(t, err)
+	assert.False(t, changed)
+	assert.Equal(t, manifest, unchanged)
+
+	write("named.root", "root2")
+	updated, changed, err := Build([]string{dir}, names, manifest, nil, second)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, uint64(2), updated.Version)
+	assert.Equal(t, second, updated.UpdatedAt)
+	assert.Equal(t, first, updated.Files[0].BuiltAt)
+	assert.Equal(t, metadata["a.updated"], updated.Files[0].Metadata)
+	assert.Equal(t, second, updated.Files[1].BuiltAt)
+
+	staged := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(staged, "named.root"), []byte("root"), 0o600))
+	overlaid, changed, err := Build([]string{dir, staged}, names, updated, nil, second)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	require.Len(t, overlaid.Files, 2)
+	assert.Equal(t, manifest.Files[1].SHA256, overlaid.Files[1].SHA256)
+}
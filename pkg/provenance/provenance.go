@@ -0,0 +1,202 @@
+// Package provenance records which sources produced each list entry,
+// and writes this information to sidecar files next to the lists.
+package provenance
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Mode is the level of detail of the provenance recorded.
+type Mode uint8
+
+const (
+	// ModeOff disables provenance tracking.
+	ModeOff Mode = iota
+	// ModeSources records the sources producing each entry.
+	ModeSources
+	// ModeLines records the sources and the raw lines producing each entry.
+	ModeLines
+)
+
+var ErrModeNotValid = errors.New("provenance mode is not valid")
+
+// ParseMode parses a mode from one of "off", "sources" or "lines".
+func ParseMode(s string) (mode Mode, err error) {
+	switch strings.ToLower(s) {
+	case "off":
+		return ModeOff, nil
+	case "sources":
+		return ModeSources, nil
+	case "lines":
+		return ModeLines, nil
+	default:
+		return 0, fmt.Errorf("%w: %q must be one of off, sources or lines",
+			ErrModeNotValid, s)
+	}
+}
+
+// String returns the string representation of the mode.
+func (m Mode) String() string {
+	switch m {
+	case ModeOff:
+		return "off"
+	case ModeSources:
+		return "sources"
+	case ModeLines:
+		return "lines"
+	default:
+		return "unknown"
+	}
+}
+
+// Entry is the provenance of a list entry.
+type Entry struct {
+	// Sources are the indices of the sources producing the entry,
+	// in ascending order.
+	Sources []int `json:"sources"`
+	// Lines are the raw lines producing the entry, if recorded.
+	Lines []string `json:"lines,omitempty"`
+}
+
+// Add adds the source index and line given to the entry, ignoring
+// duplicates. The line is ignored if it is empty.
+func (e *Entry) Add(sourceIndex int, line string) {
+	index, found := slices.BinarySearch(e.Sources, sourceIndex)
+	if !found {
+		e.Sources = slices.Insert(e.Sources, index, sourceIndex)
+	}
+	if line != "" && !slices.Contains(e.Lines, line) {
+		e.Lines = append(e.Lines, line)
+	}
+}
+
+// Merge adds the sources and lines of the other entry given
+// to the entry, ignoring duplicates.
+func (e *Entry) Merge(other Entry) {
+	for _, sourceIndex := range other.Sources {
+		e.Add(sourceIndex, "")
+	}
+	for _, line := range other.Lines {
+		if !slices.Contains(e.Lines, line) {
+			e.Lines = append(e.Lines, line)
+		}
+	}
+}
+
+// recordSeparator separates the fields of a record. It sorts before any
+// printable character, so records sort in the order of their values.
+const recordSeparator = "\x00"
+
+// EncodeRecord encodes a value with its source index and raw line into a
+// single string sorting in the order of the value, to sort values together
+// with their provenance.
+func EncodeRecord(value string, sourceIndex int, line string) string {
+	return value + recordSeparator + strconv.Itoa(sourceIndex) + recordSeparator + line
+}
+
+var ErrRecordMalformed = errors.New("provenance record is malformed")
+
+// DecodeRecord decodes a record encoded with [EncodeRecord].
+func DecodeRecord(record string) (value string, sourceIndex int, line string, err error) {
+	value, rest, found := strings.Cut(record, recordSeparator)
+	if !found {
+		return "", 0, "", fmt.Errorf("%w: %q", ErrRecordMalformed, record)
+	}
+	indexString, line, found := strings.Cut(rest, recordSeparator)
+	if !found {
+		return "", 0, "", fmt.Errorf("%w: %q", ErrRecordMalformed, record)
+	}
+	sourceIndex, err = strconv.Atoi(indexString)
+	if err != nil {
+		return "", 0, "", fmt.Errorf("%w: %q: %w", ErrRecordMalformed, record, err)
+	}
+	return value, sourceIndex, line, nil
+}
+
+// SidecarPath returns the path of the provenance sidecar file for the list
+// file path given, replacing its extension, for example "a.sources.json"
+// for "a.updated".
+func SidecarPath(listPath string) string {
+	return strings.TrimSuffix(listPath, filepath.Ext(listPath)) + ".sources.json"
+}
+
+// WriteSidecar writes the provenance of the entries given to the file path
+// given, in the order of the entries, as a JSON object with the list of
+// source names and an object mapping each entry to its provenance.
+// Entries without provenance are written with an empty list of sources.
+func WriteSidecar(path string, sources []string, entries []string,
+	provenance map[string]Entry,
+) (err error) {
+	const perms = 0o600
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perms) //nolint:gosec
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+	err = writeSidecar(writer, sources, entries, provenance)
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	err = writer.Flush()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+func writeSidecar(writer *bufio.Writer, sources []string, entries []string,
+	provenance map[string]Entry,
+) (err error) {
+	if sources == nil {
+		sources = []string{}
+	}
+	sourcesJSON, err := json.Marshal(sources)
+	if err != nil {
+		return fmt.Errorf("encoding sources: %w", err)
+	}
+	_, _ = writer.WriteString(`{"sources":`)
+	_, _ = writer.Write(sourcesJSON)
+	_, _ = writer.WriteString(`,"entries":{`)
+
+	for i, value := range entries {
+		if i > 0 {
+			_ = writer.WriteByte(',')
+		}
+		_ = writer.WriteByte('\n')
+
+		key, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Errorf("encoding entry: %w", err)
+		}
+		entry := provenance[value]
+		if entry.Sources == nil {
+			entry.Sources = []int{}
+		}
+		entryJSON, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("encoding provenance of %s: %w", value, err)
+		}
+		_, _ = writer.Write(key)
+		_ = writer.WriteByte(':')
+		_, err = writer.Write(entryJSON)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = writer.WriteString("\n}}\n")
+	return err
+}
@@ -0,0 +1,63 @@
+package provenance
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Record(t *testing.T) {
+	t.Parallel()
+
+	record := EncodeRecord("a.com", 12, "0.0.0.0 a.com # x")
+	value, sourceIndex, line, err := DecodeRecord(record)
+	require.NoError(t, err)
+	assert.Equal(t, "a.com", value)
+	assert.Equal(t, 12, sourceIndex)
+	assert.Equal(t, "0.0.0.0 a.com # x", line)
+
+	// Records sort in the order of their values.
+	assert.Less(t, EncodeRecord("a.com", 9, ""), EncodeRecord("a.com.b", 0, ""))
+
+	_, _, _, err = DecodeRecord("a.com")
+	require.ErrorIs(t, err, ErrRecordMalformed)
+}
+
+func Test_Entry_Add(t *testing.T) {
+	t.Parallel()
+
+	var entry Entry
+	entry.Add(2, "b")
+	entry.Add(0, "a")
+	entry.Add(2, "b")
+	entry.Add(1, "")
+	assert.Equal(t, Entry{Sources: []int{0, 1, 2}, Lines: []string{"b", "a"}}, entry)
+}
+
+func Test_WriteSidecar(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), SidecarPath("list.updated"))
+	assert.Equal(t, "list.sources.json", filepath.Base(path))
+
+	sources := []string{"https://a", "file:///b"}
+	entries := []string{"a.com", "b.com"}
+	provenances := map[string]Entry{
+		"a.com": {Sources: []int{0, 1}, Lines: []string{"0.0.0.0 a.com"}},
+	}
+	err := WriteSidecar(path, sources, entries, provenances)
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	const expected = `{"sources":["https://a","file:///b"],"entries":{
+"a.com":{"sources":[0,1],"lines":["0.0.0.0 a.com"]},
+"b.com":{"sources":[]}
+}}
+`
+	assert.Equal(t, expected, string(data))
+	assert.JSONEq(t, expected, string(data))
+}
@@ -0,0 +1,165 @@
+// Package resolvecache caches the IP addresses of hostnames in a state
+// file across runs, so hostnames are only resolved again once expired.
+package resolvecache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Cache caches the IP addresses of hostnames. It is safe for concurrent use.
+type Cache struct {
+	path         string
+	minFreshness time.Duration
+	gracePeriod  time.Duration
+	entries      map[string]Entry
+	changed      bool
+	mu           sync.Mutex
+}
+
+// Entry is the cached resolution of a hostname.
+type Entry struct {
+	// IPs are the IP addresses of the hostname, which can be
+	// empty if the hostname does not exist.
+	IPs []string `json:"ips,omitempty"`
+	// ExpiresAt is the time after which the hostname
+	// has to be resolved again.
+	ExpiresAt time.Time `json:"expiresAt"`
+	// LastSeen is the last time the hostname resolved
+	// successfully, and is zero if it never did.
+	LastSeen time.Time `json:"lastSeen,omitzero"`
+}
+
+// New creates a cache stored in the state file at the path given, loading
+// the entries of the file if it exists. Resolved IP addresses are fresh for
+// their TTL, and at least for the minimum freshness given. The grace period
+// is the duration during which the last IP addresses of a hostname are kept
+// after it stops resolving, and 0 disables keeping them.
+func New(path string, minFreshness, gracePeriod time.Duration) (cache *Cache, err error) {
+	cache = &Cache{
+		path:         path,
+		minFreshness: minFreshness,
+		gracePeriod:  gracePeriod,
+		entries:      make(map[string]Entry),
+	}
+
+	data, err := os.ReadFile(path) //nolint:gosec
+	if errors.Is(err, fs.ErrNotExist) {
+		return cache, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &cache.entries)
+	if err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", path, err)
+	}
+	return cache, nil
+}
+
+// Get returns the cached IP addresses of the hostname and true if
+// they are still fresh at the time given.
+func (c *Cache) Get(hostname string, now time.Time) (ips []string, fresh bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[hostname]
+	if !ok || !now.Before(entry.ExpiresAt) {
+		return nil, false
+	}
+	return entry.IPs, true
+}
+
+// Resolved caches the IP addresses resolved for the hostname at the time
+// given, fresh for the TTL given or for the minimum freshness if longer.
+func (c *Cache) Resolved(hostname string, ips []string, ttl time.Duration, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[hostname] = Entry{
+		IPs:       ips,
+		ExpiresAt: now.Add(max(ttl, c.minFreshness)),
+		LastSeen:  now,
+	}
+	c.changed = true
+}
+
+// Failed records that the resolution of the hostname failed at the time
+// given, and returns the last IP addresses of the hostname if it last
+// resolved within the grace period. If notFound is true, the hostname
+// does not exist and this result is cached for the minimum freshness.
+// Otherwise the hostname is resolved again on the next lookup.
+func (c *Cache) Failed(hostname string, notFound bool, now time.Time) (ips []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[hostname]
+	if ok && !entry.LastSeen.IsZero() && now.Sub(entry.LastSeen) <= c.gracePeriod {
+		ips = entry.IPs
+	}
+
+	switch {
+	case notFound:
+		c.entries[hostname] = Entry{
+			IPs:       ips,
+			ExpiresAt: now.Add(c.minFreshness),
+			LastSeen:  entry.LastSeen,
+		}
+		c.changed = true
+	case ok && ips == nil:
+		delete(c.entries, hostname)
+		c.changed = true
+	}
+	return ips
+}
+
+// Save removes the entries expired for longer than the grace period at
+// the time given, and writes the cache state file if the cache changed.
+func (c *Cache) Save(now time.Time) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for hostname, entry := range c.entries {
+		if now.After(entry.ExpiresAt.Add(c.gracePeriod)) {
+			delete(c.entries, hostname)
+			c.changed = true
+		}
+	}
+	if !c.changed {
+		return nil
+	}
+
+	data, err := json.Marshal(c.entries)
+	if err != nil {
+		return fmt.Errorf("encoding entries: %w", err)
+	}
+
+	const dirPerms = 0o700
+	err = os.MkdirAll(filepath.Dir(c.path), dirPerms)
+	if err != nil {
+		return fmt.Errorf("creating directory: %w", err)
+	}
+
+	tempPath := c.path + ".tmp"
+	const perms = 0o600
+	err = os.WriteFile(tempPath, data, perms)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tempPath, c.path)
+	if err != nil {
+		return err
+	}
+	c.changed = false
+	return nil
+}
+
+// Len returns the number of hostnames cached.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
@@ -0,0 +1,61 @@
+package resolvecache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Cache(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "state", "resolve.json")
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache, err := New(path, time.Hour, 2*time.Hour)
+	require.NoError(t, err)
+
+	cache.Resolved("long-ttl.com", []string{"1.2.3.4"}, 3*time.Hour, now)
+	cache.Resolved("short-ttl.com", []string{"5.6.7.8"}, time.Minute, now)
+	ips := cache.Failed("missing.com", true, now)
+	assert.Empty(t, ips)
+	ips = cache.Failed("timeout.com", false, now)
+	assert.Empty(t, ips)
+
+	err = cache.Save(now)
+	require.NoError(t, err)
+	cache, err = New(path, time.Hour, 2*time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 3, cache.Len())
+
+	later := now.Add(90 * time.Minute)
+	ips, fresh := cache.Get("long-ttl.com", later)
+	assert.True(t, fresh)
+	assert.Equal(t, []string{"1.2.3.4"}, ips)
+	_, fresh = cache.Get("short-ttl.com", later)
+	assert.False(t, fresh)
+	_, fresh = cache.Get("missing.com", later)
+	assert.False(t, fresh)
+
+	// Stopped resolving within the grace period.
+	ips = cache.Failed("short-ttl.com", false, later)
+	assert.Equal(t, []string{"5.6.7.8"}, ips)
+	// Stopped resolving after the grace period.
+	ips = cache.Failed("short-ttl.com", true, now.Add(3*time.Hour))
+	assert.Empty(t, ips)
+
+	// Entries expired for longer than the grace period are removed.
+	err = cache.Save(now.Add(5 * time.Hour))
+	require.NoError(t, err)
+	cache, err = New(path, time.Hour, 2*time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 2, cache.Len())
+
+	err = os.WriteFile(path, []byte("invalid"), 0o600)
+	require.NoError(t, err)
+	_, err = New(path, time.Hour, 2*time.Hour)
+	assert.Error(t, err)
+}
@@ -0,0 +1,102 @@
+package resolver
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// maxIdleConns is the maximum number of idle connections
+// kept open for each DNS over TLS upstream server.
+const maxIdleConns = 16
+
+// connPool keeps connections to a DNS over TLS upstream server open
+// to reuse them across queries, avoiding a TCP and TLS handshake
+// for each query. Each connection is used by one query at a time.
+type connPool struct {
+	client  *dns.Client
+	address string
+	mutex   sync.Mutex
+	idle    []*dns.Conn
+}
+
+func newConnPool(upstream Upstream, tlsConfig *tls.Config) *connPool {
+	tlsConfig = tlsConfig.Clone()
+	tlsConfig.ServerName = upstream.ServerName
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName, _, _ = net.SplitHostPort(upstream.Address)
+	}
+	return &connPool{
+		client:  &dns.Client{Net: "tcp-tls", TLSConfig: tlsConfig},
+		address: upstream.Address,
+	}
+}
+
+// exchange sends the query on an idle connection, or on a new connection
+// if there is none, and keeps the connection open once it is answered.
+// If a reused connection fails, for example because the server closed
+// it, the query is retried once on a new connection.
+func (p *connPool) exchange(ctx context.Context, query *dns.Msg) (response *dns.Msg, err error) {
+	conn := p.take()
+	if conn != nil {
+		response, _, err = p.client.ExchangeWithConnContext(ctx, query, conn)
+		if err == nil {
+			p.put(conn)
+			return response, nil
+		}
+		_ = conn.Close()
+		if ctx.Err() != nil {
+			return nil, err
+		}
+	}
+
+	conn, err = p.client.DialContext(ctx, p.address)
+	if err != nil {
+		return nil, err
+	}
+	response, _, err = p.client.ExchangeWithConnContext(ctx, query, conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	p.put(conn)
+	return response, nil
+}
+
+// take removes and returns the most recently used idle connection,
+// or returns nil if there is no idle connection.
+func (p *connPool) take() *dns.Conn {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if len(p.idle) == 0 {
+		return nil
+	}
+	conn := p.idle[len(p.idle)-1]
+	p.idle = p.idle[:len(p.idle)-1]
+	return conn
+}
+
+// put keeps the connection open to be reused, or closes it
+// if there are already enough idle connections.
+func (p *connPool) put(conn *dns.Conn) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if len(p.idle) >= maxIdleConns {
+		_ = conn.Close()
+		return
+	}
+	p.idle = append(p.idle, conn)
+}
+
+// close closes all the idle connections.
+func (p *connPool) close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, conn := range p.idle {
+		_ = conn.Close()
+	}
+	p.idle = nil
+}
@@ -0,0 +1,266 @@
+// Package resolver resolves hostnames to IP addresses by querying
+// plain DNS, DNS over TLS or DNS over HTTPS upstream servers.
+package resolver
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Resolver resolves hostnames using upstream servers.
+type Resolver struct {
+	upstreams  []Upstream
+	queryTypes []uint16
+	tlsConfig  *tls.Config
+	httpClient *http.Client
+	// tlsPools maps each DNS over TLS upstream server,
+	// as a string, to its connection pool.
+	tlsPools map[string]*connPool
+}
+
+// New creates a resolver querying the upstream servers given in order,
+// moving on to the next server if a server fails to answer. The query
+// types are the record types queried for each hostname, and can contain
+// [dns.TypeA] and [dns.TypeAAAA]. The TLS configuration is used for DNS
+// over TLS and DNS over HTTPS servers, and can be nil to use the system
+// root certificates.
+func New(upstreams []Upstream, queryTypes []uint16, tlsConfig *tls.Config) *Resolver {
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	tlsPools := make(map[string]*connPool)
+	for _, upstream := range upstreams {
+		if upstream.Protocol == ProtocolTLS {
+			tlsPools[upstream.String()] = newConnPool(upstream, tlsConfig)
+		}
+	}
+	return &Resolver{
+		upstreams:  upstreams,
+		queryTypes: queryTypes,
+		tlsConfig:  tlsConfig,
+		httpClient: newHTTPClient(tlsConfig),
+		tlsPools:   tlsPools,
+	}
+}
+
+// Close closes the idle connections kept open
+// to the upstream servers.
+func (r *Resolver) Close() {
+	for _, pool := range r.tlsPools {
+		pool.close()
+	}
+	r.httpClient.CloseIdleConnections()
+}
+
+// SystemLookup returns a lookup function using the system resolver,
+// looking up only the address families of the query types given.
+// The system resolver does not report TTLs, so the TTL returned is 0.
+func SystemLookup(queryTypes []uint16) func(ctx context.Context, host string) (
+	[]netip.Addr, time.Duration, error,
+) {
+	network := "ip"
+	switch {
+	case !slices.Contains(queryTypes, dns.TypeAAAA):
+		network = "ip4"
+	case !slices.Contains(queryTypes, dns.TypeA):
+		network = "ip6"
+	}
+	return func(ctx context.Context, host string) ([]netip.Addr, time.Duration, error) {
+		addresses, err := net.DefaultResolver.LookupNetIP(ctx, network, host)
+		return addresses, 0, err
+	}
+}
+
+// LookupNetIP returns the IP addresses of the host given, querying each
+// record type separately and following CNAME chains. The TTL returned is
+// the lowest TTL of the records followed to find the addresses, and is 0
+// if no address is found. Errors are returned
+// as [*net.DNSError], with IsNotFound set if the host does not exist or
+// has no address, IsTimeout set if a query timed out and IsTemporary set
+// if the upstream servers failed with a server failure.
+func (r *Resolver) LookupNetIP(ctx context.Context, host string) (
+	addresses []netip.Addr, ttl time.Duration, err error,
+) {
+	type result struct {
+		addresses []netip.Addr
+		ttl       time.Duration
+		err       error
+	}
+	results := make([]result, len(r.queryTypes))
+	var wg sync.WaitGroup
+	for i, queryType := range r.queryTypes {
+		wg.Go(func() {
+			results[i].addresses, results[i].ttl, results[i].err = r.lookup(ctx, host, queryType)
+		})
+	}
+	wg.Wait()
+
+	for _, result := range results {
+		if len(result.addresses) > 0 {
+			addresses = append(addresses, result.addresses...)
+			ttl = minTTL(ttl, result.ttl)
+		}
+		if err == nil {
+			err = result.err
+		}
+	}
+	switch {
+	case len(addresses) > 0:
+		return addresses, ttl, nil
+	case err != nil:
+		return nil, 0, err
+	default:
+		return nil, 0, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+}
+
+// maxCNAMEQueries is the maximum number of queries sent to follow
+// a CNAME chain not fully answered by the upstream server.
+const maxCNAMEQueries = 8
+
+// lookup returns the addresses of the host for the query type given
+// with their TTL, and no error if the host does not exist or has no
+// such address.
+func (r *Resolver) lookup(ctx context.Context, host string, queryType uint16) (
+	addresses []netip.Addr, ttl time.Duration, err error,
+) {
+	name := dns.Fqdn(host)
+	for range maxCNAMEQueries {
+		query := new(dns.Msg)
+		query.SetQuestion(name, queryType)
+		response, err := r.query(ctx, host, query)
+		if err != nil {
+			return nil, 0, err
+		} else if response.Rcode == dns.RcodeNameError {
+			return nil, 0, nil
+		}
+
+		addresses, chainTTL, target := followChain(response.Answer, name, queryType)
+		ttl = minTTL(ttl, chainTTL)
+		if target == "" {
+			return addresses, ttl, nil
+		}
+		name = target
+	}
+	return nil, 0, &net.DNSError{Err: "CNAME chain is too long", Name: host}
+}
+
+// query sends the query to each upstream server in order until one
+// answers with a success or non existent domain response code.
+func (r *Resolver) query(ctx context.Context, host string, query *dns.Msg) (
+	response *dns.Msg, err error,
+) {
+	for _, upstream := range r.upstreams {
+		response, err = r.exchange(ctx, upstream, query)
+		switch {
+		case err != nil:
+			err = exchangeError(ctx, host, upstream, err)
+		case response.Rcode == dns.RcodeSuccess, response.Rcode == dns.RcodeNameError:
+			return response, nil
+		default:
+			err = &net.DNSError{
+				Err:         "server responded with " + dns.RcodeToString[response.Rcode],
+				Name:        host,
+				Server:      upstream.String(),
+				IsTemporary: response.Rcode == dns.RcodeServerFailure,
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, err
+}
+
+func exchangeError(ctx context.Context, host string, upstream Upstream, err error) *net.DNSError {
+	var netErr net.Error
+	isTimeout := errors.Is(err, context.DeadlineExceeded) ||
+		(errors.As(err, &netErr) && netErr.Timeout()) ||
+		errors.Is(ctx.Err(), context.DeadlineExceeded)
+	return &net.DNSError{
+		UnwrapErr: err,
+		Err:       err.Error(),
+		Name:      host,
+		Server:    upstream.String(),
+		IsTimeout: isTimeout,
+	}
+}
+
+// followChain returns the addresses of the query type for the name given
+// found in the answer records, following CNAME records, and the lowest TTL
+// of the records followed. If the chain ends with a CNAME target without
+// records in the answer, the target is returned to be queried.
+func followChain(answer []dns.RR, name string, queryType uint16) (
+	addresses []netip.Addr, ttl time.Duration, target string,
+) {
+	queried := name
+	// Each iteration follows one CNAME record, so there are at most
+	// as many iterations as answer records, plus one.
+	for range len(answer) + 1 {
+		cname := ""
+		for _, record := range answer {
+			header := record.Header()
+			if !strings.EqualFold(header.Name, name) {
+				continue
+			}
+			recordTTL := time.Duration(header.Ttl) * time.Second
+			switch record := record.(type) {
+			case *dns.A:
+				if queryType == dns.TypeA {
+					addresses = appendAddress(addresses, record.A)
+					ttl = minTTL(ttl, recordTTL)
+				}
+			case *dns.AAAA:
+				if queryType == dns.TypeAAAA {
+					addresses = appendAddress(addresses, record.AAAA)
+					ttl = minTTL(ttl, recordTTL)
+				}
+			case *dns.CNAME:
+				cname = record.Target
+				ttl = minTTL(ttl, recordTTL)
+			}
+		}
+
+		switch {
+		case len(addresses) > 0:
+			return addresses, ttl, ""
+		case cname != "":
+			name = cname
+		case name == queried:
+			return nil, 0, ""
+		default:
+			return nil, ttl, name
+		}
+	}
+	return nil, ttl, name
+}
+
+func appendAddress(addresses []netip.Addr, ip net.IP) []netip.Addr {
+	address, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return addresses
+	}
+	return append(addresses, address.Unmap())
+}
+
+// minTTL returns the lowest of the TTLs given, where 0 is an unset TTL.
+func minTTL(a, b time.Duration) time.Duration {
+	switch {
+	case a == 0:
+		return b
+	case b == 0:
+		return a
+	default:
+		return min(a, b)
+	}
+}
@@ -0,0 +1,287 @@
+package resolver
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ParseUpstream(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		s          string
+		upstream   Upstream
+		errWrapped error
+	}{
+		"ipv4": {
+			s:        "1.1.1.1",
+			upstream: Upstream{Protocol: ProtocolDNS, Address: "1.1.1.1:53"},
+		},
+		"ipv6": {
+			s:        "2606:4700:4700::1111",
+			upstream: Upstream{Protocol: ProtocolDNS, Address: "[2606:4700:4700::1111]:53"},
+		},
+		"dns_with_port": {
+			s:        "dns://[::1]:5353",
+			upstream: Upstream{Protocol: ProtocolDNS, Address: "[::1]:5353"},
+		},
+		"tls": {
+			s:        "tls://dns.quad9.net",
+			upstream: Upstream{Protocol: ProtocolTLS, Address: "dns.quad9.net:853"},
+		},
+		"tls_with_server_name": {
+			s: "tls://one.one.one.one@1.1.1.1",
+			upstream: Upstream{
+				Protocol: ProtocolTLS, Address: "1.1.1.1:853", ServerName: "one.one.one.one",
+			},
+		},
+		"dns_with_server_name": {
+			s:          "dns://one.one.one.one@1.1.1.1",
+			errWrapped: ErrServerNameUnsupported,
+		},
+		"tls_with_empty_server_name": {
+			s:          "tls://@1.1.1.1",
+			errWrapped: ErrServerNameEmpty,
+		},
+		"https": {
+			s:        "https://cloudflare-dns.com/dns-query",
+			upstream: Upstream{Protocol: ProtocolHTTPS, Address: "https://cloudflare-dns.com/dns-query"},
+		},
+		"unknown_scheme": {
+			s:          "quic://1.1.1.1",
+			errWrapped: ErrUpstreamSchemeUnknown,
+		},
+		"https_without_host": {
+			s:          "https:///dns-query",
+			errWrapped: ErrUpstreamHostMissing,
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			upstream, err := ParseUpstream(testCase.s)
+			require.ErrorIs(t, err, testCase.errWrapped)
+			assert.Equal(t, testCase.upstream, upstream)
+		})
+	}
+}
+
+// testZoneHandler answers queries as a recursive server would for a test zone.
+func testZoneHandler(writer dns.ResponseWriter, query *dns.Msg) {
+	response := new(dns.Msg)
+	response.SetReply(query)
+	question := query.Question[0]
+	record := func(s string) dns.RR {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			panic(err)
+		}
+		return rr
+	}
+	direct := map[uint16]string{
+		dns.TypeA:    "direct.example. 300 IN A 1.2.3.4",
+		dns.TypeAAAA: "direct.example. 300 IN AAAA 2001:db8::1",
+	}
+
+	switch question.Name {
+	case "direct.example.":
+		response.Answer = []dns.RR{record(direct[question.Qtype])}
+	case "chain.example.":
+		// Full chain answered by the server.
+		response.Answer = []dns.RR{
+			record("chain.example. 60 IN CNAME middle.example."),
+			record("middle.example. 300 IN CNAME direct.example."),
+			record(direct[question.Qtype]),
+		}
+	case "partial.example.":
+		// Chain target to query separately.
+		response.Answer = []dns.RR{record("partial.example. 300 IN CNAME direct.example.")}
+	case "failing.example.":
+		response.Rcode = dns.RcodeServerFailure
+	default:
+		response.Rcode = dns.RcodeNameError
+	}
+	_ = writer.WriteMsg(response)
+}
+
+func Test_Resolver_LookupNetIP(t *testing.T) {
+	t.Parallel()
+
+	packetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	plainServer := &dns.Server{PacketConn: packetConn, Handler: dns.HandlerFunc(testZoneHandler)}
+	go func() { _ = plainServer.ActivateAndServe() }()
+	t.Cleanup(func() { _ = plainServer.Shutdown() })
+
+	httpsServer := httptest.NewTLSServer(http.HandlerFunc(
+		func(writer http.ResponseWriter, request *http.Request) {
+			data, err := io.ReadAll(request.Body)
+			query := new(dns.Msg)
+			if err == nil {
+				err = query.Unpack(data)
+			}
+			if err != nil {
+				http.Error(writer, err.Error(), http.StatusBadRequest)
+				return
+			}
+			responseWriter := &httpResponseWriter{writer: writer}
+			testZoneHandler(responseWriter, query)
+		}))
+	t.Cleanup(httpsServer.Close)
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", httpsServer.TLS)
+	require.NoError(t, err)
+	tlsServer := &dns.Server{Listener: listener, Net: "tcp-tls", Handler: dns.HandlerFunc(testZoneHandler)}
+	go func() { _ = tlsServer.ActivateAndServe() }()
+	t.Cleanup(func() { _ = tlsServer.Shutdown() })
+
+	tlsConfig := httpsServer.Client().Transport.(*http.Transport).TLSClientConfig //nolint:forcetypeassert
+	upstreams := map[string]Upstream{
+		"dns": {Protocol: ProtocolDNS, Address: packetConn.LocalAddr().String()},
+		"tls": {Protocol: ProtocolTLS, Address: listener.Addr().String()},
+		// The test certificate is valid for example.com.
+		"tls_server_name": {Protocol: ProtocolTLS, Address: listener.Addr().String(), ServerName: "example.com"},
+		"https":           {Protocol: ProtocolHTTPS, Address: httpsServer.URL + "/dns-query"},
+	}
+	both := []uint16{dns.TypeA, dns.TypeAAAA}
+	ipv4 := netip.MustParseAddr("1.2.3.4")
+	ipv6 := netip.MustParseAddr("2001:db8::1")
+
+	for name, upstream := range upstreams {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			resolver := New([]Upstream{upstream}, both, tlsConfig)
+			ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+			defer cancel()
+
+			hostTTLs := map[string]time.Duration{
+				"direct.example":  300 * time.Second,
+				"chain.example":   time.Minute,
+				"partial.example": 300 * time.Second,
+			}
+			for host, expectedTTL := range hostTTLs {
+				addresses, ttl, err := resolver.LookupNetIP(ctx, host)
+				require.NoError(t, err, host)
+				assert.ElementsMatch(t, []netip.Addr{ipv4, ipv6}, addresses, host)
+				assert.Equal(t, expectedTTL, ttl, host)
+			}
+
+			ipv4Resolver := New([]Upstream{upstream}, []uint16{dns.TypeA}, tlsConfig)
+			addresses, _, err := ipv4Resolver.LookupNetIP(ctx, "chain.example")
+			require.NoError(t, err)
+			assert.Equal(t, []netip.Addr{ipv4}, addresses)
+
+			unreachable := Upstream{Protocol: ProtocolDNS, Address: "127.0.0.1:1"}
+			fallbackResolver := New([]Upstream{unreachable, upstream}, both, tlsConfig)
+			addresses, _, err = fallbackResolver.LookupNetIP(ctx, "direct.example")
+			require.NoError(t, err)
+			assert.ElementsMatch(t, []netip.Addr{ipv4, ipv6}, addresses)
+
+			var dnsErr *net.DNSError
+			_, _, err = resolver.LookupNetIP(ctx, "missing.example")
+			require.True(t, errors.As(err, &dnsErr))
+			assert.True(t, dnsErr.IsNotFound)
+
+			_, _, err = resolver.LookupNetIP(ctx, "failing.example")
+			require.True(t, errors.As(err, &dnsErr))
+			assert.True(t, dnsErr.IsTemporary)
+		})
+	}
+}
+
+// recordingListener records the connections it accepts.
+type recordingListener struct {
+	net.Listener
+	mutex sync.Mutex
+	conns []net.Conn
+}
+
+func (l *recordingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.mutex.Lock()
+		l.conns = append(l.conns, conn)
+		l.mutex.Unlock()
+	}
+	return conn, err
+}
+
+func (l *recordingListener) accepted() []net.Conn {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return append([]net.Conn(nil), l.conns...)
+}
+
+func Test_Resolver_LookupNetIP_tlsConnectionReuse(t *testing.T) {
+	t.Parallel()
+
+	certificateServer := httptest.NewTLSServer(http.NotFoundHandler())
+	t.Cleanup(certificateServer.Close)
+	tlsListener, err := tls.Listen("tcp", "127.0.0.1:0", certificateServer.TLS)
+	require.NoError(t, err)
+	listener := &recordingListener{Listener: tlsListener}
+	server := &dns.Server{Listener: listener, Net: "tcp-tls", Handler: dns.HandlerFunc(testZoneHandler)}
+	go func() { _ = server.ActivateAndServe() }()
+	t.Cleanup(func() { _ = server.Shutdown() })
+
+	tlsConfig := certificateServer.Client().Transport.(*http.Transport).TLSClientConfig //nolint:forcetypeassert
+	upstream := Upstream{Protocol: ProtocolTLS, Address: listener.Addr().String()}
+	resolver := New([]Upstream{upstream}, []uint16{dns.TypeA}, tlsConfig)
+	t.Cleanup(resolver.Close)
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	for range 3 {
+		_, _, err = resolver.LookupNetIP(ctx, "direct.example")
+		require.NoError(t, err)
+	}
+	require.Len(t, listener.accepted(), 1)
+
+	// The server closing the idle connection makes the
+	// resolver retry the query on a new connection.
+	_ = listener.accepted()[0].Close()
+	addresses, _, err := resolver.LookupNetIP(ctx, "direct.example")
+	require.NoError(t, err)
+	assert.Equal(t, []netip.Addr{netip.MustParseAddr("1.2.3.4")}, addresses)
+	assert.Len(t, listener.accepted(), 2)
+}
+
+func Test_Resolver_LookupNetIP_protocolNotSupported(t *testing.T) {
+	t.Parallel()
+
+	upstream := Upstream{Protocol: "quic", Address: "127.0.0.1:853"}
+	resolver := New([]Upstream{upstream}, []uint16{dns.TypeA}, nil)
+
+	_, _, err := resolver.LookupNetIP(t.Context(), "direct.example")
+	assert.ErrorIs(t, err, ErrProtocolNotSupported)
+}
+
+// httpResponseWriter writes DNS responses as DNS over HTTPS responses.
+type httpResponseWriter struct {
+	dns.ResponseWriter
+	writer http.ResponseWriter
+}
+
+func (w *httpResponseWriter) WriteMsg(response *dns.Msg) error {
+	data, err := response.Pack()
+	if err != nil {
+		return err
+	}
+	w.writer.Header().Set("Content-Type", "application/dns-message")
+	_, err = w.writer.Write(data)
+	return err
+}
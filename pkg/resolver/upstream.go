@@ -0,0 +1,209 @@
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Protocol is the protocol used to query an upstream server.
+type Protocol string
+
+const (
+	// ProtocolDNS is plain DNS over UDP, retried over TCP
+	// if the response is truncated.
+	ProtocolDNS Protocol = "dns"
+	// ProtocolTLS is DNS over TLS.
+	ProtocolTLS Protocol = "tls"
+	// ProtocolHTTPS is DNS over HTTPS.
+	ProtocolHTTPS Protocol = "https"
+)
+
+// Upstream is an upstream DNS server.
+type Upstream struct {
+	// Protocol is the protocol used to query the server.
+	Protocol Protocol
+	// Address is the host:port address of the server for the
+	// DNS and TLS protocols, and its URL for the HTTPS protocol.
+	Address string
+	// ServerName is the name verified in the certificate of the server
+	// for the TLS protocol. It defaults to the host of the address if
+	// left empty, which must then be in the certificate, so servers
+	// addressed by IP must have IP addresses in their certificate.
+	ServerName string
+}
+
+func (u Upstream) String() string {
+	switch {
+	case u.Protocol == ProtocolHTTPS:
+		return u.Address
+	case u.ServerName != "":
+		return string(u.Protocol) + "://" + u.ServerName + "@" + u.Address
+	default:
+		return string(u.Protocol) + "://" + u.Address
+	}
+}
+
+var (
+	ErrUpstreamSchemeUnknown = errors.New("upstream scheme is unknown")
+	ErrUpstreamHostMissing   = errors.New("upstream host is missing")
+	ErrServerNameUnsupported = errors.New("upstream server name is only supported for DNS over TLS")
+	ErrServerNameEmpty       = errors.New("upstream server name is empty")
+)
+
+// ParseUpstream parses an upstream server from a string such as
+// "1.1.1.1", "dns://1.1.1.1:53", "tls://1.1.1.1",
+// "tls://one.one.one.one@1.1.1.1" or "https://cloudflare-dns.com/dns-query".
+// Strings without a scheme are plain DNS servers. The port defaults to 53
+// for plain DNS and to 853 for DNS over TLS. The name before "@" of a DNS
+// over TLS server is the name verified in its certificate, to use for
+// servers addressed by IP without IP addresses in their certificate.
+func ParseUpstream(s string) (upstream Upstream, err error) {
+	scheme, rest, found := strings.Cut(s, "://")
+	if !found {
+		scheme, rest = string(ProtocolDNS), s
+	}
+
+	switch Protocol(scheme) {
+	case ProtocolDNS, ProtocolTLS:
+		upstream.Protocol = Protocol(scheme)
+	case ProtocolHTTPS:
+		parsed, err := url.Parse(s)
+		if err != nil {
+			return Upstream{}, err
+		} else if parsed.Host == "" {
+			return Upstream{}, fmt.Errorf("%w: %s", ErrUpstreamHostMissing, s)
+		}
+		return Upstream{Protocol: ProtocolHTTPS, Address: parsed.String()}, nil
+	default:
+		return Upstream{}, fmt.Errorf("%w: %s", ErrUpstreamSchemeUnknown, scheme)
+	}
+
+	serverName, host, found := strings.Cut(rest, "@")
+	switch {
+	case !found:
+	case upstream.Protocol != ProtocolTLS:
+		return Upstream{}, fmt.Errorf("%w: %s", ErrServerNameUnsupported, s)
+	case serverName == "":
+		return Upstream{}, fmt.Errorf("%w: %s", ErrServerNameEmpty, s)
+	default:
+		upstream.ServerName, rest = serverName, host
+	}
+
+	defaultPort := "53"
+	if upstream.Protocol == ProtocolTLS {
+		defaultPort = "853"
+	}
+	upstream.Address, err = hostPort(rest, defaultPort)
+	if err != nil {
+		return Upstream{}, fmt.Errorf("parsing %s: %w", s, err)
+	}
+	return upstream, nil
+}
+
+// hostPort returns the host:port address of the address given,
+// adding the default port if it has no port.
+func hostPort(address, defaultPort string) (hostPort string, err error) {
+	if address == "" {
+		return "", ErrUpstreamHostMissing
+	}
+	ip, err := netip.ParseAddr(strings.Trim(address, "[]"))
+	if err == nil {
+		return net.JoinHostPort(ip.String(), defaultPort), nil
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return net.JoinHostPort(address, defaultPort), nil //nolint:nilerr
+	} else if host == "" {
+		return "", ErrUpstreamHostMissing
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+var ErrProtocolNotSupported = errors.New("protocol is not supported")
+
+// exchange sends the query to the upstream server and returns its response.
+func (r *Resolver) exchange(ctx context.Context, upstream Upstream, query *dns.Msg) (
+	response *dns.Msg, err error,
+) {
+	switch upstream.Protocol {
+	case ProtocolDNS:
+		client := &dns.Client{Net: "udp"}
+		response, _, err = client.ExchangeContext(ctx, query, upstream.Address)
+		if err == nil && response.Truncated {
+			client.Net = "tcp"
+			response, _, err = client.ExchangeContext(ctx, query, upstream.Address)
+		}
+		return response, err
+	case ProtocolTLS:
+		return r.tlsPools[upstream.String()].exchange(ctx, query)
+	case ProtocolHTTPS:
+		return r.exchangeHTTPS(ctx, upstream.Address, query)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrProtocolNotSupported, upstream.Protocol)
+	}
+}
+
+var ErrHTTPStatusNotOK = errors.New("HTTP status code is not OK")
+
+// exchangeHTTPS sends the query as a DNS over HTTPS POST request
+// to the URL given, as described in RFC 8484.
+func (r *Resolver) exchangeHTTPS(ctx context.Context, url string, query *dns.Msg) (
+	response *dns.Msg, err error,
+) {
+	// The query ID is 0 to make responses cacheable, see RFC 8484 section 4.1.
+	query = query.Copy()
+	query.Id = 0
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("packing query: %w", err)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(packed))
+	if err != nil {
+		return nil, err
+	}
+	const mediaType = "application/dns-message"
+	request.Header.Set("Content-Type", mediaType)
+	request.Header.Set("Accept", mediaType)
+
+	httpResponse, err := r.httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResponse.Body.Close()
+
+	if httpResponse.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s", ErrHTTPStatusNotOK, httpResponse.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(httpResponse.Body, dns.MaxMsgSize))
+	if err != nil {
+		return nil, fmt.Errorf("reading response body: %w", err)
+	}
+	response = new(dns.Msg)
+	err = response.Unpack(data)
+	if err != nil {
+		return nil, fmt.Errorf("unpacking response: %w", err)
+	}
+	return response, nil
+}
+
+// newHTTPClient returns an HTTP client for DNS over HTTPS
+// using the TLS configuration given.
+func newHTTPClient(tlsConfig *tls.Config) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone() //nolint:forcetypeassert
+	transport.TLSClientConfig = tlsConfig
+	transport.ForceAttemptHTTP2 = true
+	return &http.Client{Transport: transport}
+}
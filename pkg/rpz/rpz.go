@@ -0,0 +1,201 @@
+// Package rpz writes response policy zone (RPZ) files from hostnames
+// and IP addresses lists, as consumed by DNS resolvers such as BIND
+// and Knot Resolver.
+package rpz
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/qdm12/updated/pkg/ips"
+)
+
+// Action is the policy action applied to the triggers of the zone.
+type Action uint8
+
+const (
+	// ActionNXDOMAIN answers queries with NXDOMAIN, with `CNAME .` records.
+	ActionNXDOMAIN Action = iota
+	// ActionNODATA answers queries with no data, with `CNAME *.` records.
+	ActionNODATA
+	// ActionRedirect answers queries with local data, redirecting
+	// to an IP address or to a hostname.
+	ActionRedirect
+)
+
+var ErrActionNotValid = errors.New("RPZ action is not valid")
+
+// ParseAction parses an action from one of "nxdomain", "nodata" or "redirect".
+func ParseAction(s string) (action Action, err error) {
+	switch strings.ToLower(s) {
+	case "nxdomain":
+		return ActionNXDOMAIN, nil
+	case "nodata":
+		return ActionNODATA, nil
+	case "redirect":
+		return ActionRedirect, nil
+	default:
+		return 0, fmt.Errorf("%w: %q must be one of nxdomain, nodata or redirect",
+			ErrActionNotValid, s)
+	}
+}
+
+// String returns the string representation of the action.
+func (a Action) String() string {
+	switch a {
+	case ActionNXDOMAIN:
+		return "nxdomain"
+	case ActionNODATA:
+		return "nodata"
+	case ActionRedirect:
+		return "redirect"
+	default:
+		return "unknown"
+	}
+}
+
+// Policy is the policy of the zone.
+type Policy struct {
+	// Action is the action applied to all the triggers of the zone.
+	Action Action
+	// Redirect is the IP address or hostname to redirect to,
+	// and must be set only for [ActionRedirect].
+	Redirect string
+}
+
+var (
+	ErrRedirectMissing  = errors.New("RPZ redirect target is missing")
+	ErrRedirectNotValid = errors.New("RPZ redirect target is not valid")
+	ErrRedirectUnused   = errors.New("RPZ redirect target is set but action is not redirect")
+)
+
+// Validate validates the policy.
+func (p Policy) Validate() (err error) {
+	switch {
+	case p.Action != ActionRedirect && p.Redirect != "":
+		return fmt.Errorf("%w: %s", ErrRedirectUnused, p.Action)
+	case p.Action != ActionRedirect:
+		return nil
+	case p.Redirect == "":
+		return ErrRedirectMissing
+	}
+
+	_, err = netip.ParseAddr(p.Redirect)
+	if err != nil && !validHostname(strings.TrimSuffix(p.Redirect, ".")) {
+		return fmt.Errorf("%w: %q must be an IP address or a hostname",
+			ErrRedirectNotValid, p.Redirect)
+	}
+	return nil
+}
+
+// rdata returns the type and data of the records implementing the policy.
+func (p Policy) rdata() (data string) {
+	switch p.Action {
+	case ActionNODATA:
+		return "CNAME *."
+	case ActionRedirect:
+		address, err := netip.ParseAddr(p.Redirect)
+		switch {
+		case err != nil:
+			return "CNAME " + strings.TrimSuffix(p.Redirect, ".") + "."
+		case address.Is4():
+			return "A " + address.String()
+		default:
+			return "AAAA " + address.String()
+		}
+	default:
+		return "CNAME ."
+	}
+}
+
+// validHostname returns true if the hostname given can be written as
+// is as a relative owner name in the zone file, that is if it only
+// contains lowercase letters, digits, hyphens and underscores in
+// non empty labels of at most 63 characters.
+func validHostname(hostname string) bool {
+	const maxHostnameLength = 253
+	if hostname == "" || len(hostname) > maxHostnameLength {
+		return false
+	}
+	const maxLabelLength = 63
+	for label := range strings.SplitSeq(hostname, ".") {
+		if label == "" || len(label) > maxLabelLength {
+			return false
+		}
+		for _, r := range label {
+			valid := (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') ||
+				r == '-' || r == '_'
+			if !valid {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// ErrIPNotValid is returned for IP addresses and CIDRs
+// not valid for [ips.ParsePrefix].
+var ErrIPNotValid = ips.ErrEntryNotValid
+
+// ipTrigger returns the owner name of the rpz-ip trigger for the IP
+// address or CIDR given, for example "24.0.2.0.192.rpz-ip" for
+// 192.0.2.0/24 and "48.zz.db8.2001.rpz-ip" for 2001:db8::/48.
+func ipTrigger(value string) (owner string, err error) {
+	prefix, err := ips.ParsePrefix(value)
+	if err != nil {
+		return "", err
+	}
+
+	labels := []string{strconv.Itoa(prefix.Bits())}
+	bytes := prefix.Addr().AsSlice()
+	if prefix.Addr().Is4() {
+		for i := len(bytes) - 1; i >= 0; i-- {
+			labels = append(labels, strconv.Itoa(int(bytes[i])))
+		}
+		return strings.Join(labels, ".") + ".rpz-ip", nil
+	}
+
+	const words = 8
+	var address [words]uint16
+	for i := range address {
+		address[i] = uint16(bytes[2*i])<<8 | uint16(bytes[2*i+1]) //nolint:mnd
+	}
+	zerosStart, zerosLength := longestZeros(address)
+	for i := words - 1; i >= 0; i-- {
+		switch {
+		case i >= zerosStart && i < zerosStart+zerosLength:
+			if i == zerosStart {
+				labels = append(labels, "zz")
+			}
+		default:
+			labels = append(labels, strconv.FormatUint(uint64(address[i]), 16)) //nolint:mnd
+		}
+	}
+	return strings.Join(labels, ".") + ".rpz-ip", nil
+}
+
+// longestZeros returns the start index and length of the first longest
+// run of at least two zero words, which is replaced by "zz" in rpz-ip
+// triggers in the same way as "::" in IPv6 addresses. The length
+// returned is 0 if there is no such run.
+func longestZeros(address [8]uint16) (start, length int) {
+	const minLength = 2
+	runStart, runLength := 0, 0
+	for i, word := range address {
+		if word != 0 {
+			runLength = 0
+			continue
+		}
+		if runLength == 0 {
+			runStart = i
+		}
+		runLength++
+		if runLength >= minLength && runLength > length {
+			start, length = runStart, runLength
+		}
+	}
+	return start, length
+}
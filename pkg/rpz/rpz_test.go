@@ -0,0 +1,127 @@
+package rpz
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ipTrigger(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		value      string
+		owner      string
+		errMessage string
+	}{
+		"ipv4 address": {
+			value: "192.0.2.1",
+			owner: "32.1.2.0.192.rpz-ip",
+		},
+		"ipv4 cidr": {
+			value: "192.0.2.7/24",
+			owner: "24.0.2.0.192.rpz-ip",
+		},
+		"ipv6 address": {
+			value: "2001:db8::1",
+			owner: "128.1.zz.db8.2001.rpz-ip",
+		},
+		"ipv6 cidr": {
+			value: "2001:db8::/48",
+			owner: "48.zz.db8.2001.rpz-ip",
+		},
+		"ipv6 single zero word": {
+			value: "2001:db8:0:1:1:1:1:1",
+			owner: "128.1.1.1.1.1.0.db8.2001.rpz-ip",
+		},
+		"ipv4 mapped ipv6": {
+			value: "::ffff:192.0.2.0/120",
+			owner: "24.0.2.0.192.rpz-ip",
+		},
+		"malformed": {
+			value:      "x",
+			errMessage: "IP address or CIDR is not valid: x",
+		},
+		"ipv4 mapped ipv6 shorter than /96": {
+			value: "::ffff:0:0/80",
+			errMessage: "IP address or CIDR is not valid: " +
+				"::ffff:0:0/80 is an IPv4-mapped prefix shorter than /96",
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			owner, err := ipTrigger(testCase.value)
+			assert.Equal(t, testCase.owner, owner)
+			if testCase.errMessage != "" {
+				assert.EqualError(t, err, testCase.errMessage)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func Test_Zone_Write(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.rpz")
+	statePath := filepath.Join(dir, "state", "a.json")
+	zone := New(path, path, statePath, Policy{Action: ActionNXDOMAIN})
+	now := time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC)
+
+	result, err := zone.Write([]string{"a.com", "b;.com"}, []string{"192.0.2.0/24"}, now)
+	require.NoError(t, err)
+	assert.Equal(t, Result{Changed: true, Serial: 2024050600, Skipped: 1}, result)
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	const expected = "$TTL 300\n" +
+		"@ SOA localhost. hostmaster.localhost. 2024050600 3600 600 604800 300\n" +
+		"@ NS localhost.\n" +
+		"a.com CNAME .\n" +
+		"*.a.com CNAME .\n" +
+		"24.0.2.0.192.rpz-ip CNAME .\n"
+	assert.Equal(t, expected, string(content))
+
+	result, err = zone.Write([]string{"a.com", "b;.com"}, []string{"192.0.2.0/24"}, now)
+	require.NoError(t, err)
+	assert.Equal(t, Result{Serial: 2024050600, Skipped: 1}, result)
+
+	result, err = zone.Write([]string{"a.com"}, nil, now)
+	require.NoError(t, err)
+	assert.Equal(t, Result{Changed: true, Serial: 2024050601}, result)
+
+	// Staged zones are compared with the published zone, and their
+	// serial keeps increasing even if they are never published.
+	stagedPath := filepath.Join(dir, "staged.rpz")
+	staged := New(stagedPath, path, statePath, Policy{Action: ActionNXDOMAIN})
+	result, err = staged.Write([]string{"a.com"}, nil, now)
+	require.NoError(t, err)
+	assert.Equal(t, Result{Serial: 2024050601}, result)
+	assert.NoFileExists(t, stagedPath)
+
+	for _, expectedSerial := range []uint32{2024050602, 2024050603} {
+		result, err = staged.Write([]string{"b.com"}, nil, now)
+		require.NoError(t, err)
+		assert.Equal(t, Result{Changed: true, Serial: expectedSerial}, result)
+	}
+}
+
+func Test_Policy_rdata(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "CNAME .", Policy{Action: ActionNXDOMAIN}.rdata())
+	assert.Equal(t, "CNAME *.", Policy{Action: ActionNODATA}.rdata())
+	assert.Equal(t, "A 0.0.0.0", Policy{Action: ActionRedirect, Redirect: "0.0.0.0"}.rdata())
+	assert.Equal(t, "AAAA ::1", Policy{Action: ActionRedirect, Redirect: "::1"}.rdata())
+	assert.Equal(t, "CNAME block.example.com.",
+		Policy{Action: ActionRedirect, Redirect: "block.example.com"}.rdata())
+}
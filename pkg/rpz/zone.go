@@ -0,0 +1,338 @@
+package rpz
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Zone writes a response policy zone file, keeping its SOA serial
+// in a state file to increase it on every content change.
+type Zone struct {
+	path          string
+	publishedPath string
+	statePath     string
+	policy        Policy
+}
+
+// New creates a zone writing to the zone file path given, storing its
+// serial state at the state file path given. The published path is the
+// path of the zone file currently published, which can be the same as
+// the zone file path, and is compared with the zone generated to detect
+// content changes. The policy must be valid.
+func New(path, publishedPath, statePath string, policy Policy) *Zone {
+	return &Zone{
+		path:          path,
+		publishedPath: publishedPath,
+		statePath:     statePath,
+		policy:        policy,
+	}
+}
+
+// Result is the result of writing a zone.
+type Result struct {
+	// Changed is true if the zone content changed and the zone
+	// file was written with a new serial.
+	Changed bool
+	// Serial is the SOA serial of the zone file.
+	Serial uint32
+	// Skipped is the number of hostnames skipped because they
+	// cannot be written as owner names.
+	Skipped int
+}
+
+// Write writes the zone file with triggers for the hostnames and their
+// subdomains, and rpz-ip triggers for the IP addresses and CIDRs given.
+// The zone file is not written if the records did not change compared
+// to the published zone file. The zone generated is checked with a zone
+// parser before replacing the previous zone file.
+func (z *Zone) Write(hostnames, ips []string, now time.Time) (result Result, err error) {
+	bodyPath := z.path + ".body.tmp"
+	digest, skipped, err := z.writeBody(bodyPath, hostnames, ips)
+	if err != nil {
+		_ = os.Remove(bodyPath)
+		return Result{}, fmt.Errorf("writing records: %w", err)
+	}
+	defer os.Remove(bodyPath)
+	result.Skipped = skipped
+
+	published, err := readPublished(z.publishedPath)
+	if err != nil {
+		return Result{}, fmt.Errorf("reading published zone: %w", err)
+	} else if published.exists && published.digest == digest {
+		result.Serial = published.serial
+		return result, nil
+	}
+
+	state, err := loadState(z.statePath)
+	if err != nil {
+		return Result{}, fmt.Errorf("loading serial state: %w", err)
+	}
+	state.Serial = nextSerial(max(state.Serial, published.serial), now)
+
+	tempPath := z.path + ".tmp"
+	err = writeZone(tempPath, bodyPath, state.Serial)
+	if err != nil {
+		_ = os.Remove(tempPath)
+		return Result{}, err
+	}
+
+	err = checkZone(tempPath)
+	if err != nil {
+		_ = os.Remove(tempPath)
+		return Result{}, err
+	}
+
+	err = os.Rename(tempPath, z.path)
+	if err != nil {
+		_ = os.Remove(tempPath)
+		return Result{}, fmt.Errorf("moving zone file: %w", err)
+	}
+
+	err = saveState(z.statePath, state)
+	if err != nil {
+		return Result{}, fmt.Errorf("saving serial state: %w", err)
+	}
+
+	result.Changed = true
+	result.Serial = state.Serial
+	return result, nil
+}
+
+// writeBody writes all the records of the zone except the SOA and
+// NS records to the file path given, and returns the digest of the
+// records written and the number of hostnames skipped.
+func (z *Zone) writeBody(path string, hostnames, ips []string) (
+	digest string, skipped int, err error,
+) {
+	const perms = 0o600
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perms) //nolint:gosec
+	if err != nil {
+		return "", 0, err
+	}
+
+	hash := sha256.New()
+	writer := bufio.NewWriter(io.MultiWriter(file, hash))
+	rdata := z.policy.rdata()
+	for _, hostname := range hostnames {
+		if !validHostname(hostname) {
+			skipped++
+			continue
+		}
+		_, _ = writer.WriteString(hostname + " " + rdata + "\n")
+		_, _ = writer.WriteString("*." + hostname + " " + rdata + "\n")
+	}
+
+	for _, ip := range ips {
+		owner, err := ipTrigger(ip)
+		if err != nil {
+			_ = file.Close()
+			return "", 0, err
+		}
+		_, _ = writer.WriteString(owner + " " + rdata + "\n")
+	}
+
+	err = writer.Flush()
+	if err != nil {
+		_ = file.Close()
+		return "", 0, err
+	}
+
+	err = file.Close()
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), skipped, nil
+}
+
+// Zone header values. The zone records use relative owner names,
+// so the zone name is the one configured in the DNS resolver.
+const (
+	ttl        = 300
+	refresh    = 3600
+	retry      = 600
+	expire     = 604800
+	minimumTTL = 300
+	nameserver = "localhost."
+	mailbox    = "hostmaster.localhost."
+)
+
+// writeZone writes the zone file to the path given, with its SOA and
+// NS records followed by the records of the body file given.
+func writeZone(path, bodyPath string, serial uint32) (err error) {
+	body, err := os.Open(bodyPath) //nolint:gosec
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	const perms = 0o600
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perms) //nolint:gosec
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+	_, _ = fmt.Fprintf(writer, "$TTL %d\n", ttl)
+	_, _ = fmt.Fprintf(writer, "@ SOA %s %s %d %d %d %d %d\n",
+		nameserver, mailbox, serial, refresh, retry, expire, minimumTTL)
+	_, _ = fmt.Fprintf(writer, "@ NS %s\n", nameserver)
+	_, err = io.Copy(writer, body)
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("copying records: %w", err)
+	}
+
+	err = writer.Flush()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+var ErrZoneNotValid = errors.New("zone is not valid")
+
+// checkOrigin is the origin used to check the relative owner
+// names of the zone file.
+const checkOrigin = "rpz."
+
+// checkZone parses the zone file at the path given, and returns an
+// error if it has a syntax error or does not start with an SOA record.
+func checkZone(path string) (err error) {
+	file, err := os.Open(path) //nolint:gosec
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	parser := dns.NewZoneParser(bufio.NewReader(file), checkOrigin, filepath.Base(path))
+	first := true
+	for record, ok := parser.Next(); ok; record, ok = parser.Next() {
+		if first && record.Header().Rrtype != dns.TypeSOA {
+			return fmt.Errorf("%w: first record is not an SOA record", ErrZoneNotValid)
+		}
+		first = false
+	}
+
+	err = parser.Err()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrZoneNotValid, err)
+	}
+	return nil
+}
+
+type serialState struct {
+	Serial uint32 `json:"serial"`
+}
+
+// publishedZone describes a published zone file.
+type publishedZone struct {
+	exists bool
+	serial uint32
+	// digest is the digest of the records following the
+	// header lines, or empty if the header is not recognized.
+	digest string
+}
+
+// readPublished reads the serial and records digest of the zone
+// file at the path given, previously written by [Zone.Write].
+func readPublished(path string) (published publishedZone, err error) {
+	file, err := os.Open(path) //nolint:gosec
+	if errors.Is(err, fs.ErrNotExist) {
+		return publishedZone{}, nil
+	} else if err != nil {
+		return publishedZone{}, err
+	}
+	defer file.Close()
+	published.exists = true
+
+	reader := bufio.NewReader(file)
+	const headerLines, soaLine, soaSerialField = 3, 1, 4
+	for i := range headerLines {
+		line, err := reader.ReadString('\n')
+		if errors.Is(err, io.EOF) {
+			return published, nil
+		} else if err != nil {
+			return publishedZone{}, err
+		}
+		if i != soaLine {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) <= soaSerialField {
+			return published, nil
+		}
+		serial, err := strconv.ParseUint(fields[soaSerialField], 10, 32)
+		if err != nil {
+			return published, nil //nolint:nilerr
+		}
+		published.serial = uint32(serial)
+	}
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, reader)
+	if err != nil {
+		return publishedZone{}, err
+	}
+	published.digest = hex.EncodeToString(hash.Sum(nil))
+	return published, nil
+}
+
+// nextSerial returns the serial following the previous serial given,
+// in the date based format YYYYMMDDnn if possible.
+func nextSerial(previous uint32, now time.Time) uint32 {
+	const dateMultiplier = 100
+	date, _ := strconv.ParseUint(now.UTC().Format("20060102"), 10, 32)
+	return max(previous+1, uint32(date)*dateMultiplier) //nolint:gosec
+}
+
+// loadState loads the serial state from the path given, returning
+// an empty state if the file does not exist.
+func loadState(path string) (state serialState, err error) {
+	data, err := os.ReadFile(path) //nolint:gosec
+	if errors.Is(err, fs.ErrNotExist) {
+		return serialState{}, nil
+	} else if err != nil {
+		return serialState{}, err
+	}
+
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return serialState{}, fmt.Errorf("decoding: %w", err)
+	}
+	return state, nil
+}
+
+func saveState(path string, state serialState) (err error) {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("encoding: %w", err)
+	}
+
+	const dirPerms = 0o700
+	err = os.MkdirAll(filepath.Dir(path), dirPerms)
+	if err != nil {
+		return fmt.Errorf("creating directory: %w", err)
+	}
+
+	tempPath := path + ".tmp"
+	const perms = 0o600
+	err = os.WriteFile(tempPath, data, perms)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tempPath, path)
+}
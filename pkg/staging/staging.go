@@ -0,0 +1,250 @@
+// Package staging stages files in a temporary directory before promoting
+// them all to their target directory, so that the target directory never
+// contains partially written files.
+package staging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// prefix is the name prefix of staging directories. Staging directories
+// are hidden directories created in the target directory, so that files
+// can be moved to the target directory with atomic renames.
+const prefix = ".staging-"
+
+// Dir is a staging directory for a target directory.
+type Dir struct {
+	path   string
+	target string
+}
+
+// Clean removes the leftover staging directories in the target directory,
+// for example from a previous program instance which was interrupted.
+func Clean(target string) (err error) {
+	matches, err := filepath.Glob(filepath.Join(target, prefix+"*"))
+	if err != nil {
+		return err
+	}
+	for _, match := range matches {
+		err = os.RemoveAll(match)
+		if err != nil {
+			return fmt.Errorf("removing %s: %w", match, err)
+		}
+	}
+	return nil
+}
+
+// New creates a new staging directory in the target directory,
+// creating the target directory if it does not exist.
+func New(target string) (dir *Dir, err error) {
+	const perms = 0o700
+	err = os.MkdirAll(target, perms)
+	if err != nil {
+		return nil, fmt.Errorf("creating target directory: %w", err)
+	}
+
+	path, err := os.MkdirTemp(target, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("creating staging directory: %w", err)
+	}
+	return &Dir{
+		path:   path,
+		target: target,
+	}, nil
+}
+
+// Root returns the path of the staging directory.
+func (d *Dir) Root() string {
+	return d.path
+}
+
+// Path returns the staging path of the filename given.
+func (d *Dir) Path(filename string) string {
+	return filepath.Join(d.path, filename)
+}
+
+// TargetPath returns the target path of the filename given.
+func (d *Dir) TargetPath(filename string) string {
+	return filepath.Join(d.target, filename)
+}
+
+// CurrentPath returns the staging path of the filename given if it is
+// staged, and its target path otherwise.
+func (d *Dir) CurrentPath(filename string) string {
+	path := d.Path(filename)
+	_, err := os.Stat(path)
+	if err == nil {
+		return path
+	}
+	return d.TargetPath(filename)
+}
+
+// Filenames returns the paths relative to the staging directory of the
+// regular files staged, including the files of its subdirectories,
+// ignoring temporary files ending with ".tmp".
+func (d *Dir) Filenames() (filenames []string, err error) {
+	err = filepath.WalkDir(d.path, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		} else if !entry.Type().IsRegular() || strings.HasSuffix(entry.Name(), ".tmp") {
+			return nil
+		}
+		filename, err := filepath.Rel(d.path, path)
+		if err != nil {
+			return err
+		}
+		filenames = append(filenames, filename)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return filenames, nil
+}
+
+// DropUnchanged removes the staged files identical to their target file,
+// so that unchanged target files are left untouched.
+func (d *Dir) DropUnchanged() (err error) {
+	filenames, err := d.Filenames()
+	if err != nil {
+		return err
+	}
+	for _, filename := range filenames {
+		equal, err := filesEqual(d.Path(filename), d.TargetPath(filename))
+		if err != nil {
+			return fmt.Errorf("comparing %s: %w", filename, err)
+		} else if !equal {
+			continue
+		}
+		err = os.Remove(d.Path(filename))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Promote syncs the staged files to disk and moves them to the target
+// directory with atomic renames, creating the target subdirectories of
+// files staged in subdirectories. It returns the filenames promoted.
+func (d *Dir) Promote() (promoted []string, err error) {
+	filenames, err := d.Filenames()
+	if err != nil {
+		return nil, err
+	}
+
+	dirs := map[string]struct{}{d.path: {}}
+	targetDirs := map[string]struct{}{d.target: {}}
+	for _, filename := range filenames {
+		err = syncPath(d.Path(filename))
+		if err != nil {
+			return nil, fmt.Errorf("syncing %s: %w", filename, err)
+		}
+		dirs[filepath.Dir(d.Path(filename))] = struct{}{}
+		targetDirs[filepath.Dir(d.TargetPath(filename))] = struct{}{}
+	}
+	for dir := range dirs {
+		err = syncPath(dir)
+		if err != nil {
+			return nil, fmt.Errorf("syncing staging directory: %w", err)
+		}
+	}
+
+	const dirPerms = 0o700
+	for dir := range targetDirs {
+		err = os.MkdirAll(dir, dirPerms)
+		if err != nil {
+			return nil, fmt.Errorf("creating target directory: %w", err)
+		}
+	}
+
+	for _, filename := range filenames {
+		err = os.Rename(d.Path(filename), d.TargetPath(filename))
+		if err != nil {
+			return promoted, fmt.Errorf("moving %s: %w", filename, err)
+		}
+		promoted = append(promoted, filename)
+	}
+
+	for dir := range targetDirs {
+		err = syncPath(dir)
+		if err != nil {
+			return promoted, fmt.Errorf("syncing target directory: %w", err)
+		}
+	}
+	return promoted, nil
+}
+
+// Remove removes the staging directory and all the files it contains.
+func (d *Dir) Remove() error {
+	return os.RemoveAll(d.path)
+}
+
+func syncPath(path string) (err error) {
+	file, err := os.Open(path) //nolint:gosec
+	if err != nil {
+		return err
+	}
+	err = file.Sync()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// filesEqual returns true if both files exist and have the same content.
+func filesEqual(pathA, pathB string) (equal bool, err error) {
+	statA, err := os.Stat(pathA)
+	if err != nil {
+		return false, err
+	}
+	statB, err := os.Stat(pathB)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if statA.Size() != statB.Size() {
+		return false, nil
+	}
+
+	fileA, err := os.Open(pathA) //nolint:gosec
+	if err != nil {
+		return false, err
+	}
+	defer fileA.Close()
+	fileB, err := os.Open(pathB) //nolint:gosec
+	if err != nil {
+		return false, err
+	}
+	defer fileB.Close()
+
+	const bufferSize = 64 * 1024
+	bufferA := make([]byte, bufferSize)
+	bufferB := make([]byte, bufferSize)
+	for {
+		nA, errA := io.ReadFull(fileA, bufferA)
+		nB, errB := io.ReadFull(fileB, bufferB)
+		if !bytes.Equal(bufferA[:nA], bufferB[:nB]) {
+			return false, nil
+		}
+		endA := errors.Is(errA, io.EOF) || errors.Is(errA, io.ErrUnexpectedEOF)
+		endB := errors.Is(errB, io.EOF) || errors.Is(errB, io.ErrUnexpectedEOF)
+		switch {
+		case errA != nil && !endA:
+			return false, errA
+		case errB != nil && !endB:
+			return false, errB
+		case endA || endB:
+			return endA && endB, nil
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package staging
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Dir(t *testing.T) {
+	t.Parallel()
+
+	target := t.TempDir()
+	write := func(path, content string) {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	}
+	leftover := filepath.Join(target, prefix+"leftover")
+	require.NoError(t, os.Mkdir(leftover, 0o700))
+	write(filepath.Join(target, "unchanged"), "same")
+	write(filepath.Join(target, "changed"), "old")
+	write(filepath.Join(target, "kept"), "kept")
+
+	err := Clean(target)
+	require.NoError(t, err)
+	assert.NoDirExists(t, leftover)
+
+	dir, err := New(target)
+	require.NoError(t, err)
+
+	write(dir.Path("unchanged"), "same")
+	write(dir.Path("changed"), "new")
+	write(dir.Path("added"), "added")
+	write(dir.Path("partial.tmp"), "partial")
+	require.NoError(t, os.Mkdir(dir.Path("sub"), 0o700))
+	write(dir.Path(filepath.Join("sub", "nested")), "nested")
+	assert.Equal(t, dir.Path("changed"), dir.CurrentPath("changed"))
+	assert.Equal(t, filepath.Join(target, "kept"), dir.CurrentPath("kept"))
+
+	err = dir.DropUnchanged()
+	require.NoError(t, err)
+
+	promoted, err := dir.Promote()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"added", "changed", filepath.Join("sub", "nested")}, promoted)
+
+	require.NoError(t, dir.Remove())
+
+	entries, err := os.ReadDir(target)
+	require.NoError(t, err)
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name()
+	}
+	assert.Equal(t, []string{"added", "changed", "kept", "sub", "unchanged"}, names)
+	assert.FileExists(t, filepath.Join(target, "sub", "nested"))
+	content, err := os.ReadFile(filepath.Join(target, "changed"))
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(content))
+}
+
+func Test_filesEqual(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	write := func(name string, content []byte) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, content, 0o600))
+		return path
+	}
+	large := make([]byte, 200*1024)
+	largeChanged := make([]byte, len(large))
+	largeChanged[len(largeChanged)-1] = 1
+
+	testCases := map[string]struct {
+		a, b  string
+		equal bool
+	}{
+		"equal":          {a: write("a", []byte("abc")), b: write("b", []byte("abc")), equal: true},
+		"different":      {a: write("c", []byte("abc")), b: write("d", []byte("abd"))},
+		"missing target": {a: write("e", []byte("abc")), b: filepath.Join(dir, "missing")},
+		"large equal":    {a: write("f", large), b: write("g", large), equal: true},
+		"large change":   {a: write("h", large), b: write("i", largeChanged)},
+		"empty":          {a: write("j", nil), b: write("k", nil), equal: true},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			equal, err := filesEqual(testCase.a, testCase.b)
+			require.NoError(t, err)
+			assert.Equal(t, testCase.equal, equal)
+		})
+	}
+}
@@ -0,0 +1,132 @@
+// Package transform provides declarative line transformations used
+// to clean up lines of list sources.
+package transform
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Step is a single line transformation.
+// Exactly one of its fields must be set.
+type Step struct {
+	// StripPrefix removes the given prefix from the line.
+	StripPrefix string `json:"stripPrefix,omitempty" yaml:"stripPrefix,omitempty"`
+	// StripSuffix removes the given suffix from the line.
+	StripSuffix string `json:"stripSuffix,omitempty" yaml:"stripSuffix,omitempty"`
+	// RegexExtract replaces the line with the first match of the
+	// regular expression. Lines not matching it are rejected.
+	RegexExtract string `json:"regexExtract,omitempty" yaml:"regexExtract,omitempty"`
+	// RejectPrefixes rejects lines starting with any of the prefixes.
+	RejectPrefixes []string `json:"rejectPrefixes,omitempty" yaml:"rejectPrefixes,omitempty"`
+	// RejectSuffixes rejects lines ending with any of the suffixes.
+	RejectSuffixes []string `json:"rejectSuffixes,omitempty" yaml:"rejectSuffixes,omitempty"`
+	// Reject rejects lines equal to any of the values.
+	Reject []string `json:"reject,omitempty" yaml:"reject,omitempty"`
+}
+
+var (
+	ErrStepEmpty         = errors.New("transform step has no field set")
+	ErrStepMultipleSet   = errors.New("transform step has more than one field set")
+	ErrRegexExtractParse = errors.New("regex extract expression is not valid")
+)
+
+type stepFunc func(line string) (transformed string, ok bool)
+
+// Pipeline is an ordered compiled list of transformation steps.
+// Its zero value is valid and leaves lines unchanged.
+type Pipeline struct {
+	funcs []stepFunc
+}
+
+// Compile validates and compiles the steps given into a [Pipeline].
+func Compile(steps []Step) (pipeline Pipeline, err error) {
+	pipeline.funcs = make([]stepFunc, len(steps))
+	for i, step := range steps {
+		pipeline.funcs[i], err = step.compile()
+		if err != nil {
+			return Pipeline{}, fmt.Errorf("step %d: %w", i+1, err)
+		}
+	}
+	return pipeline, nil
+}
+
+// Apply runs the line through all the steps of the pipeline,
+// and returns the transformed line and whether it is kept.
+func (p Pipeline) Apply(line string) (transformed string, ok bool) {
+	for _, f := range p.funcs {
+		line, ok = f(line)
+		if !ok {
+			return "", false
+		}
+	}
+	return line, true
+}
+
+func (s Step) compile() (f stepFunc, err error) {
+	fieldsSet := 0
+	for _, set := range []bool{
+		s.StripPrefix != "", s.StripSuffix != "", s.RegexExtract != "",
+		len(s.RejectPrefixes) > 0, len(s.RejectSuffixes) > 0, len(s.Reject) > 0,
+	} {
+		if set {
+			fieldsSet++
+		}
+	}
+	switch fieldsSet {
+	case 0:
+		return nil, ErrStepEmpty
+	case 1:
+	default:
+		return nil, ErrStepMultipleSet
+	}
+
+	switch {
+	case s.StripPrefix != "":
+		return func(line string) (string, bool) {
+			return strings.TrimPrefix(line, s.StripPrefix), true
+		}, nil
+	case s.StripSuffix != "":
+		return func(line string) (string, bool) {
+			return strings.TrimSuffix(line, s.StripSuffix), true
+		}, nil
+	case s.RegexExtract != "":
+		regex, err := regexp.Compile(s.RegexExtract)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrRegexExtractParse, err)
+		}
+		return func(line string) (string, bool) {
+			match := regex.FindString(line)
+			return match, match != ""
+		}, nil
+	case len(s.RejectPrefixes) > 0:
+		return func(line string) (string, bool) {
+			for _, prefix := range s.RejectPrefixes {
+				if strings.HasPrefix(line, prefix) {
+					return "", false
+				}
+			}
+			return line, true
+		}, nil
+	case len(s.RejectSuffixes) > 0:
+		return func(line string) (string, bool) {
+			for _, suffix := range s.RejectSuffixes {
+				if strings.HasSuffix(line, suffix) {
+					return "", false
+				}
+			}
+			return line, true
+		}, nil
+	default:
+		return func(line string) (string, bool) {
+			for _, value := range s.Reject {
+				if line == value {
+					return "", false
+				}
+			}
+			return line, true
+		}, nil
+	}
+}
@@ -0,0 +1,83 @@
+package transform
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Pipeline_Apply(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		steps       []Step
+		line        string
+		transformed string
+		ok          bool
+	}{
+		"no step": {
+			line:        "example.com",
+			transformed: "example.com",
+			ok:          true,
+		},
+		"strip prefix and suffix": {
+			steps: []Step{
+				{StripPrefix: "address=/"},
+				{StripSuffix: "/0.0.0.0"},
+			},
+			line:        "address=/example.com/0.0.0.0",
+			transformed: "example.com",
+			ok:          true,
+		},
+		"rejected prefix": {
+			steps: []Step{{RejectPrefixes: []string{"::1", "127.0.0.1 "}}},
+			line:  "127.0.0.1 localhost",
+		},
+		"rejected suffix": {
+			steps: []Step{{RejectSuffixes: []string{"/::"}}},
+			line:  "address=/example.com/::",
+		},
+		"rejected value": {
+			steps: []Step{{StripPrefix: "0.0.0.0 "}, {Reject: []string{"0.0.0.0"}}},
+			line:  "0.0.0.0",
+		},
+		"regex extract": {
+			steps:       []Step{{RegexExtract: `[0-9]+(\.[0-9]+){3}`}},
+			line:        "1.2.3.4\t5",
+			transformed: "1.2.3.4",
+			ok:          true,
+		},
+		"regex extract no match": {
+			steps: []Step{{RegexExtract: `[0-9]+(\.[0-9]+){3}`}},
+			line:  "not an ip",
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			pipeline, err := Compile(testCase.steps)
+			require.NoError(t, err)
+
+			transformed, ok := pipeline.Apply(testCase.line)
+			assert.Equal(t, testCase.transformed, transformed)
+			assert.Equal(t, testCase.ok, ok)
+		})
+	}
+}
+
+func Test_Compile(t *testing.T) {
+	t.Parallel()
+
+	_, err := Compile([]Step{{}})
+	require.ErrorIs(t, err, ErrStepEmpty)
+	assert.EqualError(t, err, "step 1: transform step has no field set")
+
+	_, err = Compile([]Step{{StripPrefix: "a", StripSuffix: "b"}})
+	require.ErrorIs(t, err, ErrStepMultipleSet)
+
+	_, err = Compile([]Step{{RegexExtract: "("}})
+	require.ErrorIs(t, err, ErrRegexExtractParse)
+}
@@ -0,0 +1,249 @@
+// Package unpack transparently decompresses and extracts list sources
+// published as gzip files, zip archives or tar archives.
+package unpack
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"path"
+	"strings"
+)
+
+// Info is the information used to detect the compression and archive
+// format of a content.
+type Info struct {
+	// Name is the file name or URL path of the content.
+	Name string
+	// ContentEncoding is the HTTP Content-Encoding header value, if any.
+	ContentEncoding string
+	// ContentType is the HTTP Content-Type header value, if any.
+	ContentType string
+}
+
+var (
+	ErrMembersNotArchive = errors.New("members are set but content is not an archive")
+	ErrMemberNotFound    = errors.New("no archive member matches")
+	ErrMemberPattern     = errors.New("archive member pattern is malformed")
+)
+
+// Open returns a reader of the content read from the reader given,
+// decompressed and extracted according to the gzip, zip or tar format
+// detected from the information given and confirmed with the content
+// magic bytes. Tar archives, compressed or not, are detected using their
+// magic bytes only. Content in none of these formats is returned as is.
+// For archives, the regular files matching any of the members patterns,
+// as defined by [path.Match], are concatenated and separated by a new line.
+// If members is empty, all regular files of the archive are read.
+// Zip archives are fully read in memory since their index is at their end.
+// The reader returned must be closed by the caller, which also closes
+// the reader given. The reader given is closed if an error is returned.
+func Open(reader io.ReadCloser, info Info, members []string) (
+	readCloser io.ReadCloser, err error,
+) {
+	readCloser, err = open(reader, info, members)
+	if err != nil {
+		_ = reader.Close()
+		return nil, err
+	}
+	return &closer{ReadCloser: readCloser, underlying: reader}, nil
+}
+
+// closer closes both the unpacking reader and the underlying reader.
+type closer struct {
+	io.ReadCloser
+	underlying io.Closer
+}
+
+func (c *closer) Close() error {
+	return errors.Join(c.ReadCloser.Close(), c.underlying.Close())
+}
+
+func open(reader io.Reader, info Info, members []string) (
+	readCloser io.ReadCloser, err error,
+) {
+	for _, pattern := range members {
+		_, err = path.Match(pattern, "")
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrMemberPattern, pattern)
+		}
+	}
+
+	name := strings.ToLower(info.Name)
+	mediaType, _, _ := mime.ParseMediaType(info.ContentType)
+	buffered := bufio.NewReader(reader)
+
+	isGzip := isGzipEncoding(info.ContentEncoding) || isGzipType(mediaType) ||
+		strings.HasSuffix(name, ".gz") || strings.HasSuffix(name, ".tgz")
+	if isGzip && hasMagic(buffered, 0, gzipMagic) {
+		gzipReader, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("creating gzip reader: %w", err)
+		}
+		buffered = bufio.NewReader(gzipReader)
+	}
+
+	switch {
+	case (mediaType == "application/zip" || strings.HasSuffix(name, ".zip")) &&
+		hasMagic(buffered, 0, zipMagic):
+		return openZip(buffered, members)
+	case hasMagic(buffered, tarMagicOffset, tarMagic):
+		return openTar(buffered, members), nil
+	}
+
+	if len(members) > 0 {
+		return nil, ErrMembersNotArchive
+	}
+	return io.NopCloser(buffered), nil
+}
+
+//nolint:gochecknoglobals
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zipMagic  = []byte("PK\x03\x04")
+	tarMagic  = []byte("ustar")
+)
+
+// tarMagicOffset is the offset of the magic bytes in a tar archive.
+const tarMagicOffset = 257
+
+func hasMagic(reader *bufio.Reader, offset int, magic []byte) bool {
+	data, _ := reader.Peek(offset + len(magic))
+	return len(data) == offset+len(magic) && bytes.Equal(data[offset:], magic)
+}
+
+func isGzipEncoding(contentEncoding string) bool {
+	contentEncoding = strings.ToLower(strings.TrimSpace(contentEncoding))
+	return contentEncoding == "gzip" || contentEncoding == "x-gzip"
+}
+
+func isGzipType(mediaType string) bool {
+	return mediaType == "application/gzip" || mediaType == "application/x-gzip"
+}
+
+func matchMember(name string, members []string) bool {
+	if len(members) == 0 {
+		return true
+	}
+	for _, pattern := range members {
+		matched, _ := path.Match(pattern, name)
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+func openZip(reader io.Reader, members []string) (readCloser io.ReadCloser, err error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("reading zip archive: %w", err)
+	}
+
+	zipReader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("opening zip archive: %w", err)
+	}
+
+	var files []*zip.File
+	for _, file := range zipReader.File {
+		if !file.Mode().IsRegular() || !matchMember(file.Name, members) {
+			continue
+		}
+		files = append(files, file)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrMemberNotFound, strings.Join(members, ", "))
+	}
+
+	return Concat(func() (member io.ReadCloser, err error) {
+		if len(files) == 0 {
+			return nil, io.EOF
+		}
+		file := files[0]
+		files = files[1:]
+		member, err = file.Open()
+		if err != nil {
+			return nil, fmt.Errorf("opening zip member %s: %w", file.Name, err)
+		}
+		return member, nil
+	}), nil
+}
+
+func openTar(reader io.Reader, members []string) (readCloser io.ReadCloser) {
+	tarReader := tar.NewReader(reader)
+	found := false
+	return Concat(func() (member io.ReadCloser, err error) {
+		for {
+			header, err := tarReader.Next()
+			switch {
+			case errors.Is(err, io.EOF) && !found:
+				return nil, fmt.Errorf("%w: %s", ErrMemberNotFound, strings.Join(members, ", "))
+			case err != nil:
+				return nil, err
+			case !header.FileInfo().Mode().IsRegular() || !matchMember(header.Name, members):
+				continue
+			}
+			found = true
+			return io.NopCloser(tarReader), nil
+		}
+	})
+}
+
+// Concat returns a reader reading the readers returned by next one after
+// the other, separated by a new line, until next returns [io.EOF]. Each
+// reader returned by next is closed once fully read. Closing the reader
+// returned closes the reader being read, if any.
+func Concat(next func() (reader io.ReadCloser, err error)) io.ReadCloser {
+	return &concatReader{next: next}
+}
+
+type concatReader struct {
+	next    func() (member io.ReadCloser, err error)
+	current io.ReadCloser
+	started bool
+}
+
+func (m *concatReader) Read(p []byte) (n int, err error) {
+	for {
+		if m.current == nil {
+			m.current, err = m.next()
+			if err != nil {
+				return 0, err
+			}
+			if m.started && len(p) > 0 {
+				p[0] = '\n'
+				return 1, nil
+			}
+			m.started = true
+		}
+
+		n, err = m.current.Read(p)
+		if errors.Is(err, io.EOF) {
+			closeErr := m.current.Close()
+			m.current = nil
+			if closeErr != nil {
+				return n, closeErr
+			}
+			err = nil
+		}
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+}
+
+func (m *concatReader) Close() (err error) {
+	if m.current == nil {
+		return nil
+	}
+	err = m.current.Close()
+	m.current = nil
+	return err
+}
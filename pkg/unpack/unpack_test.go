@@ -0,0 +1,170 @@
+package unpack
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type file struct {
+	name    string
+	content string
+}
+
+func makeGzip(t *testing.T, data []byte) []byte {
+	t.Helper()
+	buffer := bytes.NewBuffer(nil)
+	writer := gzip.NewWriter(buffer)
+	_, err := writer.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	return buffer.Bytes()
+}
+
+func makeTar(t *testing.T, files []file) []byte {
+	t.Helper()
+	buffer := bytes.NewBuffer(nil)
+	writer := tar.NewWriter(buffer)
+	err := writer.WriteHeader(&tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0o755})
+	require.NoError(t, err)
+	for _, file := range files {
+		err := writer.WriteHeader(&tar.Header{
+			Name:     file.name,
+			Typeflag: tar.TypeReg,
+			Mode:     0o644,
+			Size:     int64(len(file.content)),
+		})
+		require.NoError(t, err)
+		_, err = writer.Write([]byte(file.content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, writer.Close())
+	return buffer.Bytes()
+}
+
+func makeZip(t *testing.T, files []file) []byte {
+	t.Helper()
+	buffer := bytes.NewBuffer(nil)
+	writer := zip.NewWriter(buffer)
+	for _, file := range files {
+		fileWriter, err := writer.Create(file.name)
+		require.NoError(t, err)
+		_, err = fileWriter.Write([]byte(file.content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, writer.Close())
+	return buffer.Bytes()
+}
+
+func Test_Open(t *testing.T) {
+	t.Parallel()
+
+	files := []file{
+		{name: "dir/a.txt", content: "a.com\nb.com"},
+		{name: "dir/b.txt", content: "c.com\n"},
+		{name: "readme.md", content: "readme"},
+	}
+
+	testCases := map[string]struct {
+		data       []byte
+		info       Info
+		members    []string
+		content    string
+		errWrapped error
+		errMessage string
+	}{
+		"plain": {
+			data:    []byte("a.com\n"),
+			info:    Info{Name: "/list.txt"},
+			content: "a.com\n",
+		},
+		"plain with gz extension": {
+			data:    []byte("a.com\n"),
+			info:    Info{Name: "/list.gz"},
+			content: "a.com\n",
+		},
+		"gzip extension": {
+			data:    makeGzip(t, []byte("a.com\n")),
+			info:    Info{Name: "/list.txt.gz"},
+			content: "a.com\n",
+		},
+		"gzip content type": {
+			data:    makeGzip(t, []byte("a.com\n")),
+			info:    Info{Name: "/download", ContentType: "application/gzip"},
+			content: "a.com\n",
+		},
+		"gzip content encoding": {
+			data:    makeGzip(t, []byte("a.com\n")),
+			info:    Info{ContentEncoding: "gzip"},
+			content: "a.com\n",
+		},
+		"tar gz members": {
+			data:    makeGzip(t, makeTar(t, files)),
+			info:    Info{Name: "/lists.tar.gz"},
+			members: []string{"dir/*.txt"},
+			content: "a.com\nb.com\nc.com\n",
+		},
+		"tar all members": {
+			data:    makeTar(t, files),
+			info:    Info{Name: "/lists.tar"},
+			content: "a.com\nb.com\nc.com\n\nreadme",
+		},
+		"tar member not found": {
+			data:       makeTar(t, files),
+			info:       Info{Name: "/lists.tar"},
+			members:    []string{"x.txt"},
+			errWrapped: ErrMemberNotFound,
+			errMessage: "no archive member matches: x.txt",
+		},
+		"zip members": {
+			data:    makeZip(t, files),
+			info:    Info{Name: "/lists.zip"},
+			members: []string{"readme.md", "dir/b.txt"},
+			content: "c.com\n\nreadme",
+		},
+		"zip member not found": {
+			data:       makeZip(t, files),
+			info:       Info{ContentType: "application/zip"},
+			members:    []string{"x.txt"},
+			errWrapped: ErrMemberNotFound,
+			errMessage: "no archive member matches: x.txt",
+		},
+		"members for plain content": {
+			data:       []byte("a.com\n"),
+			members:    []string{"a.txt"},
+			errWrapped: ErrMembersNotArchive,
+			errMessage: "members are set but content is not an archive",
+		},
+		"malformed member pattern": {
+			data:       makeTar(t, files),
+			members:    []string{"["},
+			errWrapped: ErrMemberPattern,
+			errMessage: `archive member pattern is malformed: "["`,
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			reader, err := Open(io.NopCloser(bytes.NewReader(testCase.data)), testCase.info, testCase.members)
+			if err == nil {
+				var content []byte
+				content, err = io.ReadAll(reader)
+				assert.Equal(t, testCase.content, string(content))
+				require.NoError(t, reader.Close())
+			}
+
+			assert.ErrorIs(t, err, testCase.errWrapped)
+			if testCase.errWrapped != nil {
+				assert.EqualError(t, err, testCase.errMessage)
+			}
+		})
+	}
+}